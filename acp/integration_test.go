@@ -16,7 +16,9 @@ import (
 
 	"github.com/elek/rai/config"
 	"github.com/elek/rai/llm"
+	"github.com/elek/rai/session"
 	"github.com/elek/rai/templates"
+	"github.com/elek/rai/tool"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -91,14 +93,15 @@ func (c *acpClient) readUntilResponse(id int) (Response, []Notification) {
 				continue
 			}
 			// Distinguish responses (have "id") from notifications (have "method", no "id").
+			// Requests of the server (have both) are skipped.
 			var probe struct {
 				ID     json.RawMessage `json:"id"`
 				Method string          `json:"method"`
 			}
 			_ = json.Unmarshal(line, &probe)
-			if probe.ID == nil && probe.Method != "" {
+			if probe.Method != "" {
 				var n Notification
-				if err := json.Unmarshal(line, &n); err == nil {
+				if err := json.Unmarshal(line, &n); err == nil && probe.ID == nil {
 					notifs = append(notifs, n)
 				}
 				continue
@@ -122,6 +125,27 @@ func (c *acpClient) readUntilResponse(id int) (Response, []Notification) {
 	return resp, notifs
 }
 
+// readUntilRequest reads messages until the server sends a request with the
+// given method, and returns it with the notifications seen along the way.
+func (c *acpClient) readUntilRequest(method string) (Request, []Notification) {
+	c.t.Helper()
+	var notifs []Notification
+	for c.scanner.Scan() {
+		var req Request
+		require.NoError(c.t, json.Unmarshal(c.scanner.Bytes(), &req))
+		if req.ID != nil && req.Method == method {
+			return req, notifs
+		}
+		if req.ID == nil && req.Method != "" {
+			var n Notification
+			require.NoError(c.t, json.Unmarshal(c.scanner.Bytes(), &n))
+			notifs = append(notifs, n)
+		}
+	}
+	c.t.Fatalf("did not receive a %s request", method)
+	return Request{}, nil
+}
+
 // drivePromptFlow runs the full ACP conversation against the given client:
 // initialize -> session/new -> session/prompt, and asserts that the agent
 // streams text back and reports a normal end of turn.
@@ -264,6 +288,163 @@ func TestACPFullPromptFlow(t *testing.T) {
 	drivePromptFlow(t, client)
 }
 
+// TestACPPromptsShareConversation sends two prompts to the same session and
+// checks that the second one continues the conversation of the first instead of
+// starting from an empty history.
+func TestACPPromptsShareConversation(t *testing.T) {
+	srv := NewServer(nil)
+	srv.SetConfig(fakeConfig())
+
+	client := newACPClient(t, srv)
+	defer client.close()
+
+	client.send(`{"jsonrpc":"2.0","id":1,"method":"session/new","params":{"cwd":"/tmp","mcpServers":[]}}`)
+	sessResp, _ := client.readUntilResponse(1)
+	require.Nil(t, sessResp.Error)
+	resultBytes, err := json.Marshal(sessResp.Result)
+	require.NoError(t, err)
+	var sessResult NewSessionResult
+	require.NoError(t, json.Unmarshal(resultBytes, &sessResult))
+
+	for id, prompt := range []string{"first question", "second question"} {
+		client.send(`{"jsonrpc":"2.0","id":` + strconv.Itoa(id+2) + `,"method":"session/prompt","params":{"sessionId":"` +
+			sessResult.SessionID + `","prompt":[{"type":"text","text":"` + prompt + `"}]}}`)
+		promptResp, _ := client.readUntilResponse(id + 2)
+		require.Nil(t, promptResp.Error)
+	}
+
+	srv.mu.Lock()
+	sess := srv.sessions[sessResult.SessionID]
+	srv.mu.Unlock()
+	require.NotNil(t, sess.Conversation)
+	messages := sess.Conversation.Messages()
+	require.Len(t, messages, 4)
+	assert.Equal(t, llm.RoleUser, messages[2].Role)
+	assert.Equal(t, "second question", messages[2].Blocks[0].Text)
+}
+
+// TestACPStreamsThinkingAsThoughtChunks checks that the model's reasoning is
+// forwarded as agent_thought_chunk updates, ahead of the answer.
+func TestACPStreamsThinkingAsThoughtChunks(t *testing.T) {
+	srv := NewServer(nil)
+	srv.SetConfig(fakeConfig())
+
+	client := newACPClient(t, srv)
+	defer client.close()
+
+	client.send(`{"jsonrpc":"2.0","id":1,"method":"session/new","params":{"cwd":"/tmp","mcpServers":[]}}`)
+	sessResp, _ := client.readUntilResponse(1)
+	require.Nil(t, sessResp.Error)
+	resultBytes, err := json.Marshal(sessResp.Result)
+	require.NoError(t, err)
+	var sessResult NewSessionResult
+	require.NoError(t, json.Unmarshal(resultBytes, &sessResult))
+
+	client.send(`{"jsonrpc":"2.0","id":2,"method":"session/prompt","params":{"sessionId":"` +
+		sessResult.SessionID + `","prompt":[{"type":"text","text":"[think] then answer"}]}}`)
+	promptResp, notifs := client.readUntilResponse(2)
+	require.Nil(t, promptResp.Error)
+
+	var kinds []string
+	for _, n := range notifs {
+		paramBytes, err := json.Marshal(n.Params)
+		require.NoError(t, err)
+		var upd SessionUpdateNotification
+		require.NoError(t, json.Unmarshal(paramBytes, &upd))
+		if len(kinds) == 0 || kinds[len(kinds)-1] != upd.Update.SessionUpdate {
+			kinds = append(kinds, upd.Update.SessionUpdate)
+		}
+	}
+	assert.Equal(t, []string{"agent_thought_chunk", "agent_message_chunk"}, kinds)
+}
+
+// TestACPLoadStoredSession stores a session through one server, then loads it
+// in a fresh server: the history is replayed as notifications and the next
+// prompt continues the stored conversation.
+func TestACPLoadStoredSession(t *testing.T) {
+	store := session.NewStore(t.TempDir())
+
+	first := NewServer(nil)
+	first.SetConfig(fakeConfig())
+	first.SetStore(store)
+	client := newACPClient(t, first)
+	client.send(`{"jsonrpc":"2.0","id":1,"method":"session/new","params":{"cwd":"/tmp","mcpServers":[]}}`)
+	sessResp, _ := client.readUntilResponse(1)
+	require.Nil(t, sessResp.Error)
+	resultBytes, err := json.Marshal(sessResp.Result)
+	require.NoError(t, err)
+	var sessResult NewSessionResult
+	require.NoError(t, json.Unmarshal(resultBytes, &sessResult))
+	client.send(`{"jsonrpc":"2.0","id":2,"method":"session/prompt","params":{"sessionId":"` +
+		sessResult.SessionID + `","prompt":[{"type":"text","text":"remember this"}]}}`)
+	promptResp, _ := client.readUntilResponse(2)
+	require.Nil(t, promptResp.Error)
+	client.close()
+
+	stored, err := store.Load(sessResult.SessionID)
+	require.NoError(t, err)
+	require.Len(t, stored.Messages, 2)
+
+	second := NewServer(nil)
+	second.SetConfig(fakeConfig())
+	second.SetStore(store)
+	client = newACPClient(t, second)
+	defer client.close()
+
+	client.send(`{"jsonrpc":"2.0","id":1,"method":"session/load","params":{"sessionId":"` +
+		sessResult.SessionID + `","cwd":"/tmp","mcpServers":[]}}`)
+	loadResp, notifs := client.readUntilResponse(1)
+	require.Nil(t, loadResp.Error)
+
+	var replayed []string
+	for _, n := range notifs {
+		paramBytes, err := json.Marshal(n.Params)
+		require.NoError(t, err)
+		var upd SessionUpdateNotification
+		require.NoError(t, json.Unmarshal(paramBytes, &upd))
+		replayed = append(replayed, upd.Update.SessionUpdate)
+	}
+	assert.Equal(t, []string{"user_message_chunk", "agent_message_chunk"}, replayed)
+
+	client.send(`{"jsonrpc":"2.0","id":2,"method":"session/prompt","params":{"sessionId":"` +
+		sessResult.SessionID + `","prompt":[{"type":"text","text":"what did I say?"}]}}`)
+	promptResp, _ = client.readUntilResponse(2)
+	require.Nil(t, promptResp.Error)
+
+	stored, err = store.Load(sessResult.SessionID)
+	require.NoError(t, err)
+	require.Len(t, stored.Messages, 4)
+	assert.Equal(t, "remember this", stored.Messages[0].Blocks[0].Text)
+}
+
+func TestACPPromptEndsWhenTheSessionCantBeSaved(t *testing.T) {
+	notDir := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(notDir, nil, 0o644))
+
+	srv := NewServer(nil)
+	srv.SetConfig(fakeConfig())
+	srv.SetStore(session.NewStore(filepath.Join(notDir, "sessions")))
+	client := newACPClient(t, srv)
+	defer client.close()
+	client.send(`{"jsonrpc":"2.0","id":1,"method":"session/new","params":{"cwd":"/tmp","mcpServers":[]}}`)
+	sessResp, _ := client.readUntilResponse(1)
+	require.Nil(t, sessResp.Error)
+	resultBytes, err := json.Marshal(sessResp.Result)
+	require.NoError(t, err)
+	var sessResult NewSessionResult
+	require.NoError(t, json.Unmarshal(resultBytes, &sessResult))
+
+	client.send(`{"jsonrpc":"2.0","id":2,"method":"session/prompt","params":{"sessionId":"` +
+		sessResult.SessionID + `","prompt":[{"type":"text","text":"hello"}]}}`)
+	promptResp, notifs := client.readUntilResponse(2)
+	require.Nil(t, promptResp.Error)
+	assert.Equal(t, "end_turn", promptResp.Result.(map[string]any)["stopReason"])
+
+	last, err := json.Marshal(notifs[len(notifs)-1].Params)
+	require.NoError(t, err)
+	assert.Contains(t, string(last), "Warning: session couldn't be saved")
+}
+
 // TestACPSubprocessPromptFlow is the real end-to-end test: it compiles the rai
 // binary, launches `rai acp` as a subprocess, and drives the ACP protocol over
 // the process's actual stdin/stdout - exactly what an ACP client like `acpp`
@@ -336,3 +517,97 @@ models:
 	require.NoError(t, os.WriteFile(filepath.Join(cfgDir, "config.yaml"), []byte(cfg), 0o644))
 	return home
 }
+
+// TestACPRequestsPermission configures git calls to need approval (except git
+// add) and checks that the server asks the client with
+// session/request_permission, and doesn't run the rejected call.
+func TestACPRequestsPermission(t *testing.T) {
+	var mu sync.Mutex
+	var commands []string
+	gitTool := llm.NewTool[gitToolCommandInput]("git", "Execute any git command",
+		func(_ context.Context, in gitToolCommandInput) (string, error) {
+			mu.Lock()
+			commands = append(commands, in.Command)
+			mu.Unlock()
+			return "ok", nil
+		})
+
+	cfg := fakeConfig()
+	cfg.Permissions = config.Permissions{Rules: []config.PermissionRule{
+		{Tool: "git", Pattern: "git add *", Action: "allow"},
+		{Tool: "git", Action: "ask"},
+	}}
+	srv := NewServer(&templates.ParsedTemplate{Tools: []llm.Tool{gitTool}})
+	srv.SetConfig(cfg)
+
+	client := newACPClient(t, srv)
+	defer client.close()
+
+	client.send(`{"jsonrpc":"2.0","id":1,"method":"session/new","params":{"cwd":"/tmp","mcpServers":[]}}`)
+	sessResp, _ := client.readUntilResponse(1)
+	require.Nil(t, sessResp.Error)
+	resultBytes, err := json.Marshal(sessResp.Result)
+	require.NoError(t, err)
+	var sessResult NewSessionResult
+	require.NoError(t, json.Unmarshal(resultBytes, &sessResult))
+
+	client.send(`{"jsonrpc":"2.0","id":2,"method":"session/prompt","params":{"sessionId":"` +
+		sessResult.SessionID + `","prompt":[{"type":"text","text":"please commit my work"}]}}`)
+
+	req, notifs := client.readUntilRequest("session/request_permission")
+	var params RequestPermissionParams
+	require.NoError(t, json.Unmarshal(req.Params, &params))
+	assert.Equal(t, sessResult.SessionID, params.SessionID)
+	assert.Equal(t, "fake-tool-2", params.ToolCall.ToolCallID)
+	var announced []string
+	for _, n := range notifs {
+		b, err := json.Marshal(n.Params)
+		require.NoError(t, err)
+		var upd SessionUpdateNotification
+		require.NoError(t, json.Unmarshal(b, &upd))
+		if upd.Update.SessionUpdate == "tool_call" {
+			announced = append(announced, upd.Update.ToolCallID)
+		}
+	}
+	assert.Contains(t, announced, params.ToolCall.ToolCallID, "the permission is asked for a tool call the client was told about")
+	assert.Equal(t, "execute", params.ToolCall.Kind)
+	assert.Contains(t, params.ToolCall.Title, "git commit -m")
+	assert.Len(t, params.Options, 4)
+
+	client.send(`{"jsonrpc":"2.0","id":` + string(req.ID) + `,"result":{"outcome":{"outcome":"selected","optionId":"reject_once"}}}`)
+	promptResp, _ := client.readUntilResponse(2)
+	require.Nil(t, promptResp.Error)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"git add -A"}, commands, "the rejected commit must not run")
+}
+
+// TestACPStopsBackgroundProcessesWhenTheConnectionEnds starts a background
+// process in a session and checks it is stopped once the client goes away.
+func TestACPStopsBackgroundProcessesWhenTheConnectionEnds(t *testing.T) {
+	srv := NewServer(nil)
+	srv.SetConfig(fakeConfig())
+
+	client := newACPClient(t, srv)
+	client.send(`{"jsonrpc":"2.0","id":1,"method":"session/new","params":{"cwd":"/tmp","mcpServers":[]}}`)
+	sessResp, _ := client.readUntilResponse(1)
+	require.Nil(t, sessResp.Error)
+	resultBytes, err := json.Marshal(sessResp.Result)
+	require.NoError(t, err)
+	var sessResult NewSessionResult
+	require.NoError(t, json.Unmarshal(resultBytes, &sessResult))
+
+	srv.mu.Lock()
+	processes := srv.sessions[sessResult.SessionID].processes
+	srv.mu.Unlock()
+	ctx := tool.ContextWithProcesses(context.Background(), processes)
+	_, err = tool.ProcessStart(ctx, tool.ProcessStartInput{Command: "sleep 30"})
+	require.NoError(t, err)
+
+	client.close()
+	require.Eventually(t, func() bool {
+		out, err := tool.ProcessOutput(ctx, tool.ProcessOutputInput{ID: 1})
+		return err == nil && !strings.Contains(out, "running")
+	}, 10*time.Second, 50*time.Millisecond)
+}
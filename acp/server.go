@@ -7,12 +7,15 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"sync"
+	"time"
 
-	"github.com/elek/catwalk-open/providers"
 	"github.com/elek/rai/config"
 	"github.com/elek/rai/llm"
+	"github.com/elek/rai/session"
 	"github.com/elek/rai/templates"
+	"github.com/elek/rai/tool"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 )
@@ -27,6 +30,36 @@ type Session struct {
 	TemplatePrompt string
 	FirstPrompt    bool
 	Cancel         context.CancelFunc
+	// Conversation carries the message history across session/prompt calls. It
+	// is created on the first prompt, together with the model behind it.
+	Conversation *llm.Conversation
+	lm           llm.Model
+	// record is the stored form of the session; it is saved after every
+	// prompt when the server has a session store.
+	record *session.Session
+	// workspace confines the tools of the session.
+	workspace *tool.Workspace
+	// processes are the background processes started by the tools of the
+	// session; they are stopped when the session ends.
+	processes *tool.Processes
+	// mcpClients are the MCP servers the client passed for the session; they
+	// are closed when the session ends.
+	mcpClients []*tool.McpClient
+	// stopMcp cancels the connections of the MCP servers.
+	stopMcp context.CancelFunc
+}
+
+// close stops the background processes and the MCP servers of the session.
+func (sess *Session) close() {
+	if sess.processes != nil {
+		sess.processes.Close()
+	}
+	for _, c := range sess.mcpClients {
+		c.Close()
+	}
+	if sess.stopMcp != nil {
+		sess.stopMcp()
+	}
 }
 
 // Server implements the ACP JSON-RPC 2.0 stdio server.
@@ -34,10 +67,25 @@ type Server struct {
 	cfg          *config.Config
 	parsed       *templates.ParsedTemplate
 	defaultModel *config.Model
+	store        *session.Store
+	workspace    string
+	sandbox      bool
 	sessions     map[string]*Session
 	mu           sync.Mutex
 	out          io.Writer
 	outMu        sync.Mutex
+	// pending holds the requests sent to the client that wait for a response,
+	// by id. It is nil once the client has gone away.
+	pending map[string]chan message
+	nextID  int
+}
+
+// message is an incoming JSON-RPC message: a request or notification from the
+// client, or a response to a request the server sent (which has no method).
+type message struct {
+	Request
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *RPCError       `json:"error,omitempty"`
 }
 
 // NewServer creates a new ACP server with the given parsed template.
@@ -58,6 +106,36 @@ func (s *Server) SetDefaultModel(m config.Model) {
 	s.defaultModel = &m
 }
 
+// SetWorkspace confines the tools of every session to root: the session's cwd
+// when root is empty. With sandbox, bash commands run without network access.
+func (s *Server) SetWorkspace(root string, sandbox bool) {
+	s.workspace = root
+	s.sandbox = sandbox
+}
+
+// newWorkspace returns the workspace of a session started in cwd, or nil when
+// neither the server nor the session names a directory.
+func (s *Server) newWorkspace(cwd string) (*tool.Workspace, *RPCError) {
+	root := s.workspace
+	if root == "" {
+		root = cwd
+	}
+	if root == "" {
+		return nil, nil
+	}
+	ws, err := tool.NewWorkspace(root, s.sandbox)
+	if err != nil {
+		return nil, &RPCError{Code: -32602, Message: "Invalid params: " + err.Error()}
+	}
+	return ws, nil
+}
+
+// SetStore makes the server persist every session in store, and enables
+// session/load for the sessions kept there (including ones started on the CLI).
+func (s *Server) SetStore(store *session.Store) {
+	s.store = store
+}
+
 // Serve reads JSON-RPC messages from os.Stdin and writes responses to os.Stdout.
 func (s *Server) Serve() error {
 	return s.ServeIO(os.Stdin, os.Stdout)
@@ -65,8 +143,39 @@ func (s *Server) Serve() error {
 
 // ServeIO reads JSON-RPC messages from the given reader and writes responses to the given writer.
 // Messages are newline-delimited JSON.
+//
+// Prompts are handled one after the other in the background, so that the
+// client can cancel them, and answer the requests the server sends while a
+// prompt runs (such as session/request_permission). Sessions are set up in the
+// background as well, since starting their MCP servers may take a while.
+// ServeIO returns once the input is closed and the prompts and sessions
+// received so far are done.
 func (s *Server) ServeIO(in io.Reader, out io.Writer) error {
 	s.out = out
+	s.mu.Lock()
+	s.pending = map[string]chan message{}
+	s.mu.Unlock()
+
+	prompts := make(chan Request, 16)
+	done := make(chan struct{})
+	// setups are the session/new and session/load requests being handled;
+	// they may wait for MCP servers to start, so they don't hold up the
+	// other messages.
+	var setups sync.WaitGroup
+	go func() {
+		defer close(done)
+		for req := range prompts {
+			s.respond(req)
+		}
+	}()
+	defer func() {
+		close(prompts)
+		s.closePending()
+		<-done
+		setups.Wait()
+		s.closeSessions()
+	}()
+
 	scanner := bufio.NewScanner(in)
 	scanner.Buffer(make([]byte, 0, 1024*1024), 10*1024*1024)
 
@@ -76,33 +185,114 @@ func (s *Server) ServeIO(in io.Reader, out io.Writer) error {
 			continue
 		}
 
-		var req Request
-		if err := json.Unmarshal(line, &req); err != nil {
+		var msg message
+		if err := json.Unmarshal(line, &msg); err != nil {
 			s.sendError(nil, -32700, "Parse error", err)
 			continue
 		}
 
-		if req.ID == nil {
-			s.handleNotification(req)
-			continue
+		switch {
+		case msg.ID == nil:
+			s.handleNotification(msg.Request)
+		case msg.Method == "":
+			s.handleResponse(msg)
+		case msg.Method == "session/prompt":
+			prompts <- msg.Request
+		case msg.Method == "session/new" || msg.Method == "session/load":
+			setups.Add(1)
+			go func(req Request) {
+				defer setups.Done()
+				s.respond(req)
+			}(msg.Request)
+		default:
+			s.respond(msg.Request)
 		}
+	}
+	return scanner.Err()
+}
 
-		result, rpcErr := s.handleRequest(req)
-		if rpcErr != nil {
-			s.sendResponse(Response{
-				JSONRPC: "2.0",
-				ID:      req.ID,
-				Error:   rpcErr,
-			})
-		} else {
-			s.sendResponse(Response{
-				JSONRPC: "2.0",
-				ID:      req.ID,
-				Result:  result,
-			})
+// respond handles a request and sends its response.
+func (s *Server) respond(req Request) {
+	result, rpcErr := s.handleRequest(req)
+	if rpcErr != nil {
+		s.sendResponse(Response{
+			JSONRPC: "2.0",
+			ID:      req.ID,
+			Error:   rpcErr,
+		})
+	} else {
+		s.sendResponse(Response{
+			JSONRPC: "2.0",
+			ID:      req.ID,
+			Result:  result,
+		})
+	}
+}
+
+// request sends a request to the client and waits for its response, whose
+// result is decoded into result.
+func (s *Server) request(ctx context.Context, method string, params any, result any) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	s.mu.Lock()
+	if s.pending == nil {
+		s.mu.Unlock()
+		return errors.New("client is gone")
+	}
+	s.nextID++
+	id := json.RawMessage(strconv.Itoa(s.nextID))
+	reply := make(chan message, 1)
+	s.pending[string(id)] = reply
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, string(id))
+		s.mu.Unlock()
+	}()
+
+	s.send(Request{JSONRPC: "2.0", ID: id, Method: method, Params: data})
+
+	select {
+	case resp := <-reply:
+		if resp.Error != nil {
+			return errors.Errorf("%s failed: %s", method, resp.Error.Message)
 		}
+		return errors.WithStack(json.Unmarshal(resp.Result, result))
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// handleResponse passes a response of the client to the request waiting for it.
+func (s *Server) handleResponse(msg message) {
+	s.mu.Lock()
+	reply, ok := s.pending[string(msg.ID)]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case reply <- msg:
+	default: // answered already
 	}
-	return scanner.Err()
+}
+
+// closePending fails the requests still waiting for the client, which won't
+// answer any more.
+func (s *Server) closePending() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, reply := range s.pending {
+		select {
+		case reply <- message{Error: &RPCError{Code: -32603, Message: "client is gone"}}:
+		default: // answered already
+		}
+	}
+	s.pending = nil
 }
 
 func (s *Server) handleRequest(req Request) (any, *RPCError) {
@@ -111,6 +301,8 @@ func (s *Server) handleRequest(req Request) (any, *RPCError) {
 		return s.handleInitialize(req)
 	case "session/new":
 		return s.handleNewSession(req)
+	case "session/load":
+		return s.handleLoadSession(req)
 	case "session/prompt":
 		return s.handlePrompt(req)
 	default:
@@ -127,10 +319,13 @@ func (s *Server) handleNotification(req Request) {
 		return
 	}
 	s.mu.Lock()
-	sess, ok := s.sessions[params.SessionID]
+	var cancel context.CancelFunc
+	if sess, ok := s.sessions[params.SessionID]; ok {
+		cancel = sess.Cancel
+	}
 	s.mu.Unlock()
-	if ok && sess.Cancel != nil {
-		sess.Cancel()
+	if cancel != nil {
+		cancel()
 	}
 }
 
@@ -138,9 +333,14 @@ func (s *Server) handleInitialize(_ Request) (any, *RPCError) {
 	return InitializeResult{
 		ProtocolVersion: 1,
 		AgentCapabilities: AgentCapabilities{
+			LoadSession: s.store != nil,
 			PromptCapabilities: &PromptCapabilities{
 				Text: true,
 			},
+			McpCapabilities: &McpCapabilities{
+				HTTP: true,
+				SSE:  true,
+			},
 		},
 		AgentInfo: ImplementationInfo{
 			Name:    "rai",
@@ -156,11 +356,18 @@ func (s *Server) handleNewSession(req Request) (any, *RPCError) {
 		return nil, &RPCError{Code: -32602, Message: "Invalid params: " + err.Error()}
 	}
 
+	ws, rpcErr := s.newWorkspace(params.Cwd)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
 	id := uuid.New().String()
 	sess := &Session{
 		ID:          id,
 		Cwd:         params.Cwd,
 		FirstPrompt: true,
+		workspace:   ws,
+		processes:   tool.NewProcesses(),
 	}
 	if s.parsed != nil {
 		sess.Model = s.parsed.Model
@@ -168,11 +375,202 @@ func (s *Server) handleNewSession(req Request) (any, *RPCError) {
 		sess.Tools = s.parsed.Tools
 		sess.TemplatePrompt = s.parsed.Prompt
 	}
+	if rpcErr := connectMcpServers(sess, params.McpServers); rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	s.addSession(sess)
+
+	return NewSessionResult{SessionID: id}, nil
+}
+
+func (s *Server) handleLoadSession(req Request) (any, *RPCError) {
+	var params LoadSessionParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return nil, &RPCError{Code: -32602, Message: "Invalid params: " + err.Error()}
+	}
+	if s.store == nil {
+		return nil, &RPCError{Code: -32601, Message: "Method not found: session/load"}
+	}
+
+	rec, err := s.store.Load(params.SessionID)
+	if err != nil {
+		return nil, &RPCError{Code: -32002, Message: "Session not found: " + err.Error()}
+	}
+	ws, rpcErr := s.newWorkspace(params.Cwd)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	sess := &Session{
+		ID:        rec.ID,
+		Cwd:       params.Cwd,
+		Model:     rec.Model,
+		System:    rec.System,
+		record:    rec,
+		workspace: ws,
+		processes: tool.NewProcesses(),
+	}
+	if s.parsed != nil {
+		sess.Tools = s.parsed.Tools
+	}
+	if rpcErr := connectMcpServers(sess, params.McpServers); rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	s.replay(sess.ID, rec.Messages)
+	s.addSession(sess)
+
+	return LoadSessionResult{}, nil
+}
+
+// mcpConnectTimeout bounds how long starting an MCP server of a session, and
+// listing its tools, may take.
+var mcpConnectTimeout = 30 * time.Second
+
+// connectMcpServers starts or connects to the MCP servers the client passed
+// for the session, and adds their tools to the ones of the session, prefixed
+// with the name of the server. Nothing is left running when it fails.
+func connectMcpServers(sess *Session, servers []McpServer) *RPCError {
+	if len(servers) == 0 {
+		return nil
+	}
+	tools := append([]llm.Tool{}, sess.Tools...)
+	names := map[string]bool{}
+	for _, t := range tools {
+		names[t.Info().Name] = true
+	}
+	// The connections live as long as the session (the SSE stream uses this
+	// context), so the timeout cancels it only while connecting.
+	ctx, cancel := context.WithCancel(context.Background())
+	sess.stopMcp = cancel
+	fail := func(rpcErr *RPCError) *RPCError {
+		for _, c := range sess.mcpClients {
+			c.Close()
+		}
+		sess.mcpClients = nil
+		cancel()
+		return rpcErr
+	}
+	for _, server := range servers {
+		ts, err := server.toolServer(sess.Cwd)
+		if err != nil {
+			return fail(&RPCError{Code: -32602, Message: "Invalid params: " + err.Error()})
+		}
+		timer := time.AfterFunc(mcpConnectTimeout, cancel)
+		client, err := tool.ConnectMcp(ctx, ts)
+		var serverTools []llm.Tool
+		if err == nil {
+			sess.mcpClients = append(sess.mcpClients, client)
+			serverTools, err = client.Tools(ctx)
+		}
+		if !timer.Stop() {
+			err = errors.Errorf("no answer in %s", mcpConnectTimeout)
+		}
+		if err != nil {
+			return fail(&RPCError{Code: -32603, Message: "Failed to start MCP server " + server.Name + ": " + err.Error()})
+		}
+		for _, t := range serverTools {
+			name := t.Info().Name
+			if names[name] {
+				return fail(&RPCError{Code: -32602, Message: "Invalid params: the tool " + name + " of MCP server " + server.Name + " is already defined"})
+			}
+			names[name] = true
+		}
+		tools = append(tools, serverTools...)
+	}
+	sess.Tools = tools
+	return nil
+}
+
+// toolServer converts the server for the tool package. Commands run in the
+// directory of the session. The name, which clients show to the user, prefixes
+// the tools; the tool package replaces the characters tool names can't have.
+func (m McpServer) toolServer(cwd string) (tool.McpServer, error) {
+	server := tool.McpServer{Alias: m.Name, Headers: map[string]string{}}
+	for _, h := range m.Headers {
+		server.Headers[h.Name] = h.Value
+	}
+	switch m.Type {
+	case "", "stdio":
+		if m.Command == "" {
+			return tool.McpServer{}, errors.Errorf("the MCP server %s needs a command", m.Name)
+		}
+		server.Command, server.Args, server.Dir = m.Command, m.Args, cwd
+		for _, e := range m.Env {
+			server.Env = append(server.Env, e.Name+"="+e.Value)
+		}
+	case "http", "sse":
+		if m.URL == "" {
+			return tool.McpServer{}, errors.Errorf("the MCP server %s needs a url", m.Name)
+		}
+		server.URL, server.Transport = m.URL, m.Type
+	default:
+		return tool.McpServer{}, errors.Errorf("unknown type %q of the MCP server %s", m.Type, m.Name)
+	}
+	return server, nil
+}
+
+// replay streams a stored conversation to the client as session/update
+// notifications, so the editor can show the history of a loaded session.
+func (s *Server) replay(id string, messages []llm.Message) {
+	for _, msg := range messages {
+		for _, b := range msg.Blocks {
+			var upd SessionUpdateParams
+			switch {
+			case b.Type == llm.BlockText && msg.Role == llm.RoleUser:
+				upd = SessionUpdateParams{SessionUpdate: "user_message_chunk", Content: &ContentBlock{Type: "text", Text: b.Text}}
+			case b.Type == llm.BlockText:
+				upd = SessionUpdateParams{SessionUpdate: "agent_message_chunk", Content: &ContentBlock{Type: "text", Text: b.Text}}
+			case b.Type == llm.BlockReasoning && b.Kind() == llm.ReasoningThinking:
+				// Other reasoning is opaque.
+				upd = SessionUpdateParams{SessionUpdate: "agent_thought_chunk", Content: &ContentBlock{Type: "text", Text: b.Text}}
+			case b.Type == llm.BlockToolUse:
+				upd = SessionUpdateParams{SessionUpdate: "tool_call", ToolCallID: b.ToolCallID, Title: b.ToolName, Kind: toolKind(b.ToolName), Status: "completed"}
+			default:
+				continue
+			}
+			s.sendNotification(Notification{
+				JSONRPC: "2.0",
+				Method:  "session/update",
+				Params:  SessionUpdateNotification{SessionID: id, Update: upd},
+			})
+		}
+	}
+}
 
+// closeSessions ends all sessions, stopping their background processes and
+// MCP servers.
+func (s *Server) closeSessions() {
 	s.mu.Lock()
-	s.sessions[id] = sess
+	sessions := s.sessions
+	s.sessions = make(map[string]*Session)
 	s.mu.Unlock()
 
+	var wg sync.WaitGroup
+	for _, sess := range sessions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sess.close()
+		}()
+	}
+	wg.Wait()
+}
+
+// addSession registers a session and announces its tools to the client.
+func (s *Server) addSession(sess *Session) {
+	s.mu.Lock()
+	old := s.sessions[sess.ID]
+	s.sessions[sess.ID] = sess
+	s.mu.Unlock()
+	if old != nil {
+		// The session was loaded again; the processes and MCP servers of the
+		// previous copy are not reachable any more.
+		go old.close()
+	}
+
+	id := sess.ID
 	if len(sess.Tools) > 0 {
 		var cmds []AvailableCommand
 		for _, t := range sess.Tools {
@@ -194,8 +592,6 @@ func (s *Server) handleNewSession(req Request) (any, *RPCError) {
 			},
 		})
 	}
-
-	return NewSessionResult{SessionID: id}, nil
 }
 
 func (s *Server) handlePrompt(req Request) (any, *RPCError) {
@@ -223,35 +619,26 @@ func (s *Server) handlePrompt(req Request) (any, *RPCError) {
 		sess.FirstPrompt = false
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx := tool.ContextWithProcesses(tool.ContextWithWorkspace(context.Background(), sess.workspace), sess.processes)
+	ctx, cancel := context.WithCancel(ctx)
+	s.mu.Lock()
 	sess.Cancel = cancel
+	s.mu.Unlock()
 	defer cancel()
 
 	if s.cfg == nil {
 		return nil, &RPCError{Code: -32603, Message: "Server not configured"}
 	}
 
-	model := sess.Model
-	if model == (config.Model{}) {
-		if s.defaultModel != nil {
-			model = *s.defaultModel
-		} else {
-			var found bool
-			model, found = s.cfg.FindDefaultModel()
-			if !found {
-				return nil, &RPCError{Code: -32603, Message: "No default model configured"}
-			}
+	if sess.Conversation == nil {
+		if rpcErr := s.startConversation(ctx, sess); rpcErr != nil {
+			return nil, rpcErr
 		}
 	}
+	lm := sess.lm
 
-	lm, err := llm.NewModel(ctx, *s.cfg, model)
-	if err != nil {
-		return nil, &RPCError{Code: -32603, Message: "Failed to create model: " + err.Error()}
-	}
-
-	agent := llm.NewAgent(lm, sess.System, sess.Tools)
-
-	result, err := agent.Run(ctx, promptText, llm.RunOptions{
+	result, err := sess.Conversation.Send(ctx, promptText, llm.RunOptions{
+		MaxParallel: s.cfg.MaxParallelTools,
 		OnTextDelta: func(token string) {
 			s.sendNotification(Notification{
 				JSONRPC: "2.0",
@@ -268,8 +655,28 @@ func (s *Server) handlePrompt(req Request) (any, *RPCError) {
 				},
 			})
 		},
-		OnToolCall: func(name, input string) {
-			tcID := uuid.New().String()
+		OnThinkingDelta: func(token string) {
+			s.sendNotification(Notification{
+				JSONRPC: "2.0",
+				Method:  "session/update",
+				Params: SessionUpdateNotification{
+					SessionID: params.SessionID,
+					Update: SessionUpdateParams{
+						SessionUpdate: "agent_thought_chunk",
+						Content: &ContentBlock{
+							Type: "text",
+							Text: token,
+						},
+					},
+				},
+			})
+		},
+		OnToolCall: func(id, name, input string) {
+			// The ID of the call is also the one of its permission request.
+			tcID := id
+			if tcID == "" {
+				tcID = uuid.New().String()
+			}
 			s.sendNotification(Notification{
 				JSONRPC: "2.0",
 				Method:  "session/update",
@@ -293,59 +700,165 @@ func (s *Server) handlePrompt(req Request) (any, *RPCError) {
 		return nil, &RPCError{Code: -32603, Message: "Agent error: " + err.Error()}
 	}
 
+	if s.store != nil {
+		sess.record.Messages = sess.Conversation.Messages()
+		if err := s.store.Save(sess.record); err != nil {
+			// The answer was streamed already, so the prompt still ends
+			// normally, with a warning like the one of the CLI.
+			s.sendNotification(Notification{
+				JSONRPC: "2.0",
+				Method:  "session/update",
+				Params: SessionUpdateNotification{
+					SessionID: params.SessionID,
+					Update: SessionUpdateParams{
+						SessionUpdate: "agent_message_chunk",
+						Content:       &ContentBlock{Type: "text", Text: "\n\nWarning: session couldn't be saved: " + err.Error()},
+					},
+				},
+			})
+		}
+	}
+
 	usage := result.Usage
 
 	modelID := lm.Name()
+	mu := &ModelUsageInfo{
+		InputTokens:              usage.InputTokens,
+		OutputTokens:             usage.OutputTokens,
+		CacheCreationInputTokens: usage.CacheCreationTokens,
+		CacheReadInputTokens:     usage.CacheReadTokens,
+	}
 	meta := &RaiMeta{
-		Model: modelID,
-		ModelUsage: map[string]*ModelUsageInfo{
-			modelID: {
-				InputTokens:  usage.InputTokens,
-				OutputTokens: usage.OutputTokens,
-			},
-		},
+		Model:      modelID,
+		ModelUsage: map[string]*ModelUsageInfo{modelID: mu},
 	}
-
-	for _, provider := range providers.GetAll() {
-		if string(provider.ID) != lm.Provider() {
-			continue
-		}
-		for _, m := range provider.Models {
-			if m.ID == modelID {
-				mu := meta.ModelUsage[modelID]
-				mu.ContextWindow = m.ContextWindow
-				mu.MaxOutputTokens = m.DefaultMaxTokens
-				mu.CostUSD = m.CostPer1MIn*float64(usage.InputTokens)/1_000_000 +
-					m.CostPer1MOut*float64(usage.OutputTokens)/1_000_000
-				meta.TotalCostUSD = mu.CostUSD
-				break
-			}
-		}
-		break
+	if m, found := llm.CatalogModel(lm.Provider(), modelID); found {
+		mu.ContextWindow = m.ContextWindow
+		mu.MaxOutputTokens = m.DefaultMaxTokens
+	}
+	if cost, known := llm.Cost(lm.Provider(), modelID, usage); known {
+		mu.CostUSD = cost
+		meta.TotalCostUSD = cost
 	}
 
+	stopReason := "end_turn"
+	if result.StopReason == llm.StopMaxTokens {
+		stopReason = "max_tokens"
+	}
 	return PromptResult{
-		StopReason: "end_turn",
+		StopReason: stopReason,
 		Usage: &UsageInfo{
-			InputTokens:  usage.InputTokens,
-			OutputTokens: usage.OutputTokens,
-			TotalTokens:  usage.TotalTokens,
+			InputTokens:         usage.InputTokens,
+			OutputTokens:        usage.OutputTokens,
+			TotalTokens:         usage.TotalTokens,
+			CacheCreationTokens: usage.CacheCreationTokens,
+			CacheReadTokens:     usage.CacheReadTokens,
 		},
 		Meta: meta,
 	}, nil
 }
 
+// startConversation resolves the session's model (the template's, then the
+// server default, then the configured default) and starts the conversation that
+// all prompts of the session continue.
+func (s *Server) startConversation(ctx context.Context, sess *Session) *RPCError {
+	model := sess.Model
+	if model == (config.Model{}) {
+		if s.defaultModel != nil {
+			model = *s.defaultModel
+		} else {
+			var found bool
+			model, found = s.cfg.FindDefaultModel()
+			if !found {
+				return &RPCError{Code: -32603, Message: "No default model configured"}
+			}
+		}
+	}
+
+	lm, err := llm.NewModel(ctx, *s.cfg, model)
+	if err != nil {
+		return &RPCError{Code: -32603, Message: "Failed to create model: " + err.Error()}
+	}
+
+	var history []llm.Message
+	if sess.record != nil {
+		history = sess.record.Messages
+	} else if s.store != nil {
+		sess.record = session.New(model, sess.System)
+		sess.record.ID = sess.ID
+	}
+
+	policy, err := llm.NewPolicy(s.cfg.Permissions)
+	if err != nil {
+		return &RPCError{Code: -32603, Message: "Invalid permissions: " + err.Error()}
+	}
+	tools := policy.Guard(sess.Tools, s.approver(sess.ID))
+
+	sess.lm = lm
+	sess.Conversation = llm.NewConversation(llm.NewAgent(lm, sess.System, tools), history)
+	return nil
+}
+
+// permissionOptions are the answers offered for every permission request; the
+// option ids are the approvals they stand for.
+var permissionOptions = []PermissionOption{
+	{OptionID: "allow_once", Name: "Allow", Kind: "allow_once"},
+	{OptionID: "allow_always", Name: "Always allow", Kind: "allow_always"},
+	{OptionID: "reject_once", Name: "Reject", Kind: "reject_once"},
+	{OptionID: "reject_always", Name: "Always reject", Kind: "reject_always"},
+}
+
+// approver asks the client of a session, with session/request_permission,
+// whether a tool call may run.
+func (s *Server) approver(sessionID string) llm.Approver {
+	return func(ctx context.Context, req llm.ApprovalRequest) (llm.Approval, error) {
+		params := RequestPermissionParams{
+			SessionID: sessionID,
+			ToolCall: ToolCallUpdate{
+				ToolCallID: req.Call.ID,
+				Title:      req.String(),
+				Kind:       toolKind(req.Call.Name),
+				Status:     "pending",
+			},
+			Options: permissionOptions,
+		}
+		if json.Valid([]byte(req.Call.Input)) {
+			params.ToolCall.RawInput = json.RawMessage(req.Call.Input)
+		}
+
+		var result RequestPermissionResult
+		if err := s.request(ctx, "session/request_permission", params, &result); err != nil {
+			return llm.RejectOnce, err
+		}
+		if result.Outcome.Outcome != "selected" {
+			return llm.RejectOnce, errors.New("the permission request was cancelled")
+		}
+		switch result.Outcome.OptionID {
+		case "allow_once":
+			return llm.ApproveOnce, nil
+		case "allow_always":
+			return llm.ApproveAlways, nil
+		case "reject_always":
+			return llm.RejectAlways, nil
+		default:
+			return llm.RejectOnce, nil
+		}
+	}
+}
+
 func (s *Server) sendResponse(resp Response) {
-	s.outMu.Lock()
-	defer s.outMu.Unlock()
-	data, _ := json.Marshal(resp)
-	_, _ = fmt.Fprintf(s.out, "%s\n", data)
+	s.send(resp)
 }
 
 func (s *Server) sendNotification(notif Notification) {
+	s.send(notif)
+}
+
+// send writes one message to the client.
+func (s *Server) send(msg any) {
 	s.outMu.Lock()
 	defer s.outMu.Unlock()
-	data, _ := json.Marshal(notif)
+	data, _ := json.Marshal(msg)
 	_, _ = fmt.Fprintf(s.out, "%s\n", data)
 }
 
@@ -365,11 +878,15 @@ func toolKind(name string) string {
 	switch name {
 	case "cat", "files":
 		return "read"
-	case "create", "insert":
+	case "list-symbols", "hover", "diagnostics", "list-resources", "read-resource":
+		return "read"
+	case "grep", "goto-definition", "find-references", "workspace-symbol":
+		return "search"
+	case "create", "insert", "edit", "patch", "rename-symbol", "code-action":
 		return "edit"
 	case "git":
 		return "execute"
-	case "bash":
+	case "bash", "process-start", "process-send":
 		return "execute"
 	default:
 		return "other"
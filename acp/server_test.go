@@ -2,11 +2,19 @@ package acp
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/elek/rai/tool"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestServerInitialize(t *testing.T) {
@@ -86,3 +94,110 @@ func TestSessionNotFound(t *testing.T) {
 	assert.NotNil(t, resp.Error)
 	assert.Equal(t, -32002, resp.Error.Code)
 }
+
+func TestNewSessionRejectsMissingCwd(t *testing.T) {
+	input := `{"jsonrpc":"2.0","id":1,"method":"session/new","params":{"cwd":"/nonexistent/project","mcpServers":[]}}` + "\n"
+	out := &bytes.Buffer{}
+
+	srv := NewServer(nil)
+	srv.ServeIO(strings.NewReader(input), out)
+
+	var resp Response
+	err := json.NewDecoder(out).Decode(&resp)
+	assert.NoError(t, err)
+	assert.NotNil(t, resp.Error)
+	assert.Equal(t, -32602, resp.Error.Code)
+	assert.Contains(t, resp.Error.Message, "invalid workspace")
+}
+
+func TestNewSessionConnectsMcpServers(t *testing.T) {
+	server := mcp.NewServer(&mcp.Implementation{Name: "docs", Version: "v1.0.0"}, nil)
+	mcp.AddTool(server, &mcp.Tool{Name: "search"}, func(ctx context.Context, req *mcp.CallToolRequest, input struct{}) (*mcp.CallToolResult, any, error) {
+		return &mcp.CallToolResult{}, nil, nil
+	})
+	handler := mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return server }, nil)
+	var closed atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			closed.Add(1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	input := `{"jsonrpc":"2.0","id":1,"method":"session/new","params":{"cwd":"/tmp","mcpServers":[` +
+		`{"type":"http","name":"Team docs","url":"` + ts.URL + `","headers":[{"name":"X-Token","value":"secret"}]}]}}` + "\n" +
+		`{"jsonrpc":"2.0","id":2,"method":"session/new","params":{"cwd":"/tmp","mcpServers":[{"type":"ws","name":"other","url":"ws://localhost"}]}}` + "\n"
+	out := &bytes.Buffer{}
+	srv := NewServer(nil)
+	require.NoError(t, srv.ServeIO(strings.NewReader(input), out))
+
+	// The sessions are set up concurrently, so their messages may interleave.
+	responses := map[string]Response{}
+	var commands []AvailableCommand
+	decoder := json.NewDecoder(out)
+	for decoder.More() {
+		var msg struct {
+			Response
+			Method string                    `json:"method"`
+			Params SessionUpdateNotification `json:"params"`
+		}
+		require.NoError(t, decoder.Decode(&msg))
+		if msg.Method != "" {
+			commands = append(commands, msg.Params.Update.AvailableCommands...)
+			continue
+		}
+		responses[string(msg.ID)] = msg.Response
+	}
+	require.Len(t, commands, 1)
+	assert.Equal(t, "Team_docs_search", commands[0].Name, "the name of the server is cleaned up for the model providers")
+
+	assert.Nil(t, responses["1"].Error)
+	require.NotNil(t, responses["2"].Error)
+	assert.Equal(t, -32602, responses["2"].Error.Code)
+	assert.Contains(t, responses["2"].Error.Message, `unknown type "ws" of the MCP server other`)
+
+	assert.Equal(t, int32(1), closed.Load(), "the MCP server is closed with the session")
+}
+
+func TestNewSessionWithHungMcpServerDoesNotBlock(t *testing.T) {
+	defer func(timeout time.Duration) { mcpConnectTimeout = timeout }(mcpConnectTimeout)
+	mcpConnectTimeout = 300 * time.Millisecond
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	client := newACPClient(t, NewServer(nil))
+	defer client.close()
+	client.send(`{"jsonrpc":"2.0","id":1,"method":"session/new","params":{"cwd":"/tmp","mcpServers":[{"type":"sse","name":"hung","url":"` + ts.URL + `"}]}}`)
+	client.send(`{"jsonrpc":"2.0","id":2,"method":"initialize","params":{"protocolVersion":1}}`)
+
+	resp, _ := client.readUntilResponse(2)
+	assert.Nil(t, resp.Error, "other messages are answered while the MCP server starts")
+	resp, _ = client.readUntilResponse(1)
+	require.NotNil(t, resp.Error)
+	assert.Contains(t, resp.Error.Message, "Failed to start MCP server hung: no answer in 300ms")
+}
+
+func TestMcpServerToolServer(t *testing.T) {
+	server, err := McpServer{Name: "fs", Command: "mcp-fs", Args: []string{"--ro"}, Env: []EnvVariable{{Name: "LEVEL", Value: "debug"}}}.toolServer("/work")
+	require.NoError(t, err)
+	assert.Equal(t, tool.McpServer{Command: "mcp-fs", Args: []string{"--ro"}, Env: []string{"LEVEL=debug"}, Dir: "/work", Alias: "fs", Headers: map[string]string{}}, server)
+
+	server, err = McpServer{Type: "sse", Name: "remote", URL: "https://example.com/sse"}.toolServer("/work")
+	require.NoError(t, err)
+	assert.Equal(t, tool.McpServer{URL: "https://example.com/sse", Transport: "sse", Alias: "remote", Headers: map[string]string{}}, server)
+
+	_, err = McpServer{Type: "http", Name: "remote"}.toolServer("/work")
+	assert.ErrorContains(t, err, "needs a url")
+}
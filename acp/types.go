@@ -71,7 +71,16 @@ type InitializeResult struct {
 
 // AgentCapabilities describes what the agent supports.
 type AgentCapabilities struct {
+	LoadSession        bool                `json:"loadSession,omitempty"`
 	PromptCapabilities *PromptCapabilities `json:"promptCapabilities,omitempty"`
+	McpCapabilities    *McpCapabilities    `json:"mcpCapabilities,omitempty"`
+}
+
+// McpCapabilities tells which transports the agent supports for the MCP
+// servers of session/new, besides stdio.
+type McpCapabilities struct {
+	HTTP bool `json:"http,omitempty"`
+	SSE  bool `json:"sse,omitempty"`
 }
 
 // PromptCapabilities describes the agent's prompt handling capabilities.
@@ -83,8 +92,33 @@ type PromptCapabilities struct {
 
 // NewSessionParams contains the parameters for the session/new request.
 type NewSessionParams struct {
-	Cwd        string `json:"cwd"`
-	McpServers []any  `json:"mcpServers,omitempty"`
+	Cwd        string      `json:"cwd"`
+	McpServers []McpServer `json:"mcpServers,omitempty"`
+}
+
+// McpServer is an MCP server the client asks the agent to use in a session:
+// a command speaking over stdin/stdout when Type is empty (or "stdio"), or
+// the URL of a server with Type "http" or "sse".
+type McpServer struct {
+	Type    string        `json:"type,omitempty"`
+	Name    string        `json:"name"`
+	Command string        `json:"command,omitempty"`
+	Args    []string      `json:"args,omitempty"`
+	Env     []EnvVariable `json:"env,omitempty"`
+	URL     string        `json:"url,omitempty"`
+	Headers []HTTPHeader  `json:"headers,omitempty"`
+}
+
+// EnvVariable is an environment variable of a stdio MCP server.
+type EnvVariable struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HTTPHeader is a header sent to an HTTP or SSE MCP server.
+type HTTPHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
 }
 
 // NewSessionResult contains the result of the session/new request.
@@ -92,6 +126,18 @@ type NewSessionResult struct {
 	SessionID string `json:"sessionId"`
 }
 
+// LoadSessionParams contains the parameters for the session/load request.
+type LoadSessionParams struct {
+	SessionID  string      `json:"sessionId"`
+	Cwd        string      `json:"cwd"`
+	McpServers []McpServer `json:"mcpServers,omitempty"`
+}
+
+// LoadSessionResult contains the result of the session/load request. The
+// conversation itself is replayed to the client as session/update
+// notifications before the response is sent.
+type LoadSessionResult struct{}
+
 // Prompt
 
 // PromptParams contains the parameters for the session/prompt request.
@@ -177,3 +223,43 @@ type AvailableCommandsUpdate struct {
 type CancelParams struct {
 	SessionID string `json:"sessionId"`
 }
+
+// Permission
+
+// RequestPermissionParams contains the parameters of the
+// session/request_permission request, which the agent sends to ask the user
+// whether a tool call may run.
+type RequestPermissionParams struct {
+	SessionID string             `json:"sessionId"`
+	ToolCall  ToolCallUpdate     `json:"toolCall"`
+	Options   []PermissionOption `json:"options"`
+}
+
+// ToolCallUpdate describes the tool call a permission request is about.
+type ToolCallUpdate struct {
+	ToolCallID string          `json:"toolCallId"`
+	Title      string          `json:"title,omitempty"`
+	Kind       string          `json:"kind,omitempty"`
+	Status     string          `json:"status,omitempty"`
+	RawInput   json.RawMessage `json:"rawInput,omitempty"`
+}
+
+// PermissionOption is one of the answers offered to the user. Kind is
+// "allow_once", "allow_always", "reject_once", or "reject_always".
+type PermissionOption struct {
+	OptionID string `json:"optionId"`
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+}
+
+// RequestPermissionResult contains the client's answer to a permission request.
+type RequestPermissionResult struct {
+	Outcome PermissionOutcome `json:"outcome"`
+}
+
+// PermissionOutcome is "selected", with the chosen option, or "cancelled" when
+// the prompt was cancelled before the user answered.
+type PermissionOutcome struct {
+	Outcome  string `json:"outcome"`
+	OptionID string `json:"optionId,omitempty"`
+}
@@ -9,7 +9,9 @@ import (
 	"github.com/elek/rai/acp"
 	"github.com/elek/rai/config"
 	"github.com/elek/rai/llm"
+	"github.com/elek/rai/session"
 	"github.com/elek/rai/templates"
+	"github.com/elek/rai/tool"
 	"github.com/pkg/errors"
 )
 
@@ -17,6 +19,7 @@ import (
 // server that communicates over stdio using JSON-RPC 2.0.
 type Acp struct {
 	llm.WithModel
+	tool.WithWorkspace
 	Command string `arg:"" name:"command" help:"Template name to configure the agent" optional:""`
 }
 
@@ -52,6 +55,8 @@ func (a Acp) Run() error {
 
 	srv := acp.NewServer(parsed)
 	srv.SetConfig(cfg)
+	srv.SetWorkspace(a.Workspace, a.Sandbox)
+	srv.SetStore(session.NewStore(session.DefaultDir()))
 
 	if a.Model != "" {
 		mod, found := cfg.FindModel(a.Model)
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/elek/rai/llm"
+	"github.com/pkg/errors"
+)
+
+// approvalKeys explains the answers to an approval question.
+const approvalKeys = "[y]es, [a]lways, [n]o, [d]eny always"
+
+// ttyApprover asks on the terminal whether a tool call may run. The answer is
+// read from /dev/tty rather than stdin, which may be piped into the command.
+func ttyApprover(_ context.Context, req llm.ApprovalRequest) (llm.Approval, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return llm.RejectOnce, errors.New("there is no terminal to ask")
+	}
+	defer tty.Close()
+	return askApproval(tty, tty, req)
+}
+
+// askApproval writes the approval question for req to out and reads the
+// answer from in. Anything but a yes is a no.
+func askApproval(in io.Reader, out io.Writer, req llm.ApprovalRequest) (llm.Approval, error) {
+	fmt.Fprintf(out, "Allow %s? %s: ", req, approvalKeys)
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && line == "" {
+		return llm.RejectOnce, errors.WithStack(err)
+	}
+	answer, _ := approvalAnswer(strings.TrimSpace(line))
+	return answer, nil
+}
+
+// approvalAnswer maps a typed answer to an Approval. ok is false for anything
+// that isn't one of the answers, which counts as a no.
+func approvalAnswer(s string) (answer llm.Approval, ok bool) {
+	switch strings.ToLower(s) {
+	case "y", "yes":
+		return llm.ApproveOnce, true
+	case "a", "always":
+		return llm.ApproveAlways, true
+	case "n", "no":
+		return llm.RejectOnce, true
+	case "d", "deny":
+		return llm.RejectAlways, true
+	}
+	return llm.RejectOnce, false
+}
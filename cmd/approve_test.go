@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/elek/rai/llm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAskApproval(t *testing.T) {
+	req := llm.ApprovalRequest{Call: llm.ToolCall{Name: "git"}, Subject: "git push"}
+	for answer, want := range map[string]llm.Approval{
+		"y\n":      llm.ApproveOnce,
+		"always\n": llm.ApproveAlways,
+		"n\n":      llm.RejectOnce,
+		"d\n":      llm.RejectAlways,
+		"\n":       llm.RejectOnce,
+		"whatever": llm.RejectOnce,
+	} {
+		var out bytes.Buffer
+		got, err := askApproval(strings.NewReader(answer), &out, req)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, answer)
+		assert.Equal(t, "Allow git: git push? "+approvalKeys+": ", out.String())
+	}
+}
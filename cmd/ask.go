@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/elek/rai/llm"
+	"github.com/elek/rai/session"
 	"github.com/pkg/errors"
 )
 
@@ -22,6 +23,7 @@ func (a Ask) Run() error {
 	}
 
 	e := llm.NewExecutor(cfg, a.Debug)
+	e.SetRecorder(session.NewStore(session.DefaultDir()).Record)
 
 	mdl, err := a.ResolveModel(cfg)
 	if err != nil {
@@ -2,20 +2,29 @@ package cmd
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/elek/rai/config"
 	"github.com/elek/rai/llm"
+	"github.com/elek/rai/session"
 	"github.com/elek/rai/templates"
+	"github.com/elek/rai/tool"
 	"github.com/pkg/errors"
 )
 
 type Do struct {
 	llm.WithModel
-	Command string   `arg:"" name:"command" help:"Command to be executed"`
+	tool.WithWorkspace
+	Command string   `arg:"" name:"command" help:"Command to be executed: a template of ~/.config/rai, or server/prompt for a prompt of an MCP server of the config" optional:""`
 	Args    []string `arg:"" name:"args" help:"Arguments for the command" optional:""`
 	DryRun  bool     `help:"Dry run (do not execute the command, just print the prompt)"`
+	List    bool     `help:"List the templates and the prompts of the MCP servers of the config"`
 }
 
 func (a Do) Run() error {
@@ -24,26 +33,46 @@ func (a Do) Run() error {
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	mpf := filepath.Join(home, ".config", "rai", a.Command)
+	dir := filepath.Join(home, ".config", "rai")
 
-	rawPrompt, err := os.ReadFile(mpf)
+	cfg, err := a.GetConfig()
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	promptContent := string(rawPrompt)
+	if a.List {
+		return writeCommands(os.Stdout, dir, templates.McpPrompts(ctx, cfg))
+	}
+	if a.Command == "" {
+		return errors.New("command is required, see --list for the available ones")
+	}
 
-	cfg, err := a.GetConfig()
-	if err != nil {
+	// A command that is not a template file may be a prompt of an MCP server.
+	rawPrompt, err := os.ReadFile(filepath.Join(dir, a.Command))
+	server, _, isPrompt := strings.Cut(a.Command, "/")
+	if _, found := cfg.FindMCPServer(server); !found || !os.IsNotExist(err) {
+		isPrompt = false
+	}
+	if err != nil && !isPrompt {
 		return errors.WithStack(err)
 	}
 
+	ctx, err = a.WorkspaceContext(ctx)
+	if err != nil {
+		return err
+	}
+	processes := tool.NewProcesses()
+	defer processes.Close()
+	ctx = tool.ContextWithProcesses(ctx, processes)
+
 	var cb llm.AgentCallback
 	if a.DryRun {
 		cb = llm.DryRun
 	} else {
 
 		e := llm.NewExecutor(cfg, a.Debug)
+		e.SetRecorder(session.NewStore(session.DefaultDir()).Record)
+		e.SetApprover(ttyApprover)
 		cb = e.ExecPrompt
 	}
 
@@ -66,11 +95,74 @@ func (a Do) Run() error {
 	//	}
 	//	args["Stdin"] = string(stdinBytes)
 	//}
-	_, err = templates.GoTemplateRender(cfg)(ctx, promptContent, args, cb)
+	if isPrompt {
+		parsed, err := templates.ParseMcpPrompt(ctx, cfg, a.Command, a.Args)
+		if err != nil {
+			return err
+		}
+		defer parsed.Close()
+		_, err = cb(ctx, parsed.Model, parsed.System, parsed.Prompt, parsed.Tools)
+		return errors.WithStack(err)
+	}
+	_, err = templates.GoTemplateRender(cfg)(ctx, string(rawPrompt), args, cb)
 
 	return errors.WithStack(err)
 }
 
+// writeCommands lists the templates in dir, and the prompts of MCP servers
+// with their descriptions.
+func writeCommands(w io.Writer, dir string, prompts map[string]string) error {
+	names, err := templateNames(dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fmt.Fprintln(w, name)
+	}
+
+	names = names[:0]
+	for name := range prompts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if strings.HasSuffix(name, "/") {
+			fmt.Fprintf(w, "%s (unavailable: %s)\n", name, prompts[name])
+		} else if prompts[name] != "" {
+			fmt.Fprintf(w, "%s - %s\n", name, prompts[name])
+		} else {
+			fmt.Fprintln(w, name)
+		}
+	}
+	return nil
+}
+
+// templateNames returns the templates in dir, skipping the config, the
+// sessions, the skills and hidden files.
+func templateNames(dir string) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		switch {
+		case path == dir:
+		case strings.HasPrefix(d.Name(), "."), d.IsDir() && (rel == "sessions" || rel == "skills"):
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+		case !d.IsDir() && rel != "config.yaml":
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return names, errors.WithStack(err)
+}
+
 // withModelOverride wraps an AgentCallback so that a non-empty model forces the
 // model used for the call, overriding whatever the template resolved. When
 // model is the zero value the template's model (passed by the renderer) is left
@@ -1,12 +1,16 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/elek/rai/config"
 	"github.com/elek/rai/llm"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestModelOverrideUsesCLIModelWhenSet(t *testing.T) {
@@ -37,3 +41,23 @@ func TestModelOverrideKeepsTemplateModelWhenCLIEmpty(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, template, got, "with no CLI model the template's model must be used")
 }
+
+func TestWriteCommandsListsTemplatesAndPrompts(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"config.yaml", "summarize", "git/commit", "sessions/1.json", "skills/go/SKILL.md", ".hidden"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o644))
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, writeCommands(&out, dir, map[string]string{
+		"tickets/triage": "Triage a ticket (arguments: id)",
+		"docs/index":     "",
+		"broken/":        "connection refused",
+	}))
+	assert.Equal(t, "git/commit\nsummarize\nbroken/ (unavailable: connection refused)\ndocs/index\ntickets/triage - Triage a ticket (arguments: id)\n", out.String())
+
+	out.Reset()
+	require.NoError(t, writeCommands(&out, filepath.Join(dir, "missing"), nil))
+	assert.Empty(t, out.String())
+}
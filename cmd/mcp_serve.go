@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/elek/rai/config"
+	"github.com/elek/rai/llm"
+	"github.com/elek/rai/session"
+	"github.com/elek/rai/templates"
+	"github.com/elek/rai/tool"
+	"github.com/google/shlex"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/pkg/errors"
+)
+
+// McpServe implements the `rai mcp-serve` CLI command, which offers the
+// built-in tools and the templates of ~/.config/rai to other agents as an MCP
+// server over stdio.
+type McpServe struct {
+	llm.WithModel
+	tool.WithWorkspace
+}
+
+// TemplateInput defines the input parameters of the tool of a template.
+type TemplateInput struct {
+	Args []string `json:"args,omitempty" jsonschema:"The arguments of the template, available in it as .Args"`
+}
+
+// Run serves MCP on stdin/stdout until the client disconnects. The answers of
+// the templates are streamed to stderr while they run, as stdout carries the
+// protocol.
+func (m McpServe) Run() error {
+	ctx := context.Background()
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	dir := filepath.Join(home, ".config", "rai")
+
+	cfg, err := m.GetConfig()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	ctx, err = m.WorkspaceContext(ctx)
+	if err != nil {
+		return err
+	}
+	processes := tool.NewProcesses()
+	defer processes.Close()
+	ctx = tool.ContextWithProcesses(ctx, processes)
+
+	e := llm.NewExecutor(cfg, m.Debug)
+	e.SetOutput(os.Stderr)
+	e.SetRecorder(session.NewStore(session.DefaultDir()).Record)
+	e.SetApprover(mcpApprover)
+	cliModel, err := m.ResolveModel(cfg)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	server, err := newMcpServer(cfg, dir, withModelOverride(e.ExecPrompt, cliModel))
+	if err != nil {
+		return err
+	}
+	return errors.WithStack(server.Run(ctx, &mcp.StdioTransport{}))
+}
+
+// newMcpServer returns an MCP server with the built-in tools, guarded by the
+// permissions of the config, and a prompt and a tool for each template in
+// dir. The tool of a template runs it with run, and returns the answer. The
+// calls that need approval are asked from the user of the client.
+func newMcpServer(cfg config.Config, dir string, run llm.AgentCallback) (*mcp.Server, error) {
+	server := mcp.NewServer(&mcp.Implementation{Name: "rai", Version: "0.1.0"}, nil)
+
+	policy, err := llm.NewPolicy(cfg.Permissions)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range policy.Guard(tool.AllTools(), mcpApprover) {
+		server.AddTool(mcpTool(t.Info()), toolHandler(t))
+	}
+
+	names, err := templateNames(dir)
+	if err != nil {
+		return nil, err
+	}
+	tools := map[string]string{}
+	for _, name := range names {
+		toolName := templateToolName(name)
+		if other, ok := tools[toolName]; ok {
+			return nil, errors.Errorf("the templates %s and %s would both be offered as the tool %s, rename one of them", other, name, toolName)
+		}
+		tools[toolName] = name
+		server.AddPrompt(&mcp.Prompt{
+			Name:        name,
+			Description: "The " + name + " template of rai",
+			Arguments:   []*mcp.PromptArgument{{Name: "args", Description: "The arguments of the template, separated by spaces"}},
+		}, templatePrompt(cfg, filepath.Join(dir, name)))
+		mcp.AddTool(server, &mcp.Tool{
+			Name:        toolName,
+			Description: "Run the " + name + " template of rai with an agent, and return its answer",
+		}, templateTool(cfg, filepath.Join(dir, name), run))
+	}
+	return server, nil
+}
+
+// mcpTool describes a tool for MCP clients.
+func mcpTool(info llm.ToolInfo) *mcp.Tool {
+	schema := map[string]any{"type": "object"}
+	if len(info.Parameters) > 0 {
+		schema["properties"] = info.Parameters
+	}
+	if len(info.Required) > 0 {
+		schema["required"] = info.Required
+	}
+	t := &mcp.Tool{Name: info.Name, Description: info.Description, InputSchema: schema}
+	if info.Parallel {
+		t.Annotations = &mcp.ToolAnnotations{ReadOnlyHint: true}
+	}
+	return t
+}
+
+// toolHandler runs t for the calls of MCP clients.
+func toolHandler(t llm.Tool) mcp.ToolHandler {
+	return func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		ctx = context.WithValue(ctx, mcpSessionKey{}, req.Session)
+		input := string(req.Params.Arguments)
+		if input == "" {
+			input = "{}"
+		}
+		res, err := t.Run(ctx, llm.ToolCall{Name: req.Params.Name, Input: input})
+		if err != nil {
+			res = llm.ToolResult{Content: err.Error(), IsError: true}
+		}
+		return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: res.Content}}, IsError: res.IsError}, nil
+	}
+}
+
+// mcpSessionKey is the context key of the MCP session a tool call came from.
+type mcpSessionKey struct{}
+
+// mcpApprover asks the user of the MCP client whether a tool call may run,
+// with an elicitation. The terminal belongs to the client, so clients that
+// can't elicit get the call rejected.
+func mcpApprover(ctx context.Context, req llm.ApprovalRequest) (llm.Approval, error) {
+	session, _ := ctx.Value(mcpSessionKey{}).(*mcp.ServerSession)
+	if session == nil || session.InitializeParams() == nil || session.InitializeParams().Capabilities == nil || session.InitializeParams().Capabilities.Elicitation == nil {
+		return llm.RejectOnce, errors.New("approval is not available, as the MCP client doesn't support elicitation; allow the tool in the permissions of the rai config")
+	}
+	res, err := session.Elicit(ctx, &mcp.ElicitParams{
+		Message: "Allow " + req.String() + "?",
+		RequestedSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"always": map[string]any{"type": "boolean", "description": "Also allow " + req.String() + " when it is called again"},
+			},
+		},
+	})
+	if err != nil {
+		return llm.RejectOnce, errors.WithStack(err)
+	}
+	switch {
+	case res.Action != "accept":
+		return llm.RejectOnce, nil
+	case res.Content["always"] == true:
+		return llm.ApproveAlways, nil
+	default:
+		return llm.ApproveOnce, nil
+	}
+}
+
+// templateToolName returns the name of the tool of a template: do- and the
+// name, cleaned up like the names of MCP tools.
+func templateToolName(name string) string {
+	return tool.CleanToolName("do-" + name)
+}
+
+// templateTool runs the template at path, read at every call, with an agent.
+func templateTool(cfg config.Config, path string, run llm.AgentCallback) mcp.ToolHandlerFor[TemplateInput, any] {
+	return func(ctx context.Context, req *mcp.CallToolRequest, input TemplateInput) (*mcp.CallToolResult, any, error) {
+		ctx = context.WithValue(ctx, mcpSessionKey{}, req.Session)
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		answer, err := templates.GoTemplateRender(cfg)(ctx, string(raw), map[string]any{"Args": input.Args}, run)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: answer}}}, nil, nil
+	}
+}
+
+// templatePrompt renders the template at path, with the system prompt before
+// the prompt, for MCP clients to run themselves.
+func templatePrompt(cfg config.Config, path string) mcp.PromptHandler {
+	return func(ctx context.Context, req *mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		args, err := shlex.Split(req.Params.Arguments["args"])
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		parsed, err := templates.ParseTemplate(ctx, cfg, string(raw), map[string]any{"Args": args})
+		if err != nil {
+			return nil, err
+		}
+		defer parsed.Close()
+		text := strings.TrimSpace(parsed.Prompt)
+		if system := strings.TrimSpace(parsed.System); system != "" {
+			text = system + "\n\n" + text
+		}
+		return &mcp.GetPromptResult{Messages: []*mcp.PromptMessage{
+			{Role: "user", Content: &mcp.TextContent{Text: text}},
+		}}, nil
+	}
+}
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elek/rai/config"
+	"github.com/elek/rai/llm"
+	"github.com/elek/rai/tool"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// connectMcpServe connects a client with opts to the MCP server of the
+// templates in dir, serving with ctx.
+func connectMcpServe(t *testing.T, ctx context.Context, cfg config.Config, dir string, run llm.AgentCallback, opts *mcp.ClientOptions) *mcp.ClientSession {
+	t.Helper()
+	server, err := newMcpServer(cfg, dir, run)
+	require.NoError(t, err)
+	clientTransport, serverTransport := mcp.NewInMemoryTransports()
+	serverSession, err := server.Connect(ctx, serverTransport, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = serverSession.Close() })
+	client := mcp.NewClient(&mcp.Implementation{Name: "test", Version: "v1.0.0"}, opts)
+	session, err := client.Connect(t.Context(), clientTransport, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = session.Close() })
+	return session
+}
+
+func TestMcpServe(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"config.yaml": "",
+		"review":      "<system>Be brief.</system>Review {{index .Args 0}}",
+		"git/commit":  "Write a commit message",
+		"broken":      "<model>missing</model>",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	work := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(work, "main.go"), []byte("package main\n"), 0o644))
+	ws, err := tool.NewWorkspace(work, false)
+	require.NoError(t, err)
+
+	var gotSystem, gotPrompt string
+	run := func(_ context.Context, _ config.Model, system string, prompt string, _ []llm.Tool) (string, error) {
+		gotSystem, gotPrompt = system, prompt
+		return "looks good", nil
+	}
+	cfg := config.Config{Permissions: config.Permissions{Rules: []config.PermissionRule{{Tool: "bash", Action: "deny"}}}}
+	session := connectMcpServe(t, tool.ContextWithWorkspace(context.Background(), ws), cfg, dir, run, nil)
+
+	tools := map[string]*mcp.Tool{}
+	for tl, err := range session.Tools(t.Context(), nil) {
+		require.NoError(t, err)
+		tools[tl.Name] = tl
+	}
+	for _, name := range []string{"cat", "grep", "skill", "bash", "do-review", "do-git_commit", "do-broken"} {
+		assert.Contains(t, tools, name)
+	}
+	assert.True(t, tools["cat"].Annotations.ReadOnlyHint)
+
+	res, err := session.CallTool(t.Context(), &mcp.CallToolParams{Name: "cat", Arguments: map[string]any{"path": "main.go"}})
+	require.NoError(t, err)
+	assert.False(t, res.IsError)
+	assert.Contains(t, res.Content[0].(*mcp.TextContent).Text, "package main", "paths are resolved in the workspace")
+
+	res, err = session.CallTool(t.Context(), &mcp.CallToolParams{Name: "bash", Arguments: map[string]any{"command": "ls"}})
+	require.NoError(t, err)
+	assert.True(t, res.IsError)
+	assert.Contains(t, res.Content[0].(*mcp.TextContent).Text, "permission denied")
+
+	res, err = session.CallTool(t.Context(), &mcp.CallToolParams{Name: "do-review", Arguments: map[string]any{"args": []string{"main.go"}}})
+	require.NoError(t, err)
+	assert.False(t, res.IsError)
+	assert.Equal(t, "looks good", res.Content[0].(*mcp.TextContent).Text)
+	assert.Equal(t, "Be brief.", gotSystem)
+	assert.Equal(t, "Review main.go", gotPrompt)
+
+	res, err = session.CallTool(t.Context(), &mcp.CallToolParams{Name: "do-broken", Arguments: map[string]any{}})
+	require.NoError(t, err)
+	assert.True(t, res.IsError)
+	assert.Contains(t, res.Content[0].(*mcp.TextContent).Text, "model couldn't be found: missing")
+
+	var prompts []string
+	for p, err := range session.Prompts(t.Context(), nil) {
+		require.NoError(t, err)
+		prompts = append(prompts, p.Name)
+	}
+	assert.ElementsMatch(t, []string{"review", "git/commit", "broken"}, prompts)
+
+	prompt, err := session.GetPrompt(t.Context(), &mcp.GetPromptParams{Name: "review", Arguments: map[string]string{"args": "'main file.go'"}})
+	require.NoError(t, err)
+	require.Len(t, prompt.Messages, 1)
+	assert.Equal(t, "Be brief.\n\nReview main file.go", prompt.Messages[0].Content.(*mcp.TextContent).Text)
+}
+
+func TestMcpServeAsksTheClientForApproval(t *testing.T) {
+	ws, err := tool.NewWorkspace(t.TempDir(), false)
+	require.NoError(t, err)
+	ctx := tool.ContextWithWorkspace(context.Background(), ws)
+	cfg := config.Config{Permissions: config.Permissions{Rules: []config.PermissionRule{{Tool: "bash", Action: "ask"}}}}
+	call := &mcp.CallToolParams{Name: "bash", Arguments: map[string]any{"command": "echo hi"}}
+
+	session := connectMcpServe(t, ctx, cfg, t.TempDir(), nil, nil)
+	res, err := session.CallTool(t.Context(), call)
+	require.NoError(t, err)
+	assert.True(t, res.IsError)
+	assert.Contains(t, res.Content[0].(*mcp.TextContent).Text, "approval is not available, as the MCP client doesn't support elicitation")
+
+	var asked []string
+	action := "decline"
+	session = connectMcpServe(t, ctx, cfg, t.TempDir(), nil, &mcp.ClientOptions{
+		ElicitationHandler: func(_ context.Context, req *mcp.ElicitRequest) (*mcp.ElicitResult, error) {
+			asked = append(asked, req.Params.Message)
+			return &mcp.ElicitResult{Action: action, Content: map[string]any{"always": true}}, nil
+		},
+	})
+	res, err = session.CallTool(t.Context(), call)
+	require.NoError(t, err)
+	assert.True(t, res.IsError)
+	assert.Contains(t, res.Content[0].(*mcp.TextContent).Text, "the user rejected the bash tool call")
+
+	action = "accept"
+	for range 2 {
+		res, err = session.CallTool(t.Context(), call)
+		require.NoError(t, err)
+		assert.False(t, res.IsError)
+		assert.Equal(t, "hi\n", res.Content[0].(*mcp.TextContent).Text)
+	}
+	assert.Equal(t, []string{"Allow bash: echo hi?", "Allow bash: echo hi?"}, asked, "always holds for the later calls")
+}
+
+func TestMcpServeRejectsTemplatesOfTheSameToolName(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"git/commit", "git_commit"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("Commit"), 0o644))
+	}
+	_, err := newMcpServer(config.Config{}, dir, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "would both be offered as the tool do-git_commit")
+}
+
+func TestTemplateToolName(t *testing.T) {
+	assert.Equal(t, "do-review", templateToolName("review"))
+	assert.Equal(t, "do-git_commit", templateToolName("git/commit"))
+	assert.Equal(t, "do-fix_bug_md", templateToolName("fix bug.md"))
+	assert.Regexp(t, `^do-a{52}_[0-9a-f]{8}$`, templateToolName(strings.Repeat("a", 80)))
+}
@@ -1,10 +1,18 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"os"
+
 	"github.com/elek/catwalk-open/providers"
+	"github.com/elek/rai/config"
+	"github.com/elek/rai/llm"
+	"github.com/pkg/errors"
 )
 
 type Models struct {
+	config.WithConfig
 	Provider string `arg:"" optional:"" help:"The provider to list models for."`
 }
 
@@ -14,7 +22,33 @@ func (l *Models) Run() error {
 			continue
 		}
 		for _, model := range provider.Models {
-			println(string(provider.Type) + ": " + model.ID)
+			fmt.Println(string(provider.Type) + ": " + model.ID)
+		}
+	}
+
+	// Local servers are asked for the models they have; without a config
+	// there are none to ask.
+	cfg, err := l.GetConfig()
+	if os.IsNotExist(errors.Cause(err)) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, p := range cfg.Providers {
+		if p.Type != "ollama" && p.Type != "llamacpp" {
+			continue
+		}
+		if l.Provider != "" && p.Name != l.Provider {
+			continue
+		}
+		models, err := llm.ListLocalModels(context.Background(), p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", p.Name, err)
+			continue
+		}
+		for _, model := range models {
+			fmt.Println(p.Name + ": " + model)
 		}
 	}
 	return nil
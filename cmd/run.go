@@ -0,0 +1,454 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/elek/rai/config"
+	"github.com/elek/rai/llm"
+	"github.com/elek/rai/session"
+	"github.com/elek/rai/tool"
+	"github.com/pkg/errors"
+)
+
+// Run implements the `rai run` CLI command: an interactive REPL that keeps the
+// whole conversation across turns and streams each answer through llm.Agent.
+type Run struct {
+	llm.WithModel
+	tool.WithWorkspace
+	System    string `help:"System prompt for the conversation"`
+	WithTools bool   `help:"Enable all tools for the agent"`
+}
+
+// replHelp lists the slash commands understood by the REPL.
+const replHelp = `Commands:
+  /model [name]  show the current model, or switch to another one (history is kept)
+  /tools         list the tools available to the model
+  /clear         forget the conversation so far
+  /help          show this help
+  /quit          leave the REPL (also ctrl+d)`
+
+func (r Run) Run() error {
+	ctx := context.Background()
+
+	cfg, err := r.GetConfig()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	mdl, err := r.ResolveModel(cfg)
+	if err != nil {
+		return err
+	}
+	if mdl == (config.Model{}) {
+		def, found := cfg.FindDefaultModel()
+		if !found {
+			return errors.New("model is not defined, and no default model found")
+		}
+		mdl = def
+	}
+
+	var tools []llm.Tool
+	if r.WithTools {
+		tools = tool.AllTools()
+	}
+
+	ctx, err = r.WorkspaceContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return runRepl(ctx, cfg, session.New(mdl, r.System), tools, r.Debug)
+}
+
+// runRepl starts the interactive REPL on rec, which is saved to the session
+// store after every turn. The tools are confined to the workspace of ctx.
+func runRepl(ctx context.Context, cfg config.Config, rec *session.Session, tools []llm.Tool, debug bool) error {
+	mdl := rec.Model
+	if debug {
+		mdl.Debug = true
+	}
+	model, err := llm.NewModel(ctx, cfg, mdl)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	policy, err := llm.NewPolicy(cfg.Permissions)
+	if err != nil {
+		return err
+	}
+
+	r := newRepl(cfg, model, rec.System, nil, debug)
+	r.tools = policy.Guard(tools, r.approve)
+	r.conv = llm.NewConversation(llm.NewAgent(model, rec.System, r.tools), rec.Messages)
+	r.store = session.NewStore(session.DefaultDir())
+	r.record = rec
+	r.workspace = tool.WorkspaceFrom(ctx)
+	r.processes = tool.NewProcesses()
+	defer r.processes.Close()
+
+	_, err = tea.NewProgram(r).Run()
+	return errors.WithStack(err)
+}
+
+// textDeltaMsg carries a chunk of streamed assistant text.
+type textDeltaMsg string
+
+// toolCallMsg reports that the agent is calling a tool.
+type toolCallMsg struct {
+	name  string
+	input string
+}
+
+// approvalMsg asks the user whether a tool call may run; the answer is sent
+// back on reply.
+type approvalMsg struct {
+	req   llm.ApprovalRequest
+	reply chan llm.Approval
+}
+
+// turnDoneMsg is sent once the agent loop of a turn returns.
+type turnDoneMsg struct {
+	result *llm.Result
+	err    error
+}
+
+// repl is the bubbletea model of the interactive session. Finished output is
+// printed above the program with tea.Println; only the answer being streamed and
+// the input line are part of the view.
+type repl struct {
+	cfg    config.Config
+	model  llm.Model
+	system string
+	tools  []llm.Tool
+	debug  bool
+
+	conv  *llm.Conversation
+	usage llm.Usage
+	cost  float64
+
+	// store and record, when set, persist the conversation after every turn.
+	store  *session.Store
+	record *session.Session
+	// workspace, when set, confines the tools.
+	workspace *tool.Workspace
+	// processes are the background processes started during the session.
+	processes *tool.Processes
+
+	input   textinput.Model
+	width   int
+	pending string
+	running bool
+	asking  *approvalMsg
+	cancel  context.CancelFunc
+	events  chan tea.Msg
+}
+
+func newRepl(cfg config.Config, model llm.Model, system string, tools []llm.Tool, debug bool) *repl {
+	input := textinput.New()
+	input.Prompt = "> "
+	input.Placeholder = "Ask anything, or /help"
+	input.Focus()
+	return &repl{
+		cfg:    cfg,
+		model:  model,
+		system: system,
+		tools:  tools,
+		debug:  debug,
+		conv:   llm.NewConversation(llm.NewAgent(model, system, tools), nil),
+		input:  input,
+	}
+}
+
+func (r *repl) Init() tea.Cmd {
+	banner := fmt.Sprintf("rai %s/%s — type /help for commands", r.model.Provider(), r.model.Name())
+	if n := len(r.conv.Messages()); n > 0 && r.record != nil {
+		banner += fmt.Sprintf("\nResumed session %s (%d messages): %s", r.record.ShortID(), n, r.record.Title())
+	}
+	return tea.Println(banner)
+}
+
+func (r *repl) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		r.width = msg.Width
+		r.input.Width = msg.Width - len(r.input.Prompt) - 1
+		return r, nil
+	case tea.KeyMsg:
+		if r.asking != nil && msg.Type != tea.KeyCtrlC {
+			return r, r.answer(msg.String())
+		}
+		switch msg.Type {
+		case tea.KeyCtrlC:
+			if r.running {
+				r.cancel()
+				if r.asking != nil {
+					// The approver gives up on the cancelled context.
+					r.asking = nil
+					return r, r.wait()
+				}
+				return r, nil
+			}
+			return r, tea.Quit
+		case tea.KeyCtrlD:
+			if !r.running {
+				return r, tea.Quit
+			}
+		case tea.KeyEnter:
+			if r.running {
+				return r, nil
+			}
+			line := strings.TrimSpace(r.input.Value())
+			r.input.SetValue("")
+			if line == "" {
+				return r, nil
+			}
+			if strings.HasPrefix(line, "/") {
+				return r, r.command(line)
+			}
+			return r, r.send(line)
+		}
+		if r.running {
+			return r, nil
+		}
+	case textDeltaMsg:
+		r.pending += string(msg)
+		return r, r.wait()
+	case toolCallMsg:
+		out := r.flush()
+		out = append(out, fmt.Sprintf("Calling tool %s with input: %s", msg.name, msg.input))
+		return r, tea.Batch(tea.Println(strings.Join(out, "\n")), r.wait())
+	case approvalMsg:
+		r.asking = &msg
+		if out := r.flush(); len(out) > 0 {
+			return r, tea.Println(strings.Join(out, "\n"))
+		}
+		return r, nil
+	case turnDoneMsg:
+		return r, r.finish(msg)
+	}
+
+	var cmd tea.Cmd
+	r.input, cmd = r.input.Update(msg)
+	return r, cmd
+}
+
+func (r *repl) View() string {
+	var b strings.Builder
+	if r.pending != "" {
+		b.WriteString(r.wrap(r.pending))
+		b.WriteString("\n")
+	}
+	if r.asking != nil {
+		fmt.Fprintf(&b, "Allow %s? %s", r.asking.req, approvalKeys)
+	} else if r.running {
+		b.WriteString("… (ctrl+c to cancel)")
+	} else {
+		b.WriteString(r.input.View())
+	}
+	return b.String()
+}
+
+// send starts an agent turn for prompt in the background. Events produced by
+// the agent are delivered to Update one by one through r.events.
+func (r *repl) send(prompt string) tea.Cmd {
+	ctx := tool.ContextWithProcesses(tool.ContextWithWorkspace(context.Background(), r.workspace), r.processes)
+	ctx, cancel := context.WithCancel(ctx)
+	r.cancel = cancel
+	r.running = true
+	r.events = make(chan tea.Msg)
+
+	events := r.events
+	conv := r.conv
+	go func() {
+		res, err := conv.Send(ctx, prompt, llm.RunOptions{
+			MaxParallel: r.cfg.MaxParallelTools,
+			OnTextDelta: func(delta string) { events <- textDeltaMsg(delta) },
+			OnToolCall:  func(_, name, input string) { events <- toolCallMsg{name: name, input: input} },
+		})
+		events <- turnDoneMsg{result: res, err: err}
+	}()
+
+	return tea.Batch(tea.Println(r.input.Prompt+prompt), r.wait())
+}
+
+// approve asks the user, through the REPL, whether a tool call may run. It is
+// called from the agent goroutine of the running turn.
+func (r *repl) approve(ctx context.Context, req llm.ApprovalRequest) (llm.Approval, error) {
+	reply := make(chan llm.Approval, 1)
+	select {
+	case r.events <- approvalMsg{req: req, reply: reply}:
+	case <-ctx.Done():
+		return llm.RejectOnce, ctx.Err()
+	}
+	select {
+	case answer := <-reply:
+		return answer, nil
+	case <-ctx.Done():
+		return llm.RejectOnce, ctx.Err()
+	}
+}
+
+// answer handles a key pressed while an approval question is shown. Other
+// keys than the listed answers are ignored.
+func (r *repl) answer(key string) tea.Cmd {
+	answer, ok := approvalAnswer(key)
+	if !ok {
+		return nil
+	}
+	r.asking.reply <- answer
+	line := fmt.Sprintf("Allow %s? %s", r.asking.req, key)
+	r.asking = nil
+	return tea.Batch(tea.Println(line), r.wait())
+}
+
+// wait returns a command that delivers the next agent event.
+func (r *repl) wait() tea.Cmd {
+	events := r.events
+	return func() tea.Msg { return <-events }
+}
+
+// finish reports the outcome of a turn. A failed or cancelled turn leaves the
+// conversation untouched, so the prompt can simply be retried.
+func (r *repl) finish(msg turnDoneMsg) tea.Cmd {
+	r.running = false
+	r.cancel()
+
+	out := r.flush()
+	switch {
+	case errors.Is(msg.err, context.Canceled):
+		out = append(out, "(cancelled)")
+	case msg.err != nil:
+		out = append(out, "Error: "+msg.err.Error())
+	default:
+		if strings.TrimSpace(msg.result.Text) == "" {
+			out = append(out, "(no text returned by the model)")
+		}
+		out = append(out, r.stats(msg.result.Usage))
+		if err := r.save(); err != nil {
+			out = append(out, "Warning: session couldn't be saved: "+err.Error())
+		}
+	}
+	return tea.Println(strings.Join(out, "\n"))
+}
+
+// stats adds the usage of a turn to the session totals and describes both.
+// The cost is summed turn by turn, as the model may change in between.
+func (r *repl) stats(u llm.Usage) string {
+	r.usage = r.usage.Add(u)
+	if cost, known := llm.Cost(r.model.Provider(), r.model.Name(), u); known {
+		r.cost += cost
+	}
+
+	stats := fmt.Sprintf("[tokens in=%d out=%d", u.InputTokens, u.OutputTokens)
+	if u.CacheCreationTokens > 0 || u.CacheReadTokens > 0 {
+		stats += fmt.Sprintf(" cache write=%d read=%d", u.CacheCreationTokens, u.CacheReadTokens)
+	}
+	stats += fmt.Sprintf(", session total=%d", r.usage.TotalTokens)
+	if r.cost > 0 {
+		stats += fmt.Sprintf(", session cost $%.4f", r.cost)
+	}
+	return stats + "]"
+}
+
+// save stores the conversation in the session store, if there is one.
+func (r *repl) save() error {
+	if r.store == nil {
+		return nil
+	}
+	r.record.Messages = r.conv.Messages()
+	return r.store.Save(r.record)
+}
+
+// flush returns the streamed text not yet printed (if any) and resets it.
+func (r *repl) flush() []string {
+	if r.pending == "" {
+		return nil
+	}
+	text := r.wrap(r.pending)
+	r.pending = ""
+	return []string{text}
+}
+
+// wrap wraps text to the terminal width; the renderer would truncate longer
+// lines otherwise.
+func (r *repl) wrap(text string) string {
+	if r.width <= 0 {
+		return text
+	}
+	return ansi.Wrap(text, r.width, "")
+}
+
+// command executes a slash command typed at the prompt.
+func (r *repl) command(line string) tea.Cmd {
+	name, arg, _ := strings.Cut(strings.TrimPrefix(line, "/"), " ")
+	arg = strings.TrimSpace(arg)
+
+	switch name {
+	case "help":
+		return tea.Println(replHelp)
+	case "quit", "exit":
+		return tea.Quit
+	case "clear":
+		r.conv.Reset()
+		r.usage = llm.Usage{}
+		r.cost = 0
+		if r.record != nil {
+			// The cleared conversation stays stored; new turns go to a new session.
+			r.record = session.New(r.record.Model, r.system)
+		}
+		return tea.Println("Context cleared.")
+	case "tools":
+		if len(r.tools) == 0 {
+			return tea.Println("No tools enabled (start with --with-tools).")
+		}
+		var b strings.Builder
+		for _, t := range r.tools {
+			info := t.Info()
+			fmt.Fprintf(&b, "  %s: %s\n", info.Name, firstLine(info.Description))
+		}
+		return tea.Println(strings.TrimRight(b.String(), "\n"))
+	case "model":
+		if arg == "" {
+			return tea.Println(fmt.Sprintf("Current model: %s/%s", r.model.Provider(), r.model.Name()))
+		}
+		if err := r.switchModel(arg); err != nil {
+			return tea.Println("Error: " + err.Error())
+		}
+		return tea.Println(fmt.Sprintf("Switched to %s/%s.", r.model.Provider(), r.model.Name()))
+	default:
+		return tea.Println(fmt.Sprintf("Unknown command /%s. Type /help for the list of commands.", name))
+	}
+}
+
+// switchModel replaces the model used for the following turns. The
+// conversation history is provider neutral, so it carries over.
+func (r *repl) switchModel(name string) error {
+	mdl, err := llm.LookupModel(r.cfg, name)
+	if err != nil {
+		return err
+	}
+	if r.debug {
+		mdl.Debug = true
+	}
+	model, err := llm.NewModel(context.Background(), r.cfg, mdl)
+	if err != nil {
+		return err
+	}
+	if r.record != nil {
+		r.record.Model = mdl
+	}
+	r.model = model
+	r.conv = llm.NewConversation(llm.NewAgent(model, r.system, r.tools), r.conv.Messages())
+	return nil
+}
+
+// firstLine returns the first line of s.
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
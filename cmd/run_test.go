@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/elek/rai/config"
+	"github.com/elek/rai/llm"
+	"github.com/elek/rai/tool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeReplConfig returns a config with two models backed by the fake provider.
+func fakeReplConfig() config.Config {
+	return config.Config{
+		Providers: []config.Provider{{Name: "fake", Type: "fake"}},
+		Models: []config.Model{
+			{Name: "one", Provider: "fake", Model: "fake-1", Default: true},
+			{Name: "two", Provider: "fake", Model: "fake-2"},
+		},
+	}
+}
+
+func TestReplTurnKeepsHistory(t *testing.T) {
+	r := newRepl(fakeReplConfig(), llm.NewFakeModel("fake", "fake-1"), "", nil, false)
+	r.send("hello")
+
+	// Drain the agent events the same way the bubbletea runtime would.
+	for r.running {
+		r.Update(r.wait()())
+	}
+
+	history := r.conv.Messages()
+	require.Len(t, history, 2)
+	assert.Equal(t, llm.RoleUser, history[0].Role)
+	assert.Equal(t, llm.RoleAssistant, history[1].Role)
+	assert.Positive(t, r.usage.OutputTokens)
+	assert.Empty(t, r.pending)
+
+	r.send("and again")
+	for r.running {
+		r.Update(r.wait()())
+	}
+	assert.Len(t, r.conv.Messages(), 4, "the second turn must build on the first")
+}
+
+func TestReplClearForgetsHistory(t *testing.T) {
+	r := newRepl(fakeReplConfig(), llm.NewFakeModel("fake", "fake-1"), "", nil, false)
+	r.conv = llm.NewConversation(llm.NewAgent(r.model, "", nil), []llm.Message{llm.UserMessage("hi")})
+	r.usage = llm.Usage{TotalTokens: 10}
+
+	r.command("/clear")
+	assert.Empty(t, r.conv.Messages())
+	assert.Zero(t, r.usage.TotalTokens)
+}
+
+func TestReplModelSwitchKeepsHistory(t *testing.T) {
+	r := newRepl(fakeReplConfig(), llm.NewFakeModel("fake", "fake-1"), "", nil, false)
+	r.conv = llm.NewConversation(llm.NewAgent(r.model, "", nil), []llm.Message{llm.UserMessage("hi")})
+
+	r.command("/model two")
+	assert.Equal(t, "fake-2", r.model.Name())
+	assert.Len(t, r.conv.Messages(), 1)
+
+	r.command("/model missing")
+	assert.Equal(t, "fake-2", r.model.Name(), "an unknown model must not replace the current one")
+}
+
+func TestReplAsksBeforeToolCalls(t *testing.T) {
+	cfg := fakeReplConfig()
+	cfg.Permissions = config.Permissions{Rules: []config.PermissionRule{
+		{Tool: "git", Pattern: "git add *", Action: "allow"},
+		{Tool: "git", Action: "ask"},
+	}}
+	policy, err := llm.NewPolicy(cfg.Permissions)
+	require.NoError(t, err)
+
+	var commands []string
+	git := llm.NewTool[tool.GitInput]("git", "Runs git", func(_ context.Context, in tool.GitInput) (string, error) {
+		commands = append(commands, in.Command)
+		return "ok", nil
+	})
+
+	r := newRepl(cfg, llm.NewFakeModel("fake", "fake-1"), "", nil, false)
+	r.tools = policy.Guard([]llm.Tool{git}, r.approve)
+	r.conv = llm.NewConversation(llm.NewAgent(r.model, "", r.tools), nil)
+	r.send("commit my work")
+
+	var asked []string
+	for r.running {
+		r.Update(r.wait()())
+		if r.asking != nil {
+			asked = append(asked, r.asking.req.Subject)
+			assert.Contains(t, r.View(), "Allow git: git commit")
+			r.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("n")})
+		}
+	}
+
+	require.Len(t, asked, 1, "git add is allowed without asking")
+	assert.Contains(t, asked[0], "git commit -m")
+	assert.Equal(t, []string{"git add -A"}, commands, "the rejected commit must not run")
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/elek/rai/config"
+	"github.com/elek/rai/llm"
+	"github.com/elek/rai/session"
+	"github.com/elek/rai/tool"
+	"github.com/pkg/errors"
+)
+
+// Sessions implements the `rai sessions` CLI command group, which inspects the
+// conversations stored under ~/.config/rai/sessions.
+type Sessions struct {
+	List SessionsList `cmd:"" default:"1" help:"List stored sessions, most recent first."`
+	Show SessionsShow `cmd:"" help:"Print the transcript of a stored session."`
+	Rm   SessionsRm   `cmd:"" help:"Delete a stored session."`
+}
+
+type SessionsList struct {
+	Limit int `help:"Maximum number of sessions to list (0 lists all)." default:"20"`
+}
+
+func (l SessionsList) Run() error {
+	all, err := session.NewStore(session.DefaultDir()).List()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if len(all) == 0 {
+		fmt.Println("No stored sessions.")
+		return nil
+	}
+	for i, s := range all {
+		if l.Limit > 0 && i >= l.Limit {
+			fmt.Printf("... and %d more\n", len(all)-l.Limit)
+			break
+		}
+		fmt.Printf("%s  %s  %-30s %3d msgs  %s\n", s.ShortID(), s.Updated.Format(time.DateTime),
+			s.Model.Provider+"/"+s.Model.Model, len(s.Messages), s.Title())
+	}
+	return nil
+}
+
+type SessionsShow struct {
+	ID string `arg:"" help:"Session ID (or a unique prefix of it)."`
+}
+
+func (s SessionsShow) Run() error {
+	sess, err := session.NewStore(session.DefaultDir()).Load(s.ID)
+	if err != nil {
+		return err
+	}
+	return errors.WithStack(sess.WriteTranscript(os.Stdout))
+}
+
+type SessionsRm struct {
+	ID string `arg:"" help:"Session ID (or a unique prefix of it)."`
+}
+
+func (s SessionsRm) Run() error {
+	store := session.NewStore(session.DefaultDir())
+	sess, err := store.Load(s.ID)
+	if err != nil {
+		return err
+	}
+	return store.Delete(sess.ID)
+}
+
+// Resume implements the `rai resume` CLI command: it reopens a stored session
+// in the interactive REPL, optionally as a fork that leaves the original as it
+// was.
+type Resume struct {
+	llm.WithModel
+	tool.WithWorkspace
+	ID        string `arg:"" optional:"" help:"Session ID (or a unique prefix of it). Defaults to the most recent session."`
+	Fork      bool   `help:"Continue in a copy of the session instead of the session itself."`
+	WithTools bool   `help:"Enable all tools for the agent"`
+}
+
+func (r Resume) Run() error {
+	ctx := context.Background()
+
+	cfg, err := r.GetConfig()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	store := session.NewStore(session.DefaultDir())
+	var rec *session.Session
+	if r.ID == "" {
+		rec, err = store.Latest()
+	} else {
+		rec, err = store.Load(r.ID)
+	}
+	if err != nil {
+		return err
+	}
+	if r.Fork {
+		rec = rec.Fork()
+	}
+
+	// --model switches the resumed conversation to another model.
+	mdl, err := r.ResolveModel(cfg)
+	if err != nil {
+		return err
+	}
+	if mdl != (config.Model{}) {
+		rec.Model = mdl
+	}
+
+	var tools []llm.Tool
+	if r.WithTools {
+		tools = tool.AllTools()
+	}
+
+	ctx, err = r.WorkspaceContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return runRepl(ctx, cfg, rec, tools, r.Debug)
+}
@@ -1,8 +1,30 @@
 package config
 
+import "time"
+
 type Config struct {
 	Providers []Provider `yaml:"providers"`
 	Models    []Model    `yaml:"models"`
+	// MaxParallelTools bounds how many read-only tool calls of one model turn
+	// run at the same time. Zero means the default; one disables concurrency.
+	MaxParallelTools int `yaml:"max_parallel_tools"`
+	// Permissions decides which tool calls run, which are refused, and which
+	// need the user's approval first.
+	Permissions Permissions `yaml:"permissions"`
+	// LSP configures the language servers started on demand by <lsp/>.
+	LSP LSP `yaml:"lsp"`
+	// MCP are the MCP servers templates can use by name.
+	MCP []MCPServer `yaml:"mcp"`
+}
+
+// FindMCPServer returns the MCP server with the given name.
+func (c Config) FindMCPServer(name string) (MCPServer, bool) {
+	for _, s := range c.MCP {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return MCPServer{}, false
 }
 
 func (c Config) FindProvider(name string) (Provider, bool) {
@@ -36,18 +58,110 @@ type Provider struct {
 	Name           string `yaml:"name"`
 	Type           string `yaml:"type"`
 	Key            string `yaml:"key"`
-	Project        string `yaml:"location"`
-	Location       string `yaml:"project"`
+	Project        string `yaml:"project"`
+	Location       string `yaml:"location"`
 	CredentialFile string `yaml:"credential_file"`
 	Endpoint       string `yaml:"endpoint"`
+	// ToolCalls selects how local providers (ollama, llamacpp) call tools:
+	// "auto" (the default), "native", or "prompt".
+	ToolCalls string `yaml:"tool_calls"`
 }
 
 type Model struct {
-	Name        string  `yaml:"name"`
-	Provider    string  `yaml:"provider"`
-	Model       string  `yaml:"model"`
-	MaxToken    int     `yaml:"max_token"`
-	Debug       bool    `yaml:"debug"`
-	Temperature float64 `yaml:"temperature"`
-	Default     bool    `yaml:"default"`
+	Name        string  `yaml:"name" json:"name"`
+	Provider    string  `yaml:"provider" json:"provider"`
+	Model       string  `yaml:"model" json:"model"`
+	MaxToken    int     `yaml:"max_token" json:"max_token,omitempty"`
+	Debug       bool    `yaml:"debug" json:"debug,omitempty"`
+	Temperature float64 `yaml:"temperature" json:"temperature,omitempty"`
+	Default     bool    `yaml:"default" json:"default,omitempty"`
+	// ThinkingBudget enables extended thinking with this many tokens on models
+	// that support it (Anthropic).
+	ThinkingBudget int `yaml:"thinking_budget" json:"thinking_budget,omitempty"`
+	// Routing holds OpenRouter routing preferences; other providers ignore it.
+	Routing *Routing `yaml:"routing" json:"routing,omitempty"`
+}
+
+// Routing configures how OpenRouter routes the requests of a model: which
+// upstream providers to prefer, and which models to fall back to.
+type Routing struct {
+	// Order lists upstream providers (such as "anthropic" or "together") to try
+	// first, in order.
+	Order []string `yaml:"order" json:"order,omitempty"`
+	// Only restricts routing to these upstream providers.
+	Only []string `yaml:"only" json:"only,omitempty"`
+	// Ignore excludes these upstream providers.
+	Ignore []string `yaml:"ignore" json:"ignore,omitempty"`
+	// AllowFallbacks controls whether providers outside Order may be used when
+	// the listed ones fail. OpenRouter allows them by default.
+	AllowFallbacks *bool `yaml:"allow_fallbacks" json:"allow_fallbacks,omitempty"`
+	// Sort ranks providers by "price", "throughput", or "latency" instead of
+	// OpenRouter's default load balancing.
+	Sort string `yaml:"sort" json:"sort,omitempty"`
+	// Fallbacks lists models to try, in order, when the primary model is
+	// unavailable or refuses the request.
+	Fallbacks []string `yaml:"fallbacks" json:"fallbacks,omitempty"`
+}
+
+// Permissions is the tool-call policy. Rules are checked in order and the first
+// matching one decides; calls no rule matches get Default.
+type Permissions struct {
+	// Default is "allow" (when empty), "ask", or "deny".
+	Default string           `yaml:"default"`
+	Rules   []PermissionRule `yaml:"rules"`
+}
+
+// PermissionRule decides about the calls of the tools matching Tool, and, when
+// Pattern is set, only about the calls whose command (bash, git) or path (file
+// tools) matches it. Both are globs where * matches any text, including spaces
+// and slashes.
+type PermissionRule struct {
+	Tool    string `yaml:"tool"`
+	Pattern string `yaml:"pattern"`
+	// Action is "allow", "ask", or "deny".
+	Action string `yaml:"action"`
+}
+
+// LSP configures the language servers that are selected by the extension of
+// the files the tools work on.
+type LSP struct {
+	// Servers are checked before the built-in ones, so they can replace the
+	// server of a language.
+	Servers []LanguageServer `yaml:"servers"`
+	// IdleTimeout stops servers that weren't used for this long. Zero means
+	// the default (5 minutes).
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+}
+
+// LanguageServer is a language server and the files it is used for.
+type LanguageServer struct {
+	// Name is the name of the server in the LSP server registry, e.g. gopls.
+	Name string `yaml:"name"`
+	// Extensions are the file extensions the server handles, e.g. ".go".
+	Extensions []string `yaml:"extensions"`
+	// RootMarkers are files that mark the root of a project, e.g. go.mod. The
+	// server is started in the closest directory above a file holding one.
+	RootMarkers []string `yaml:"root_markers"`
+}
+
+// MCPServer is an MCP server, started with Command or reached at URL. Headers
+// and BearerToken may refer to environment variables as $VAR or ${VAR}, so
+// secrets don't have to be written in the config.
+type MCPServer struct {
+	Name string `yaml:"name"`
+	// Command is the command line of a server speaking over stdin/stdout.
+	Command string `yaml:"command"`
+	URL     string `yaml:"url"`
+	// Transport is "streamable" or "sse"; empty tries streamable HTTP first
+	// and falls back to SSE.
+	Transport string            `yaml:"transport"`
+	Headers   map[string]string `yaml:"headers"`
+	// BearerToken is sent as "Authorization: Bearer <token>".
+	BearerToken string `yaml:"bearer_token"`
+	// Alias prefixes the tools of the server, as alias_tool; Name is used
+	// when it's empty.
+	Alias string `yaml:"alias"`
+	// Allow and Deny are globs of the tool names to offer and to hide.
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
 }
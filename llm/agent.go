@@ -2,6 +2,7 @@ package llm
 
 import (
 	"context"
+	"sync"
 
 	"github.com/pkg/errors"
 )
@@ -10,6 +11,10 @@ import (
 // against a model that keeps requesting tools indefinitely.
 const defaultMaxSteps = 50
 
+// defaultMaxParallel bounds how many parallel-safe tool calls run at the same
+// time when RunOptions.MaxParallel is not set.
+const defaultMaxParallel = 4
+
 // Agent drives a multi-turn tool-calling loop on top of a Model.
 type Agent struct {
 	model  Model
@@ -26,17 +31,30 @@ func NewAgent(model Model, system string, tools []Tool) *Agent {
 type RunOptions struct {
 	// OnTextDelta is called for each streamed chunk of assistant text.
 	OnTextDelta func(delta string)
-	// OnToolCall is called when the assistant requests a tool, with the tool
-	// name and its raw JSON input.
-	OnToolCall func(name, input string)
+	// OnToolCall is called when the assistant requests a tool, with the ID of
+	// the call, the tool name and its raw JSON input.
+	OnToolCall func(id, name, input string)
+	// OnThinkingDelta is called for each streamed chunk of the model's
+	// reasoning, for models that expose it.
+	OnThinkingDelta func(delta string)
 	// MaxSteps bounds the number of model turns. Zero uses defaultMaxSteps.
 	MaxSteps int
+	// MaxParallel bounds how many tool calls of one turn run concurrently. Only
+	// tools declared Parallel run concurrently at all. Zero uses
+	// defaultMaxParallel; one runs every call in turn.
+	MaxParallel int
 }
 
 // Result is the outcome of an agent Run.
 type Result struct {
 	Text  string
 	Usage Usage
+	// StopReason is why the last turn ended; StopMaxTokens when the answer
+	// was cut off at the token limit.
+	StopReason StopReason
+	// Messages is the full conversation after the run: the messages the run
+	// started from followed by every assistant and tool turn it produced.
+	Messages []Message
 }
 
 // Run sends prompt to the model and loops: each turn, it streams the assistant
@@ -44,6 +62,13 @@ type Result struct {
 // results back. It returns once the model stops requesting tools, or errors if
 // MaxSteps is exceeded.
 func (a *Agent) Run(ctx context.Context, prompt string, opts RunOptions) (*Result, error) {
+	return a.Continue(ctx, []Message{UserMessage(prompt)}, opts)
+}
+
+// Continue runs the same loop as Run on an existing conversation, whose last
+// message is normally the new user turn. The returned Result carries the
+// extended history so the caller can pass it back on the next turn.
+func (a *Agent) Continue(ctx context.Context, history []Message, opts RunOptions) (*Result, error) {
 	maxSteps := opts.MaxSteps
 	if maxSteps <= 0 {
 		maxSteps = defaultMaxSteps
@@ -54,7 +79,7 @@ func (a *Agent) Run(ctx context.Context, prompt string, opts RunOptions) (*Resul
 		byName[t.Info().Name] = t
 	}
 
-	messages := []Message{UserMessage(prompt)}
+	messages := append([]Message(nil), history...)
 	var (
 		usage    Usage
 		lastText string
@@ -62,9 +87,10 @@ func (a *Agent) Run(ctx context.Context, prompt string, opts RunOptions) (*Resul
 
 	for step := 0; step < maxSteps; step++ {
 		turn, err := a.model.Stream(ctx, Request{
-			System:   a.system,
-			Messages: messages,
-			Tools:    a.tools,
+			System:     a.system,
+			Messages:   messages,
+			Tools:      a.tools,
+			OnThinking: opts.OnThinkingDelta,
 		}, opts.OnTextDelta)
 		if err != nil {
 			return nil, errors.WithStack(err)
@@ -77,23 +103,53 @@ func (a *Agent) Run(ctx context.Context, prompt string, opts RunOptions) (*Resul
 
 		toolUses := toolUseBlocks(turn.Blocks)
 		if turn.StopReason != StopToolUse && len(toolUses) == 0 {
-			return &Result{Text: lastText, Usage: usage}, nil
+			return &Result{Text: lastText, Usage: usage, StopReason: turn.StopReason, Messages: messages}, nil
 		}
 
-		// Execute each requested tool and collect the results into one tool turn.
-		results := make([]Block, 0, len(toolUses))
-		for _, tu := range toolUses {
-			if opts.OnToolCall != nil {
-				opts.OnToolCall(tu.ToolName, tu.Input)
-			}
-			results = append(results, a.runTool(ctx, byName, tu))
-		}
+		// Execute the requested tools and collect the results into one tool turn.
+		results := a.runTools(ctx, byName, toolUses, opts)
 		messages = append(messages, Message{Role: RoleTool, Blocks: results})
 	}
 
 	return nil, errors.Errorf("agent exceeded max steps (%d) without completing", maxSteps)
 }
 
+// runTools executes the tool calls of a turn and returns their results in
+// request order. Consecutive calls of parallel-safe tools run concurrently, at
+// most opts.MaxParallel at a time. Any other call first waits for the calls
+// before it and then runs alone, so a write never races with the calls around
+// it.
+func (a *Agent) runTools(ctx context.Context, byName map[string]Tool, calls []Block, opts RunOptions) []Block {
+	limit := opts.MaxParallel
+	if limit <= 0 {
+		limit = defaultMaxParallel
+	}
+
+	results := make([]Block, len(calls))
+	slots := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i, tu := range calls {
+		if opts.OnToolCall != nil {
+			opts.OnToolCall(tu.ToolCallID, tu.ToolName, tu.Input)
+		}
+		tool, ok := byName[tu.ToolName]
+		if !ok || !tool.Info().Parallel || limit == 1 {
+			wg.Wait()
+			results[i] = a.runTool(ctx, byName, tu)
+			continue
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = a.runTool(ctx, byName, tu)
+			<-slots
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
 // runTool invokes the named tool and returns a tool_result block.
 func (a *Agent) runTool(ctx context.Context, byName map[string]Tool, tu Block) Block {
 	tool, ok := byName[tu.ToolName]
@@ -2,7 +2,10 @@ package llm
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -77,7 +80,7 @@ func TestAgentExecutesToolThenReturnsFinalText(t *testing.T) {
 	agent := NewAgent(model, "sys", []Tool{echo})
 	var toolCalls []string
 	res, err := agent.Run(context.Background(), "go", RunOptions{
-		OnToolCall: func(name, input string) { toolCalls = append(toolCalls, name) },
+		OnToolCall: func(_, name, input string) { toolCalls = append(toolCalls, name) },
 	})
 	require.NoError(t, err)
 
@@ -115,3 +118,111 @@ func TestAgentStopsAtMaxSteps(t *testing.T) {
 	assert.Contains(t, err.Error(), "max steps")
 	assert.Equal(t, 3, model.calls)
 }
+
+func TestAgentContinueExtendsHistory(t *testing.T) {
+	model := &scriptedModel{turns: []*Turn{
+		{Blocks: []Block{TextBlock("second answer")}, StopReason: StopEnd},
+	}}
+
+	history := []Message{
+		UserMessage("first question"),
+		{Role: RoleAssistant, Blocks: []Block{TextBlock("first answer")}},
+		UserMessage("second question"),
+	}
+	agent := NewAgent(model, "", nil)
+	res, err := agent.Continue(context.Background(), history, RunOptions{})
+	require.NoError(t, err)
+
+	// The model sees the earlier turns, and the result extends them.
+	assert.Len(t, model.lastRequest.Messages, 3)
+	require.Len(t, res.Messages, 4)
+	assert.Equal(t, history, res.Messages[:3])
+	assert.Equal(t, "second answer", textOf(res.Messages[3].Blocks))
+	assert.Len(t, history, 3, "the caller's slice must not be modified")
+}
+
+// concurrencyProbe is a tool callback that records how many calls run at the
+// same time.
+type concurrencyProbe struct {
+	mu      sync.Mutex
+	active  int
+	maxSeen int
+	// overlaps records, per input, how many other calls were running when it
+	// started.
+	overlaps map[string]int
+}
+
+type probeIn struct {
+	Text string `json:"text"`
+}
+
+func (p *concurrencyProbe) run(_ context.Context, in probeIn) (string, error) {
+	p.mu.Lock()
+	p.overlaps[in.Text] = p.active
+	p.active++
+	p.maxSeen = max(p.maxSeen, p.active)
+	p.mu.Unlock()
+
+	time.Sleep(30 * time.Millisecond)
+
+	p.mu.Lock()
+	p.active--
+	p.mu.Unlock()
+	return "done " + in.Text, nil
+}
+
+func probeCalls(names ...string) *Turn {
+	turn := &Turn{StopReason: StopToolUse}
+	for i, name := range names {
+		turn.Blocks = append(turn.Blocks, Block{
+			Type:       BlockToolUse,
+			ToolCallID: fmt.Sprintf("call-%d", i),
+			ToolName:   name,
+			Input:      fmt.Sprintf(`{"text":"%d"}`, i),
+		})
+	}
+	return turn
+}
+
+func TestAgentRunsParallelToolsConcurrentlyInOrder(t *testing.T) {
+	probe := &concurrencyProbe{overlaps: map[string]int{}}
+	read := Parallel(NewTool("read", "reads", probe.run))
+	model := &scriptedModel{turns: []*Turn{
+		probeCalls("read", "read", "read", "read", "read"),
+		{Blocks: []Block{TextBlock("ok")}, StopReason: StopEnd},
+	}}
+
+	var calls []string
+	res, err := NewAgent(model, "", []Tool{read}).Run(context.Background(), "go", RunOptions{
+		MaxParallel: 3,
+		OnToolCall:  func(_, name, input string) { calls = append(calls, input) },
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "ok", res.Text)
+	assert.Equal(t, 3, probe.maxSeen, "at most MaxParallel calls run at once")
+	assert.Len(t, calls, 5)
+
+	results := res.Messages[2].Blocks
+	require.Len(t, results, 5)
+	for i, r := range results {
+		assert.Equal(t, fmt.Sprintf("call-%d", i), r.ToolCallID)
+		assert.Equal(t, fmt.Sprintf("done %d", i), r.Text)
+	}
+}
+
+func TestAgentRunsUnsafeToolsAlone(t *testing.T) {
+	probe := &concurrencyProbe{overlaps: map[string]int{}}
+	read := Parallel(NewTool("read", "reads", probe.run))
+	write := NewTool("write", "writes", probe.run)
+	model := &scriptedModel{turns: []*Turn{
+		probeCalls("read", "read", "write", "read", "write"),
+		{Blocks: []Block{TextBlock("ok")}, StopReason: StopEnd},
+	}}
+
+	_, err := NewAgent(model, "", []Tool{read, write}).Run(context.Background(), "go", RunOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, probe.maxSeen, "the two leading reads overlap")
+	assert.Zero(t, probe.overlaps["2"], "a write waits for the reads before it")
+	assert.Zero(t, probe.overlaps["3"], "a read waits for the write before it")
+	assert.Zero(t, probe.overlaps["4"])
+}
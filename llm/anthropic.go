@@ -12,17 +12,25 @@ import (
 // defaultMaxTokens is used when a model config does not specify a token limit.
 const defaultMaxTokens int64 = 4096
 
+// minThinkingBudget is the smallest thinking budget the API accepts.
+const minThinkingBudget int64 = 1024
+
 // anthropicModel implements Model against the official Anthropic SDK.
 type anthropicModel struct {
 	client    anthropic.Client
 	model     string
 	maxTokens int64
-	debug     bool
+	// thinkingBudget is the number of tokens the model may spend on extended
+	// thinking; zero disables thinking.
+	thinkingBudget int64
+	debug          bool
 }
 
-// NewAnthropicModel creates a Model backed by the Anthropic Messages API. When
-// debug is true, every request and response is traced to stderr.
-func NewAnthropicModel(apiKey, baseURL, model string, maxTokens int64, debug bool) Model {
+// NewAnthropicModel creates a Model backed by the Anthropic Messages API. A
+// positive thinkingBudget enables extended thinking with that many tokens (at
+// least minThinkingBudget). When debug is true, every request and response is
+// traced to stderr.
+func NewAnthropicModel(apiKey, baseURL, model string, maxTokens, thinkingBudget int64, debug bool) Model {
 	opts := []option.RequestOption{option.WithAPIKey(apiKey)}
 	if baseURL != "" {
 		opts = append(opts, option.WithBaseURL(baseURL))
@@ -30,11 +38,20 @@ func NewAnthropicModel(apiKey, baseURL, model string, maxTokens int64, debug boo
 	if maxTokens <= 0 {
 		maxTokens = defaultMaxTokens
 	}
+	if thinkingBudget > 0 {
+		thinkingBudget = max(thinkingBudget, minThinkingBudget)
+		// The budget is part of max_tokens, which must leave room for the
+		// answer itself.
+		if maxTokens <= thinkingBudget {
+			maxTokens = thinkingBudget + defaultMaxTokens
+		}
+	}
 	return &anthropicModel{
-		client:    anthropic.NewClient(opts...),
-		model:     model,
-		maxTokens: maxTokens,
-		debug:     debug,
+		client:         anthropic.NewClient(opts...),
+		model:          model,
+		maxTokens:      maxTokens,
+		thinkingBudget: thinkingBudget,
+		debug:          debug,
 	}
 }
 
@@ -54,11 +71,15 @@ func (m *anthropicModel) Stream(ctx context.Context, req Request, onText func(de
 		params.Tools = toAnthropicTools(req.Tools)
 	}
 	// Temperature is only sent when explicitly set; the adaptive Opus models
-	// reject the parameter entirely.
-	if req.Temperature > 0 {
+	// reject the parameter entirely, and so does extended thinking.
+	if m.thinkingBudget > 0 {
+		params.Thinking = anthropic.ThinkingConfigParamOfEnabled(m.thinkingBudget)
+	} else if req.Temperature > 0 {
 		params.Temperature = anthropic.Float(req.Temperature)
 	}
 
+	setCacheBreakpoints(&params)
+
 	if m.debug {
 		debugRequest(m.Provider(), m.model, req)
 	}
@@ -70,10 +91,15 @@ func (m *anthropicModel) Stream(ctx context.Context, req Request, onText func(de
 		if err := message.Accumulate(event); err != nil {
 			return nil, errors.WithStack(err)
 		}
-		if onText != nil {
-			if delta, ok := event.AsAny().(anthropic.ContentBlockDeltaEvent); ok {
-				if td, ok := delta.Delta.AsAny().(anthropic.TextDelta); ok {
-					onText(td.Text)
+		if delta, ok := event.AsAny().(anthropic.ContentBlockDeltaEvent); ok {
+			switch d := delta.Delta.AsAny().(type) {
+			case anthropic.TextDelta:
+				if onText != nil {
+					onText(d.Text)
+				}
+			case anthropic.ThinkingDelta:
+				if req.OnThinking != nil {
+					req.OnThinking(d.Thinking)
 				}
 			}
 		}
@@ -89,8 +115,35 @@ func (m *anthropicModel) Stream(ctx context.Context, req Request, onText func(de
 	return turn, nil
 }
 
+// setCacheBreakpoints marks the end of the tool definitions, of the system
+// prompt, and of the conversation as cache breakpoints. The prompt is cached in
+// that order, so the stable tools and system prompt are read from the cache
+// even when the conversation changes, and each turn of an agent loop reuses the
+// prefix written by the turn before it. Prompts shorter than the model's
+// minimum cacheable length are simply not cached.
+func setCacheBreakpoints(params *anthropic.MessageNewParams) {
+	if n := len(params.Tools); n > 0 {
+		if cc := params.Tools[n-1].GetCacheControl(); cc != nil {
+			*cc = anthropic.NewCacheControlEphemeralParam()
+		}
+	}
+	if n := len(params.System); n > 0 {
+		params.System[n-1].CacheControl = anthropic.NewCacheControlEphemeralParam()
+	}
+	if n := len(params.Messages); n > 0 {
+		last := params.Messages[n-1].Content
+		if k := len(last); k > 0 {
+			if cc := last[k-1].GetCacheControl(); cc != nil {
+				*cc = anthropic.NewCacheControlEphemeralParam()
+			}
+		}
+	}
+}
+
 // toAnthropicMessages converts neutral messages into Anthropic message params.
 // Tool results are carried in a user-role message, per the Anthropic API.
+// Thinking blocks are sent back unchanged (with their signatures), as the API
+// requires within a tool-use loop; reasoning of other providers is dropped.
 func toAnthropicMessages(msgs []Message) []anthropic.MessageParam {
 	out := make([]anthropic.MessageParam, 0, len(msgs))
 	for _, msg := range msgs {
@@ -107,6 +160,13 @@ func toAnthropicMessages(msgs []Message) []anthropic.MessageParam {
 				blocks = append(blocks, anthropic.NewToolUseBlock(b.ToolCallID, input, b.ToolName))
 			case BlockToolResult:
 				blocks = append(blocks, anthropic.NewToolResultBlock(b.ToolCallID, b.Text, b.IsError))
+			case BlockReasoning:
+				switch b.Kind() {
+				case ReasoningRedacted:
+					blocks = append(blocks, anthropic.NewRedactedThinkingBlock(b.Text))
+				case ReasoningThinking:
+					blocks = append(blocks, anthropic.NewThinkingBlock(b.Signature, b.Text))
+				}
 			}
 		}
 		switch msg.Role {
@@ -146,6 +206,10 @@ func turnFromAnthropic(message *anthropic.Message) *Turn {
 		switch v := block.AsAny().(type) {
 		case anthropic.TextBlock:
 			blocks = append(blocks, TextBlock(v.Text))
+		case anthropic.ThinkingBlock:
+			blocks = append(blocks, Block{Type: BlockReasoning, Reasoning: ReasoningThinking, Text: v.Thinking, Signature: v.Signature})
+		case anthropic.RedactedThinkingBlock:
+			blocks = append(blocks, Block{Type: BlockReasoning, Reasoning: ReasoningRedacted, Text: v.Data})
 		case anthropic.ToolUseBlock:
 			blocks = append(blocks, Block{
 				Type:       BlockToolUse,
@@ -164,9 +228,12 @@ func turnFromAnthropic(message *anthropic.Message) *Turn {
 	return &Turn{
 		Blocks: blocks,
 		Usage: Usage{
-			InputTokens:  message.Usage.InputTokens,
-			OutputTokens: message.Usage.OutputTokens,
-			TotalTokens:  message.Usage.InputTokens + message.Usage.OutputTokens,
+			InputTokens:         message.Usage.InputTokens,
+			OutputTokens:        message.Usage.OutputTokens,
+			CacheCreationTokens: message.Usage.CacheCreationInputTokens,
+			CacheReadTokens:     message.Usage.CacheReadInputTokens,
+			TotalTokens: message.Usage.InputTokens + message.Usage.CacheCreationInputTokens +
+				message.Usage.CacheReadInputTokens + message.Usage.OutputTokens,
 		},
 		StopReason: stop,
 	}
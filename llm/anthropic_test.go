@@ -0,0 +1,160 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// anthropicEvents renders Anthropic stream events as a server-sent event body.
+func anthropicEvents(events ...string) string {
+	var b strings.Builder
+	for _, e := range events {
+		var head struct {
+			Type string `json:"type"`
+		}
+		_ = json.Unmarshal([]byte(e), &head)
+		b.WriteString("event: " + head.Type + "\ndata: " + e + "\n\n")
+	}
+	return b.String()
+}
+
+func TestAnthropicCachesPromptPrefixAndReportsCacheUsage(t *testing.T) {
+	var body map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, &body))
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, anthropicEvents(
+			`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-test","content":[],"stop_reason":null,"usage":{"input_tokens":5,"cache_creation_input_tokens":1200,"cache_read_input_tokens":3000,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":7}}`,
+			`{"type":"message_stop"}`,
+		))
+	}))
+	t.Cleanup(srv.Close)
+
+	type in struct {
+		Text string `json:"text"`
+	}
+	noop := func(context.Context, in) (string, error) { return "", nil }
+	m := NewAnthropicModel("test-key", srv.URL, "claude-test", 0, 0, false)
+	turn, err := m.Stream(context.Background(), Request{
+		System: "You are helpful.",
+		Messages: []Message{
+			UserMessage("first"),
+			{Role: RoleAssistant, Blocks: []Block{{Type: BlockToolUse, ToolCallID: "t1", ToolName: "a", Input: `{}`}}},
+			{Role: RoleTool, Blocks: []Block{{Type: BlockToolResult, ToolCallID: "t1", Text: "ok"}}},
+		},
+		Tools: []Tool{NewTool("a", "first tool", noop), NewTool("b", "second tool", noop)},
+	}, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Hi", textOf(turn.Blocks))
+	assert.Equal(t, Usage{
+		InputTokens:         5,
+		OutputTokens:        7,
+		CacheCreationTokens: 1200,
+		CacheReadTokens:     3000,
+		TotalTokens:         4212,
+	}, turn.Usage)
+
+	ephemeral := map[string]any{"type": "ephemeral"}
+	tools := body["tools"].([]any)
+	assert.NotContains(t, tools[0], "cache_control")
+	assert.Equal(t, ephemeral, tools[1].(map[string]any)["cache_control"])
+	assert.Equal(t, ephemeral, body["system"].([]any)[0].(map[string]any)["cache_control"])
+
+	messages := body["messages"].([]any)
+	for _, msg := range messages[:2] {
+		for _, block := range msg.(map[string]any)["content"].([]any) {
+			assert.NotContains(t, block, "cache_control")
+		}
+	}
+	last := messages[2].(map[string]any)["content"].([]any)
+	assert.Equal(t, ephemeral, last[len(last)-1].(map[string]any)["cache_control"])
+}
+
+func TestAnthropicThinkingRoundTrip(t *testing.T) {
+	var bodies []map[string]any
+	responses := []string{
+		anthropicEvents(
+			`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-test","content":[],"stop_reason":null,"usage":{"input_tokens":10,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":"","signature":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"Need the "}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"log."}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"SIG"}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"content_block_start","index":1,"content_block":{"type":"redacted_thinking","data":"REDACTED"}}`,
+			`{"type":"content_block_stop","index":1}`,
+			`{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_1","name":"echo","input":{}}}`,
+			`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{\"text\":\"hi\"}"}}`,
+			`{"type":"content_block_stop","index":2}`,
+			`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":20}}`,
+			`{"type":"message_stop"}`,
+		),
+		anthropicEvents(
+			`{"type":"message_start","message":{"id":"msg_2","type":"message","role":"assistant","model":"claude-test","content":[],"stop_reason":null,"usage":{"input_tokens":30,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"done"}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":2}}`,
+			`{"type":"message_stop"}`,
+		),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		bodies = append(bodies, body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, responses[len(bodies)-1])
+	}))
+	t.Cleanup(srv.Close)
+
+	type in struct {
+		Text string `json:"text"`
+	}
+	echo := NewTool("echo", "echoes", func(_ context.Context, v in) (string, error) { return v.Text, nil })
+	m := NewAnthropicModel("test-key", srv.URL, "claude-test", 2000, 2048, false)
+
+	var thinking strings.Builder
+	res, err := NewAgent(m, "", []Tool{echo}).Run(context.Background(), "go", RunOptions{
+		OnThinkingDelta: func(d string) { thinking.WriteString(d) },
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "done", res.Text)
+	assert.Equal(t, "Need the log.", thinking.String())
+
+	// Thinking is requested with the budget, leaving room for the answer.
+	assert.Equal(t, map[string]any{"type": "enabled", "budget_tokens": float64(2048)}, bodies[0]["thinking"])
+	assert.Greater(t, bodies[0]["max_tokens"].(float64), float64(2048))
+	assert.NotContains(t, bodies[0], "temperature")
+
+	// The signed and the redacted thinking go back ahead of the tool call.
+	assistant := bodies[1]["messages"].([]any)[1].(map[string]any)["content"].([]any)
+	require.Len(t, assistant, 3)
+	assert.Equal(t, map[string]any{"type": "thinking", "thinking": "Need the log.", "signature": "SIG"}, assistant[0])
+	assert.Equal(t, map[string]any{"type": "redacted_thinking", "data": "REDACTED"}, assistant[1])
+	assert.Equal(t, "tool_use", assistant[2].(map[string]any)["type"])
+}
+
+func TestReasoningKindOfStoredBlocks(t *testing.T) {
+	// Blocks stored before the kind was recorded.
+	assert.Equal(t, ReasoningThinking, Block{Type: BlockReasoning, Text: "hm", Signature: "SIG"}.Kind())
+	assert.Equal(t, ReasoningRedacted, Block{Type: BlockReasoning, Text: "DATA", Redacted: true}.Kind())
+	assert.Equal(t, ReasoningThoughtSignature, Block{Type: BlockReasoning, ToolCallID: "c1", Signature: "SIG"}.Kind())
+	assert.Equal(t, ReasoningEncrypted, Block{Type: BlockReasoning, ToolCallID: "rs_1", Text: "ENC"}.Kind())
+
+	// The recorded kind wins over the fields.
+	assert.Equal(t, ReasoningThoughtSignature, Block{Type: BlockReasoning, Reasoning: ReasoningThoughtSignature, Signature: "SIG"}.Kind())
+}
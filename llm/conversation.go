@@ -0,0 +1,53 @@
+package llm
+
+import "context"
+
+// Conversation is a multi-turn session with an Agent. Unlike Agent.Run, which
+// starts from an empty history every time, each Send builds on the messages of
+// the previous ones, so the model keeps the context of the whole session.
+//
+// A Conversation is not safe for concurrent use; callers serialize Sends.
+type Conversation struct {
+	agent    *Agent
+	messages []Message
+	usage    Usage
+}
+
+// NewConversation creates a conversation driven by agent. A non-empty history
+// (for example a restored transcript) is used as the starting context.
+func NewConversation(agent *Agent, history []Message) *Conversation {
+	return &Conversation{agent: agent, messages: append([]Message(nil), history...)}
+}
+
+// Send appends prompt as a new user turn and runs the agent loop on the whole
+// history. On success the assistant and tool turns produced by the run are
+// appended to the conversation. When the run fails (or is cancelled) the
+// conversation is left as it was before the call, so it never ends with a
+// dangling tool request the provider would reject on the next turn.
+func (c *Conversation) Send(ctx context.Context, prompt string, opts RunOptions) (*Result, error) {
+	history := append(c.Messages(), UserMessage(prompt))
+	res, err := c.agent.Continue(ctx, history, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.messages = res.Messages
+	c.usage = c.usage.Add(res.Usage)
+	return res, nil
+}
+
+// Messages returns a copy of the conversation so far: every user, assistant,
+// and tool message with all of their blocks.
+func (c *Conversation) Messages() []Message {
+	return append([]Message(nil), c.messages...)
+}
+
+// Usage returns the token usage accumulated over all Sends.
+func (c *Conversation) Usage() Usage {
+	return c.usage
+}
+
+// Reset forgets the history and the accumulated usage.
+func (c *Conversation) Reset() {
+	c.messages = nil
+	c.usage = Usage{}
+}
@@ -0,0 +1,65 @@
+package llm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConversationKeepsHistoryAcrossSends(t *testing.T) {
+	model := &scriptedModel{turns: []*Turn{
+		{Blocks: []Block{TextBlock("Madrid")}, StopReason: StopEnd, Usage: Usage{TotalTokens: 5}},
+		{Blocks: []Block{TextBlock("Paris")}, StopReason: StopEnd, Usage: Usage{TotalTokens: 7}},
+	}}
+	conv := NewConversation(NewAgent(model, "", nil), nil)
+
+	_, err := conv.Send(context.Background(), "Capital of Spain?", RunOptions{})
+	require.NoError(t, err)
+	res, err := conv.Send(context.Background(), "And of France?", RunOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "Paris", res.Text)
+
+	// The second request carries the first exchange.
+	require.Len(t, model.lastRequest.Messages, 3)
+	assert.Equal(t, "Capital of Spain?", textOf(model.lastRequest.Messages[0].Blocks))
+	assert.Equal(t, "Madrid", textOf(model.lastRequest.Messages[1].Blocks))
+
+	assert.Len(t, conv.Messages(), 4)
+	assert.Equal(t, int64(12), conv.Usage().TotalTokens)
+
+	conv.Reset()
+	assert.Empty(t, conv.Messages())
+	assert.Zero(t, conv.Usage().TotalTokens)
+}
+
+func TestConversationRecordsToolTurns(t *testing.T) {
+	model := &scriptedModel{turns: []*Turn{
+		{Blocks: []Block{{Type: BlockToolUse, ToolCallID: "c1", ToolName: "noop", Input: "{}"}}, StopReason: StopToolUse},
+		{Blocks: []Block{TextBlock("done")}, StopReason: StopEnd},
+	}}
+	type noopIn struct{}
+	noop := NewTool[noopIn]("noop", "noop", func(_ context.Context, _ noopIn) (string, error) { return "ok", nil })
+	conv := NewConversation(NewAgent(model, "", []Tool{noop}), nil)
+
+	_, err := conv.Send(context.Background(), "go", RunOptions{})
+	require.NoError(t, err)
+
+	msgs := conv.Messages()
+	require.Len(t, msgs, 4)
+	assert.Equal(t, []Role{RoleUser, RoleAssistant, RoleTool, RoleAssistant},
+		[]Role{msgs[0].Role, msgs[1].Role, msgs[2].Role, msgs[3].Role})
+	assert.Equal(t, "ok", msgs[2].Blocks[0].Text)
+}
+
+func TestConversationUnchangedOnError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	history := []Message{UserMessage("hi"), {Role: RoleAssistant, Blocks: []Block{TextBlock("hello")}}}
+	conv := NewConversation(NewAgent(NewFakeModel("fake", "fake-1"), "", nil), history)
+	_, err := conv.Send(ctx, "again", RunOptions{})
+	require.Error(t, err)
+	assert.Equal(t, history, conv.Messages())
+}
@@ -0,0 +1,38 @@
+package llm
+
+import "github.com/elek/catwalk-open/providers"
+
+// CatalogModel looks up a model in the catwalk catalog, which knows the prices
+// and limits of the models of the well-known providers.
+func CatalogModel(provider, model string) (providers.Model, bool) {
+	for _, p := range providers.GetAll() {
+		if string(p.ID) != provider {
+			continue
+		}
+		for _, m := range p.Models {
+			if m.ID == model {
+				return m, true
+			}
+		}
+		break
+	}
+	return providers.Model{}, false
+}
+
+// Cost returns the price of u in USD. A cost reported by the provider is used
+// as is; otherwise it is estimated from the catalog prices of the model,
+// including the cache writes and reads. The second result is false when the
+// price is unknown.
+func Cost(provider, model string, u Usage) (float64, bool) {
+	if u.Cost > 0 {
+		return u.Cost, true
+	}
+	m, found := CatalogModel(provider, model)
+	if !found {
+		return 0, false
+	}
+	return (m.CostPer1MIn*float64(u.InputTokens) +
+		m.CostPer1MOut*float64(u.OutputTokens) +
+		m.CostPer1MInCached*float64(u.CacheCreationTokens) +
+		m.CostPer1MOutCached*float64(u.CacheReadTokens)) / 1_000_000, true
+}
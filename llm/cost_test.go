@@ -0,0 +1,16 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCostPrefersReportedCost(t *testing.T) {
+	cost, known := Cost("openrouter", "some/model", Usage{InputTokens: 1000, Cost: 0.25})
+	assert.True(t, known)
+	assert.InDelta(t, 0.25, cost, 1e-9)
+
+	_, known = Cost("nowhere", "unknown-model", Usage{InputTokens: 1000})
+	assert.False(t, known)
+}
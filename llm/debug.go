@@ -56,8 +56,12 @@ func formatRequest(provider, model string, req Request) string {
 // formatTurn renders a Turn as a human-readable, multi-line trace.
 func formatTurn(provider, model string, turn *Turn) string {
 	var b strings.Builder
-	fmt.Fprintf(&b, "<<< %s/%s response (stop=%s, tokens in=%d out=%d)\n",
+	fmt.Fprintf(&b, "<<< %s/%s response (stop=%s, tokens in=%d out=%d",
 		provider, model, turn.StopReason, turn.Usage.InputTokens, turn.Usage.OutputTokens)
+	if u := turn.Usage; u.CacheCreationTokens > 0 || u.CacheReadTokens > 0 {
+		fmt.Fprintf(&b, " cache write=%d read=%d", u.CacheCreationTokens, u.CacheReadTokens)
+	}
+	b.WriteString(")\n")
 	writeBlocks(&b, string(RoleAssistant), turn.Blocks)
 	return b.String()
 }
@@ -77,6 +81,10 @@ func writeBlocks(b *strings.Builder, role string, blocks []Block) {
 				marker = "tool_result(error)"
 			}
 			fmt.Fprintf(b, "    %s (id=%s):\n%s\n", marker, blk.ToolCallID, indent(blk.Text))
+		case BlockReasoning:
+			if blk.Kind() == ReasoningThinking {
+				fmt.Fprintf(b, "    thinking:\n%s\n", indent(blk.Text))
+			}
 		}
 	}
 }
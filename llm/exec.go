@@ -21,6 +21,10 @@ const noTextNotice = "(no text returned by the model)"
 // satisfy this type.
 type AgentCallback func(ctx context.Context, model config.Model, system string, prompt string, tools []Tool) (string, error)
 
+// Recorder persists the transcript of a finished run together with the model
+// and system prompt that produced it.
+type Recorder func(model config.Model, system string, messages []Message) error
+
 // Executor runs prompts against models created from a configuration.
 type Executor struct {
 	cfg   config.Config
@@ -28,6 +32,11 @@ type Executor struct {
 	// out is where streamed text, tool-call notices, and the empty-response
 	// notice are written. It defaults to os.Stdout; tests inject a buffer.
 	out io.Writer
+	// recorder, when set, is called with the transcript of every successful run.
+	recorder Recorder
+	// approver is asked about the tool calls the permission policy wants
+	// approved. Without one, those calls are refused.
+	approver Approver
 }
 
 // NewExecutor creates an Executor bound to a configuration. When debug is true,
@@ -36,6 +45,24 @@ func NewExecutor(cfg config.Config, debug bool) *Executor {
 	return &Executor{cfg: cfg, debug: debug, out: os.Stdout}
 }
 
+// SetRecorder makes the executor hand the transcript of every successful run
+// to r, for example to store it as a session.
+func (e *Executor) SetRecorder(r Recorder) {
+	e.recorder = r
+}
+
+// SetOutput makes the executor write the streamed text and the tool-call
+// notices to w instead of stdout.
+func (e *Executor) SetOutput(w io.Writer) {
+	e.out = w
+}
+
+// SetApprover makes the executor ask approve about the tool calls that need
+// approval under the configured permissions.
+func (e *Executor) SetApprover(approve Approver) {
+	e.approver = approve
+}
+
 // ExecPrompt runs prompt through an agent loop, streaming the model's text to
 // stdout and reporting tool calls as they happen. If mdl is the zero value, the
 // configured default model is used.
@@ -57,34 +84,51 @@ func (e *Executor) ExecPrompt(ctx context.Context, mdl config.Model, system stri
 		return "", errors.WithStack(err)
 	}
 
-	return e.runAgent(ctx, model, system, prompt, tools)
+	result, err := e.runAgent(ctx, model, system, prompt, tools)
+	if err != nil {
+		return "", err
+	}
+	if e.recorder != nil {
+		// The answer has already been streamed, so a failure to store the
+		// transcript is reported but does not fail the command.
+		if err := e.recorder(mdl, system, result.Messages); err != nil {
+			fmt.Fprintln(os.Stderr, "Warning: session couldn't be saved:", err)
+		}
+	}
+	return result.Text, nil
 }
 
 // runAgent drives the agent loop against an already-created model, streaming
 // text and tool calls to the executor's output. When the run finishes without
 // any final text, it writes noTextNotice so a degenerate response is not
 // mistaken for no output at all.
-func (e *Executor) runAgent(ctx context.Context, model Model, system string, prompt string, tools []Tool) (string, error) {
+func (e *Executor) runAgent(ctx context.Context, model Model, system string, prompt string, tools []Tool) (*Result, error) {
 	out := e.out
 	if out == nil {
 		out = os.Stdout
 	}
 
-	agent := NewAgent(model, system, tools)
+	policy, err := NewPolicy(e.cfg.Permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	agent := NewAgent(model, system, policy.Guard(tools, e.approver))
 	result, err := agent.Run(ctx, prompt, RunOptions{
+		MaxParallel: e.cfg.MaxParallelTools,
 		OnTextDelta: func(delta string) { fmt.Fprint(out, delta) },
-		OnToolCall: func(name, input string) {
+		OnToolCall: func(_, name, input string) {
 			fmt.Fprintln(out, "Calling tool", name, "with input:", input)
 		},
 	})
 	if err != nil {
-		return "", errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 	fmt.Fprintln(out)
 	if strings.TrimSpace(result.Text) == "" {
 		fmt.Fprintln(out, noTextNotice)
 	}
-	return result.Text, nil
+	return result, nil
 }
 
 // DryRun prints the resolved model, prompts, and tools without calling any
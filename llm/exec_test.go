@@ -21,9 +21,9 @@ func TestExecutorNotifiesWhenModelReturnsNoText(t *testing.T) {
 
 	var buf bytes.Buffer
 	e := &Executor{out: &buf}
-	text, err := e.runAgent(context.Background(), model, "", "go", []Tool{noop})
+	res, err := e.runAgent(context.Background(), model, "", "go", []Tool{noop})
 	require.NoError(t, err)
-	assert.Empty(t, text)
+	assert.Empty(t, res.Text)
 	assert.Contains(t, buf.String(), "no text")
 }
 
@@ -33,8 +33,8 @@ func TestExecutorDoesNotNotifyWhenModelReturnsText(t *testing.T) {
 	}}
 	var buf bytes.Buffer
 	e := &Executor{out: &buf}
-	text, err := e.runAgent(context.Background(), model, "", "go", nil)
+	res, err := e.runAgent(context.Background(), model, "", "go", nil)
 	require.NoError(t, err)
-	assert.Equal(t, "here is your answer", text)
+	assert.Equal(t, "here is your answer", res.Text)
 	assert.NotContains(t, buf.String(), "no text")
 }
@@ -34,12 +34,18 @@ func (f *fakeModel) Name() string     { return f.model }
 // Stream streams randomly generated text word by word via onText, then returns
 // the complete turn. As special cases, a "[scenario1 count=N]" directive drives
 // a scripted write/read loop (see scenario1Turn) and a prompt mentioning
-// "commit" requests git tool calls (see commitTurn). Context cancellation is
+// "commit" requests git tool calls (see commitTurn). A "[think]" directive
+// first streams random reasoning to req.OnThinking. Context cancellation is
 // honored between words.
 func (f *fakeModel) Stream(ctx context.Context, req Request, onText func(delta string)) (*Turn, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
+	if req.OnThinking != nil && mentions(req.Messages, "[think]") {
+		if err := streamText(ctx, randomParagraph(), req.OnThinking); err != nil {
+			return nil, err
+		}
+	}
 	if count, ok := scenario1Count(req.Messages); ok {
 		return scenario1Turn(ctx, req, count, onText)
 	}
@@ -87,7 +93,7 @@ func commitTurn(req Request) *Turn {
 		text := "Done: staged and committed the changes."
 		return &Turn{Blocks: []Block{TextBlock(text)}, Usage: usageFor(text), StopReason: StopEnd}
 	}
-	if !mentionsCommit(req.Messages) {
+	if !mentions(req.Messages, "commit") {
 		return nil
 	}
 	message := randomCommitMessage()
@@ -101,14 +107,14 @@ func commitTurn(req Request) *Turn {
 	}
 }
 
-// mentionsCommit reports whether any user message text contains "commit".
-func mentionsCommit(messages []Message) bool {
+// mentions reports whether any user message text contains word, ignoring case.
+func mentions(messages []Message, word string) bool {
 	for _, m := range messages {
 		if m.Role != RoleUser {
 			continue
 		}
 		for _, b := range m.Blocks {
-			if b.Type == BlockText && strings.Contains(strings.ToLower(b.Text), "commit") {
+			if b.Type == BlockText && strings.Contains(strings.ToLower(b.Text), word) {
 				return true
 			}
 		}
@@ -149,7 +149,7 @@ func TestFakeModelScenario1DrivesAgent(t *testing.T) {
 
 	var toolCalls int
 	res, err := agent.Run(context.Background(), "[scenario1 count=2]", RunOptions{
-		OnToolCall: func(name, input string) { toolCalls++ },
+		OnToolCall: func(_, name, input string) { toolCalls++ },
 	})
 	require.NoError(t, err)
 	assert.Contains(t, res.Text, "completed 2")
@@ -0,0 +1,286 @@
+package llm
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"cloud.google.com/go/auth/credentials"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"google.golang.org/genai"
+)
+
+// geminiCallPrefix marks tool call IDs generated locally. The Gemini API does
+// not always assign IDs to function calls, but the agent loop needs one to
+// match each result with its call. Generated IDs are unique in a conversation,
+// and never sent back.
+const geminiCallPrefix = "gemini-call-"
+
+// geminiModel implements Model against Google's Gemini models, either through
+// the Gemini API (API key) or Vertex AI (project, location, and credentials).
+type geminiModel struct {
+	client    *genai.Client
+	model     string
+	maxTokens int64
+	debug     bool
+}
+
+// NewGeminiModel creates a Model backed by the Gemini API. A non-empty baseURL
+// targets a different endpoint (tests use it to point at a local fake). When
+// debug is true, every request and response is traced to stderr.
+func NewGeminiModel(ctx context.Context, apiKey, baseURL, model string, maxTokens int64, debug bool) (Model, error) {
+	cc := &genai.ClientConfig{
+		APIKey:  apiKey,
+		Backend: genai.BackendGeminiAPI,
+	}
+	cc.HTTPOptions.BaseURL = baseURL
+	return newGeminiModel(ctx, cc, model, maxTokens, debug)
+}
+
+// NewVertexGeminiModel creates a Model backed by Gemini on Vertex AI. The
+// service account key in credentialFile is used when set; otherwise the
+// application default credentials are.
+func NewVertexGeminiModel(ctx context.Context, project, location, credentialFile, baseURL, model string, maxTokens int64, debug bool) (Model, error) {
+	cc := &genai.ClientConfig{
+		Backend:  genai.BackendVertexAI,
+		Project:  project,
+		Location: location,
+	}
+	cc.HTTPOptions.BaseURL = baseURL
+	if credentialFile != "" {
+		creds, err := credentials.DetectDefault(&credentials.DetectOptions{
+			Scopes:          []string{"https://www.googleapis.com/auth/cloud-platform"},
+			CredentialsFile: credentialFile,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't load google credentials")
+		}
+		cc.Credentials = creds
+	}
+	return newGeminiModel(ctx, cc, model, maxTokens, debug)
+}
+
+func newGeminiModel(ctx context.Context, cc *genai.ClientConfig, model string, maxTokens int64, debug bool) (Model, error) {
+	client, err := genai.NewClient(ctx, cc)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &geminiModel{
+		client:    client,
+		model:     model,
+		maxTokens: maxTokens,
+		debug:     debug,
+	}, nil
+}
+
+func (m *geminiModel) Provider() string { return "google" }
+func (m *geminiModel) Name() string     { return m.model }
+
+func (m *geminiModel) Stream(ctx context.Context, req Request, onText func(delta string)) (*Turn, error) {
+	config := &genai.GenerateContentConfig{}
+	if req.System != "" {
+		config.SystemInstruction = genai.NewContentFromText(req.System, genai.RoleUser)
+	}
+	if m.maxTokens > 0 {
+		config.MaxOutputTokens = int32(m.maxTokens)
+	}
+	if req.Temperature > 0 {
+		t := float32(req.Temperature)
+		config.Temperature = &t
+	}
+	if len(req.Tools) > 0 {
+		config.Tools = toGeminiTools(req.Tools)
+	}
+
+	if m.debug {
+		debugRequest(m.Provider(), m.model, req)
+	}
+
+	var (
+		parts  []*genai.Part
+		usage  *genai.GenerateContentResponseUsageMetadata
+		finish genai.FinishReason
+	)
+	for resp, err := range m.client.Models.GenerateContentStream(ctx, m.model, toGeminiContents(req.Messages), config) {
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if resp.UsageMetadata != nil {
+			usage = resp.UsageMetadata
+		}
+		if len(resp.Candidates) == 0 {
+			continue
+		}
+		cand := resp.Candidates[0]
+		if cand.FinishReason != "" {
+			finish = cand.FinishReason
+		}
+		if cand.Content == nil {
+			continue
+		}
+		for _, p := range cand.Content.Parts {
+			if onText != nil && p.Text != "" && !p.Thought {
+				onText(p.Text)
+			}
+			parts = append(parts, p)
+		}
+	}
+
+	turn := turnFromGemini(parts, usage)
+	if finish == genai.FinishReasonMaxTokens && turn.StopReason != StopToolUse {
+		turn.StopReason = StopMaxTokens
+	}
+	if len(turn.Blocks) == 0 && finish != "" && finish != genai.FinishReasonStop {
+		// Safety filters and similar stops end the response without content;
+		// say why instead of returning an empty answer.
+		return nil, errors.Errorf("gemini response stopped: %s", finish)
+	}
+	if m.debug {
+		debugTurn(m.Provider(), m.model, turn)
+	}
+	return turn, nil
+}
+
+// toGeminiContents converts neutral messages into Gemini contents. Tool results
+// travel in a user-role content as function responses, which Gemini matches to
+// their calls by name, so the names are looked up from the preceding calls.
+// The thought signature of a function call (kept in a BlockReasoning block in
+// front of its tool_use) is sent back on that call, as Gemini requires.
+func toGeminiContents(msgs []Message) []*genai.Content {
+	names := map[string]string{}
+	out := make([]*genai.Content, 0, len(msgs))
+	for _, msg := range msgs {
+		role := genai.RoleUser
+		if msg.Role == RoleAssistant {
+			role = genai.RoleModel
+		}
+		var (
+			parts     []*genai.Part
+			signature []byte
+		)
+		for _, b := range msg.Blocks {
+			switch b.Type {
+			case BlockText:
+				parts = append(parts, genai.NewPartFromText(b.Text))
+			case BlockReasoning:
+				if b.Kind() == ReasoningThoughtSignature {
+					signature, _ = base64.StdEncoding.DecodeString(b.Signature)
+				}
+			case BlockToolUse:
+				names[b.ToolCallID] = b.ToolName
+				args := map[string]any{}
+				if b.Input != "" {
+					_ = json.Unmarshal([]byte(b.Input), &args)
+				}
+				parts = append(parts, &genai.Part{
+					FunctionCall:     &genai.FunctionCall{ID: geminiCallID(b.ToolCallID), Name: b.ToolName, Args: args},
+					ThoughtSignature: signature,
+				})
+				signature = nil
+			case BlockToolResult:
+				key := "output"
+				if b.IsError {
+					key = "error"
+				}
+				parts = append(parts, &genai.Part{FunctionResponse: &genai.FunctionResponse{
+					ID:       geminiCallID(b.ToolCallID),
+					Name:     names[b.ToolCallID],
+					Response: map[string]any{key: b.Text},
+				}})
+			}
+		}
+		if len(parts) > 0 {
+			out = append(out, &genai.Content{Role: role, Parts: parts})
+		}
+	}
+	return out
+}
+
+// geminiCallID returns the ID to send to Gemini for a tool call: the one it
+// assigned, or empty for IDs that were generated locally.
+func geminiCallID(id string) string {
+	if strings.HasPrefix(id, geminiCallPrefix) {
+		return ""
+	}
+	return id
+}
+
+// toGeminiTools converts neutral tools into Gemini function declarations.
+func toGeminiTools(tools []Tool) []*genai.Tool {
+	decls := make([]*genai.FunctionDeclaration, 0, len(tools))
+	for _, t := range tools {
+		info := t.Info()
+		properties := info.Parameters
+		if properties == nil {
+			properties = map[string]any{}
+		}
+		required := info.Required
+		if required == nil {
+			required = []string{}
+		}
+		decls = append(decls, &genai.FunctionDeclaration{
+			Name:        info.Name,
+			Description: info.Description,
+			ParametersJsonSchema: map[string]any{
+				"type":       "object",
+				"properties": properties,
+				"required":   required,
+			},
+		})
+	}
+	return []*genai.Tool{{FunctionDeclarations: decls}}
+}
+
+// turnFromGemini converts the parts streamed for one response into a Turn.
+// Consecutive text chunks are merged into a single text block; thought
+// summaries are dropped.
+func turnFromGemini(parts []*genai.Part, usage *genai.GenerateContentResponseUsageMetadata) *Turn {
+	turn := &Turn{StopReason: StopEnd}
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			turn.Blocks = append(turn.Blocks, TextBlock(text.String()))
+			text.Reset()
+		}
+	}
+	for _, p := range parts {
+		switch {
+		case p.FunctionCall != nil:
+			flush()
+			id := p.FunctionCall.ID
+			if id == "" {
+				id = geminiCallPrefix + uuid.NewString()
+			}
+			if len(p.ThoughtSignature) > 0 {
+				turn.Blocks = append(turn.Blocks, Block{
+					Type:       BlockReasoning,
+					Reasoning:  ReasoningThoughtSignature,
+					ToolCallID: id,
+					Signature:  base64.StdEncoding.EncodeToString(p.ThoughtSignature),
+				})
+			}
+			input, _ := json.Marshal(p.FunctionCall.Args)
+			turn.Blocks = append(turn.Blocks, Block{
+				Type:       BlockToolUse,
+				ToolCallID: id,
+				ToolName:   p.FunctionCall.Name,
+				Input:      string(input),
+			})
+			turn.StopReason = StopToolUse
+		case p.Text != "" && !p.Thought:
+			text.WriteString(p.Text)
+		}
+	}
+	flush()
+
+	if usage != nil {
+		turn.Usage = Usage{
+			InputTokens:  int64(usage.PromptTokenCount),
+			OutputTokens: int64(usage.CandidatesTokenCount + usage.ThoughtsTokenCount),
+			TotalTokens:  int64(usage.TotalTokenCount),
+		}
+	}
+	return turn
+}
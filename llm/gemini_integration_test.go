@@ -0,0 +1,32 @@
+package llm
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestGeminiIntegration hits the real Gemini API when GEMINI_API_KEY is set, and
+// is skipped otherwise. The model can be overridden via RAI_TEST_GEMINI_MODEL
+// (default: gemini-2.5-flash).
+func TestGeminiIntegration(t *testing.T) {
+	key := os.Getenv("GEMINI_API_KEY")
+	if key == "" {
+		t.Skip("GEMINI_API_KEY not set; skipping live Gemini integration test")
+	}
+	model := os.Getenv("RAI_TEST_GEMINI_MODEL")
+	if model == "" {
+		model = "gemini-2.5-flash"
+	}
+
+	m, err := NewGeminiModel(context.Background(), key, "", model, 1024, false)
+	require.NoError(t, err)
+	agent := NewAgent(m, "", nil)
+	res, err := agent.Run(context.Background(), "What is the capital of Spain? Answer in one word.", RunOptions{})
+	require.NoError(t, err)
+	assert.Contains(t, strings.ToLower(res.Text), "madrid")
+}
@@ -0,0 +1,145 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genai"
+)
+
+// fakeGemini is an httptest stand-in for the Gemini streamGenerateContent
+// endpoint. Each request is answered with the next scripted list of SSE
+// chunks; the decoded request bodies are recorded for assertions.
+type fakeGemini struct {
+	t         *testing.T
+	mu        sync.Mutex
+	responses [][]string
+	requests  []map[string]any
+	paths     []string
+}
+
+func (f *fakeGemini) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	require.NoError(f.t, err)
+	var decoded map[string]any
+	require.NoError(f.t, json.Unmarshal(body, &decoded))
+
+	f.mu.Lock()
+	f.paths = append(f.paths, r.URL.Path)
+	f.requests = append(f.requests, decoded)
+	chunks := f.responses[0]
+	f.responses = f.responses[1:]
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, c := range chunks {
+		_, _ = fmt.Fprintf(w, "data: %s\r\n\r\n", c)
+	}
+}
+
+func newFakeGemini(t *testing.T, responses ...[]string) (*fakeGemini, Model) {
+	t.Helper()
+	fake := &fakeGemini{t: t, responses: responses}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	m, err := NewGeminiModel(context.Background(), "test-key", srv.URL, "gemini-test", 512, false)
+	require.NoError(t, err)
+	return fake, m
+}
+
+func TestGeminiStreamsTextAndUsage(t *testing.T) {
+	fake, m := newFakeGemini(t, []string{
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"Mad"}]}}]}`,
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"rid"}]},"finishReason":"STOP"}],` +
+			`"usageMetadata":{"promptTokenCount":11,"candidatesTokenCount":2,"thoughtsTokenCount":3,"totalTokenCount":16}}`,
+	})
+
+	var streamed strings.Builder
+	turn, err := m.Stream(context.Background(), Request{
+		System:   "be brief",
+		Messages: []Message{UserMessage("Capital of Spain?")},
+	}, func(d string) { streamed.WriteString(d) })
+	require.NoError(t, err)
+
+	assert.Equal(t, "Madrid", streamed.String())
+	require.Len(t, turn.Blocks, 1, "text chunks are merged into one block")
+	assert.Equal(t, "Madrid", turn.Blocks[0].Text)
+	assert.Equal(t, StopEnd, turn.StopReason)
+	assert.Equal(t, Usage{InputTokens: 11, OutputTokens: 5, TotalTokens: 16}, turn.Usage)
+
+	assert.Contains(t, fake.paths[0], "models/gemini-test:streamGenerateContent")
+	req := fake.requests[0]
+	assert.Equal(t, "be brief", req["systemInstruction"].(map[string]any)["parts"].([]any)[0].(map[string]any)["text"])
+	assert.EqualValues(t, 512, req["generationConfig"].(map[string]any)["maxOutputTokens"])
+}
+
+func TestGeminiToolCallRoundTrip(t *testing.T) {
+	fake, m := newFakeGemini(t,
+		[]string{`{"candidates":[{"content":{"role":"model","parts":[` +
+			`{"functionCall":{"name":"echo","args":{"text":"hi"}},"thoughtSignature":"c2lnbmVk"}]},"finishReason":"STOP"}]}`},
+		[]string{`{"candidates":[{"content":{"role":"model","parts":[{"text":"done: hi"}]},"finishReason":"STOP"}]}`},
+	)
+
+	type echoIn struct {
+		Text string `json:"text"`
+	}
+	echo := NewTool[echoIn]("echo", "echoes its input", func(_ context.Context, in echoIn) (string, error) {
+		return "echoed " + in.Text, nil
+	})
+
+	var calls []string
+	res, err := NewAgent(m, "", []Tool{echo}).Run(context.Background(), "go", RunOptions{
+		OnToolCall: func(_, name, input string) { calls = append(calls, name+" "+input) },
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "done: hi", res.Text)
+	assert.Equal(t, []string{`echo {"text":"hi"}`}, calls)
+
+	// The tool was declared with its JSON schema.
+	decl := fake.requests[0]["tools"].([]any)[0].(map[string]any)["functionDeclarations"].([]any)[0].(map[string]any)
+	assert.Equal(t, "echo", decl["name"])
+	assert.Contains(t, decl["parametersJsonSchema"].(map[string]any)["properties"], "text")
+
+	// The second request replays the call (with its thought signature, without
+	// the locally generated ID) and answers it with a named function response.
+	contents := fake.requests[1]["contents"].([]any)
+	require.Len(t, contents, 3)
+	call := contents[1].(map[string]any)["parts"].([]any)[0].(map[string]any)
+	assert.Equal(t, "c2lnbmVk", call["thoughtSignature"])
+	assert.NotContains(t, call["functionCall"], "id")
+	result := contents[2].(map[string]any)["parts"].([]any)[0].(map[string]any)["functionResponse"].(map[string]any)
+	assert.Equal(t, "echo", result["name"])
+	assert.Equal(t, map[string]any{"output": "echoed hi"}, result["response"])
+}
+
+func TestGeminiEmptyResponseReportsFinishReason(t *testing.T) {
+	_, m := newFakeGemini(t, []string{`{"candidates":[{"finishReason":"SAFETY"}]}`})
+	_, err := m.Stream(context.Background(), Request{Messages: []Message{UserMessage("hi")}}, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "SAFETY")
+}
+
+func TestGeminiFlagsAnAnswerCutOffAtTheTokenLimit(t *testing.T) {
+	_, m := newFakeGemini(t, []string{`{"candidates":[{"content":{"role":"model","parts":[{"text":"Once upon"}]},"finishReason":"MAX_TOKENS"}]}`})
+	res, err := NewAgent(m, "", nil).Run(context.Background(), "tell a story", RunOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "Once upon", res.Text)
+	assert.Equal(t, StopMaxTokens, res.StopReason)
+}
+
+func TestGeminiGeneratedCallIDsAreUnique(t *testing.T) {
+	call := &genai.Part{FunctionCall: &genai.FunctionCall{Name: "echo"}}
+	first := turnFromGemini([]*genai.Part{call}, nil)
+	second := turnFromGemini([]*genai.Part{call}, nil)
+	assert.NotEqual(t, first.Blocks[0].ToolCallID, second.Blocks[0].ToolCallID, "the IDs of later turns don't repeat")
+	assert.Empty(t, geminiCallID(first.Blocks[0].ToolCallID))
+}
@@ -0,0 +1,653 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/elek/rai/config"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Tool call modes of local model servers, set with tool_calls on the provider.
+const (
+	// ToolCallsAuto uses native tool calls, and switches to prompt-based ones
+	// when the server rejects them. It is the default.
+	ToolCallsAuto = "auto"
+	// ToolCallsNative uses native tool calls only; requests with tools fail
+	// with a clear error when the server doesn't support them.
+	ToolCallsNative = "native"
+	// ToolCallsPrompt describes the tools in the system prompt and parses the
+	// calls from the model's text, for models without native tool calling.
+	ToolCallsPrompt = "prompt"
+)
+
+// Default endpoints of local model servers.
+const (
+	ollamaURL   = "http://localhost:11434"
+	llamaCppURL = "http://localhost:8080"
+)
+
+// localCallPrefix prefixes the IDs of tool calls that the server didn't assign
+// an ID to.
+const localCallPrefix = "local-call-"
+
+// localCallID returns a new ID for a tool call without one. IDs are unique in
+// a conversation, as clients tell the calls apart by them.
+func localCallID() string {
+	return localCallPrefix + uuid.NewString()
+}
+
+// localModel implements Model against a model server running on the local
+// machine: Ollama (its native /api/chat API) or llama.cpp's llama-server (its
+// OpenAI-compatible API). Usage is taken from the eval counts the servers
+// report.
+type localModel struct {
+	client    *http.Client
+	dialect   string
+	endpoint  string
+	model     string
+	maxTokens int64
+	toolCalls string
+	debug     bool
+	// promptTools is set once the server rejected native tool calls in auto
+	// mode, so later turns don't retry them.
+	promptTools atomic.Bool
+}
+
+// NewOllamaModel creates a Model backed by an Ollama server. An empty endpoint
+// means the default local server. toolCalls is one of the ToolCalls modes
+// (empty means auto). When debug is true, every request and response is traced
+// to stderr.
+func NewOllamaModel(endpoint, model, toolCalls string, maxTokens int64, debug bool) (Model, error) {
+	return newLocalModel("ollama", endpoint, model, toolCalls, maxTokens, debug)
+}
+
+// NewLlamaCppModel creates a Model backed by a llama.cpp server (llama-server).
+// An empty endpoint means the default local server; the other arguments are as
+// for NewOllamaModel.
+func NewLlamaCppModel(endpoint, model, toolCalls string, maxTokens int64, debug bool) (Model, error) {
+	return newLocalModel("llamacpp", endpoint, model, toolCalls, maxTokens, debug)
+}
+
+func newLocalModel(dialect, endpoint, model, toolCalls string, maxTokens int64, debug bool) (Model, error) {
+	switch toolCalls {
+	case "":
+		toolCalls = ToolCallsAuto
+	case ToolCallsAuto, ToolCallsNative, ToolCallsPrompt:
+	default:
+		return nil, errors.Errorf("unknown tool_calls mode %q (expected auto, native, or prompt)", toolCalls)
+	}
+	return &localModel{
+		client:    http.DefaultClient,
+		dialect:   dialect,
+		endpoint:  localEndpoint(dialect, endpoint),
+		model:     model,
+		maxTokens: maxTokens,
+		toolCalls: toolCalls,
+		debug:     debug,
+	}, nil
+}
+
+// localEndpoint returns the base URL of a local server of the given dialect.
+func localEndpoint(dialect, endpoint string) string {
+	if endpoint == "" {
+		if dialect == "ollama" {
+			return ollamaURL
+		}
+		return llamaCppURL
+	}
+	return strings.TrimSuffix(endpoint, "/")
+}
+
+func (m *localModel) Provider() string { return m.dialect }
+func (m *localModel) Name() string     { return m.model }
+
+func (m *localModel) Stream(ctx context.Context, req Request, onText func(delta string)) (*Turn, error) {
+	if m.debug {
+		debugRequest(m.Provider(), m.model, req)
+	}
+	turn, err := m.stream(ctx, req, onText)
+	if err != nil {
+		return nil, err
+	}
+	if m.debug {
+		debugTurn(m.Provider(), m.model, turn)
+	}
+	return turn, nil
+}
+
+// stream picks between native and prompt-based tool calls. A server rejects
+// tools before it generates anything, so falling back doesn't repeat any
+// streamed text.
+func (m *localModel) stream(ctx context.Context, req Request, onText func(delta string)) (*Turn, error) {
+	if len(req.Tools) == 0 {
+		return m.chat(ctx, req, onText)
+	}
+	if m.toolCalls == ToolCallsPrompt || m.promptTools.Load() {
+		return m.chatPromptTools(ctx, req, onText)
+	}
+	turn, err := m.chat(ctx, req, onText)
+	if !toolsUnsupported(err) {
+		return turn, err
+	}
+	if m.toolCalls == ToolCallsNative {
+		return nil, errors.Errorf("model %s doesn't support native tool calls (%s); set tool_calls to prompt or auto on the provider to emulate them, or run without tools", m.model, err)
+	}
+	m.promptTools.Store(true)
+	return m.chatPromptTools(ctx, req, onText)
+}
+
+// chat performs one native chat request in the server's dialect.
+func (m *localModel) chat(ctx context.Context, req Request, onText func(delta string)) (*Turn, error) {
+	if m.dialect == "ollama" {
+		return m.ollamaChat(ctx, req, onText)
+	}
+	return m.llamaCppChat(ctx, req, onText)
+}
+
+// serverError is an error response of a local server.
+type serverError struct {
+	Status  int
+	Message string
+}
+
+func (e *serverError) Error() string {
+	return fmt.Sprintf("server returned %d: %s", e.Status, e.Message)
+}
+
+// toolsUnsupportedMessages are the errors of the servers refusing the tools
+// of a request: Ollama answers "<model> does not support tools", llama-server
+// started without --jinja "tools param requires --jinja flag".
+var toolsUnsupportedMessages = []string{"does not support tools", "requires --jinja"}
+
+// toolsUnsupported reports whether err is a server refusing the tools of a
+// request.
+func toolsUnsupported(err error) bool {
+	var se *serverError
+	if !errors.As(err, &se) {
+		return false
+	}
+	msg := strings.ToLower(se.Message)
+	for _, known := range toolsUnsupportedMessages {
+		if strings.Contains(msg, known) {
+			return true
+		}
+	}
+	return false
+}
+
+// post sends a JSON request to the server and returns the response of a
+// successful one.
+func (m *localModel) post(ctx context.Context, path string, body any) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, m.endpoint+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	resp, err := m.client.Do(hreq)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't reach the %s server at %s", m.dialect, m.endpoint)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer func() { _ = resp.Body.Close() }()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, errors.WithStack(&serverError{Status: resp.StatusCode, Message: serverErrorMessage(msg)})
+	}
+	return resp, nil
+}
+
+// serverErrorMessage extracts the message of an error response: Ollama sends
+// {"error": "..."}, llama-server {"error": {"message": "..."}}.
+func serverErrorMessage(body []byte) string {
+	var flat struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &flat) == nil && flat.Error != "" {
+		return flat.Error
+	}
+	var nested struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &nested) == nil && nested.Error.Message != "" {
+		return nested.Error.Message
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// localTool is a tool definition in the OpenAI format, which both servers
+// accept.
+type localTool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description,omitempty"`
+		Parameters  map[string]any `json:"parameters"`
+	} `json:"function"`
+}
+
+func toLocalTools(tools []Tool) []localTool {
+	out := make([]localTool, 0, len(tools))
+	for _, t := range tools {
+		info := t.Info()
+		var lt localTool
+		lt.Type = "function"
+		lt.Function.Name = info.Name
+		lt.Function.Description = info.Description
+		// Servers reject a null properties or required, so a tool without
+		// parameters has empty properties and no required.
+		properties := info.Parameters
+		if properties == nil {
+			properties = map[string]any{}
+		}
+		lt.Function.Parameters = map[string]any{
+			"type":       "object",
+			"properties": properties,
+		}
+		if len(info.Required) > 0 {
+			lt.Function.Parameters["required"] = info.Required
+		}
+		out = append(out, lt)
+	}
+	return out
+}
+
+// localOptions returns the generation options of a request.
+func (m *localModel) localOptions(req Request) (maxTokens int64, temperature float64) {
+	maxTokens = m.maxTokens
+	if req.MaxTokens > 0 {
+		maxTokens = req.MaxTokens
+	}
+	return maxTokens, req.Temperature
+}
+
+// ollamaMessage is a message of Ollama's chat API.
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// ollamaChunk is one line of a streamed Ollama chat response.
+type ollamaChunk struct {
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	PromptEvalCount int64         `json:"prompt_eval_count"`
+	EvalCount       int64         `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+// ollamaChat streams a response from Ollama's /api/chat, which sends one JSON
+// object per line and the eval counts with the last one.
+func (m *localModel) ollamaChat(ctx context.Context, req Request, onText func(delta string)) (*Turn, error) {
+	body := map[string]any{
+		"model":    m.model,
+		"messages": toOllamaMessages(req.System, req.Messages),
+		"stream":   true,
+	}
+	if len(req.Tools) > 0 {
+		body["tools"] = toLocalTools(req.Tools)
+	}
+	options := map[string]any{}
+	maxTokens, temperature := m.localOptions(req)
+	if maxTokens > 0 {
+		options["num_predict"] = maxTokens
+	}
+	if temperature > 0 {
+		options["temperature"] = temperature
+	}
+	if len(options) > 0 {
+		body["options"] = options
+	}
+
+	resp, err := m.post(ctx, "/api/chat", body)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var (
+		text  strings.Builder
+		calls []Block
+		usage Usage
+	)
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var chunk ollamaChunk
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return nil, errors.Wrap(err, "couldn't parse ollama response")
+		}
+		if chunk.Error != "" {
+			return nil, errors.Errorf("ollama: %s", chunk.Error)
+		}
+		if d := chunk.Message.Content; d != "" {
+			text.WriteString(d)
+			if onText != nil {
+				onText(d)
+			}
+		}
+		for _, tc := range chunk.Message.ToolCalls {
+			calls = append(calls, Block{
+				Type:       BlockToolUse,
+				ToolCallID: localCallID(),
+				ToolName:   tc.Function.Name,
+				Input:      string(tc.Function.Arguments),
+			})
+		}
+		if chunk.Done {
+			usage = Usage{
+				InputTokens:  chunk.PromptEvalCount,
+				OutputTokens: chunk.EvalCount,
+				TotalTokens:  chunk.PromptEvalCount + chunk.EvalCount,
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return localTurn(text.String(), calls, usage), nil
+}
+
+// toOllamaMessages converts neutral messages into Ollama chat messages. Tool
+// results name the tool they answer, as Ollama's calls have no IDs.
+func toOllamaMessages(system string, msgs []Message) []ollamaMessage {
+	names := map[string]string{}
+	out := make([]ollamaMessage, 0, len(msgs)+1)
+	if system != "" {
+		out = append(out, ollamaMessage{Role: "system", Content: system})
+	}
+	for _, msg := range msgs {
+		switch msg.Role {
+		case RoleAssistant:
+			om := ollamaMessage{Role: "assistant", Content: textOf(msg.Blocks)}
+			for _, b := range msg.Blocks {
+				if b.Type != BlockToolUse {
+					continue
+				}
+				names[b.ToolCallID] = b.ToolName
+				var tc ollamaToolCall
+				tc.Function.Name = b.ToolName
+				tc.Function.Arguments = json.RawMessage(toolArguments(b.Input))
+				om.ToolCalls = append(om.ToolCalls, tc)
+			}
+			out = append(out, om)
+		case RoleTool:
+			for _, b := range msg.Blocks {
+				if b.Type == BlockToolResult {
+					out = append(out, ollamaMessage{Role: "tool", Content: b.Text, ToolName: names[b.ToolCallID]})
+				}
+			}
+		default: // user
+			out = append(out, ollamaMessage{Role: "user", Content: textOf(msg.Blocks)})
+		}
+	}
+	return out
+}
+
+// llamaCppMessage is a message of the OpenAI-compatible chat API of
+// llama-server.
+type llamaCppMessage struct {
+	Role       string             `json:"role"`
+	Content    string             `json:"content"`
+	ToolCalls  []llamaCppToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string             `json:"tool_call_id,omitempty"`
+}
+
+type llamaCppToolCall struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// llamaCppChunk is one streamed chunk of a llama-server chat completion. The
+// last one carries the timings with the eval counts.
+type llamaCppChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content   string             `json:"content"`
+			ToolCalls []llamaCppToolCall `json:"tool_calls"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int64 `json:"prompt_tokens"`
+		CompletionTokens int64 `json:"completion_tokens"`
+	} `json:"usage"`
+	Timings *struct {
+		PromptN    int64 `json:"prompt_n"`
+		PredictedN int64 `json:"predicted_n"`
+	} `json:"timings"`
+}
+
+// llamaCppChat streams a response from llama-server's /v1/chat/completions.
+func (m *localModel) llamaCppChat(ctx context.Context, req Request, onText func(delta string)) (*Turn, error) {
+	body := map[string]any{
+		"model":          m.model,
+		"messages":       toLlamaCppMessages(req.System, req.Messages),
+		"stream":         true,
+		"stream_options": map[string]any{"include_usage": true},
+	}
+	if len(req.Tools) > 0 {
+		body["tools"] = toLocalTools(req.Tools)
+	}
+	maxTokens, temperature := m.localOptions(req)
+	if maxTokens > 0 {
+		body["max_tokens"] = maxTokens
+	}
+	if temperature > 0 {
+		body["temperature"] = temperature
+	}
+
+	resp, err := m.post(ctx, "/v1/chat/completions", body)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var (
+		text  strings.Builder
+		calls []*llamaCppToolCall
+		usage Usage
+	)
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+		var chunk llamaCppChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, errors.Wrap(err, "couldn't parse llama.cpp response")
+		}
+		for _, choice := range chunk.Choices {
+			if d := choice.Delta.Content; d != "" {
+				text.WriteString(d)
+				if onText != nil {
+					onText(d)
+				}
+			}
+			// Tool calls arrive in pieces, keyed by their index.
+			for _, tc := range choice.Delta.ToolCalls {
+				for len(calls) <= tc.Index {
+					calls = append(calls, &llamaCppToolCall{})
+				}
+				call := calls[tc.Index]
+				if tc.ID != "" {
+					call.ID = tc.ID
+				}
+				call.Function.Name += tc.Function.Name
+				call.Function.Arguments += tc.Function.Arguments
+			}
+		}
+		switch {
+		case chunk.Timings != nil:
+			usage = Usage{
+				InputTokens:  chunk.Timings.PromptN,
+				OutputTokens: chunk.Timings.PredictedN,
+				TotalTokens:  chunk.Timings.PromptN + chunk.Timings.PredictedN,
+			}
+		case chunk.Usage != nil && usage == (Usage{}):
+			usage = Usage{
+				InputTokens:  chunk.Usage.PromptTokens,
+				OutputTokens: chunk.Usage.CompletionTokens,
+				TotalTokens:  chunk.Usage.PromptTokens + chunk.Usage.CompletionTokens,
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var blocks []Block
+	for _, call := range calls {
+		id := call.ID
+		if id == "" {
+			id = localCallID()
+		}
+		blocks = append(blocks, Block{
+			Type:       BlockToolUse,
+			ToolCallID: id,
+			ToolName:   call.Function.Name,
+			Input:      call.Function.Arguments,
+		})
+	}
+	return localTurn(text.String(), blocks, usage), nil
+}
+
+// toLlamaCppMessages converts neutral messages into OpenAI-style chat messages.
+func toLlamaCppMessages(system string, msgs []Message) []llamaCppMessage {
+	out := make([]llamaCppMessage, 0, len(msgs)+1)
+	if system != "" {
+		out = append(out, llamaCppMessage{Role: "system", Content: system})
+	}
+	for _, msg := range msgs {
+		switch msg.Role {
+		case RoleAssistant:
+			lm := llamaCppMessage{Role: "assistant", Content: textOf(msg.Blocks)}
+			for _, b := range msg.Blocks {
+				if b.Type != BlockToolUse {
+					continue
+				}
+				tc := llamaCppToolCall{Index: len(lm.ToolCalls), ID: b.ToolCallID, Type: "function"}
+				tc.Function.Name = b.ToolName
+				tc.Function.Arguments = toolArguments(b.Input)
+				lm.ToolCalls = append(lm.ToolCalls, tc)
+			}
+			out = append(out, lm)
+		case RoleTool:
+			for _, b := range msg.Blocks {
+				if b.Type == BlockToolResult {
+					out = append(out, llamaCppMessage{Role: "tool", Content: b.Text, ToolCallID: b.ToolCallID})
+				}
+			}
+		default: // user
+			out = append(out, llamaCppMessage{Role: "user", Content: textOf(msg.Blocks)})
+		}
+	}
+	return out
+}
+
+// toolArguments returns the JSON arguments of a tool call, defaulting to an
+// empty object.
+func toolArguments(input string) string {
+	if strings.TrimSpace(input) == "" {
+		return "{}"
+	}
+	return input
+}
+
+// localTurn assembles a Turn from the text and tool calls of a response.
+func localTurn(text string, calls []Block, usage Usage) *Turn {
+	turn := &Turn{Usage: usage, StopReason: StopEnd}
+	if text != "" {
+		turn.Blocks = append(turn.Blocks, TextBlock(text))
+	}
+	if len(calls) > 0 {
+		turn.Blocks = append(turn.Blocks, calls...)
+		turn.StopReason = StopToolUse
+	}
+	return turn
+}
+
+// ListLocalModels returns the models available on the local server of an
+// ollama or llamacpp provider: the pulled models of Ollama, or the model
+// llama-server was started with.
+func ListLocalModels(ctx context.Context, p config.Provider) ([]string, error) {
+	path := "/v1/models"
+	if p.Type == "ollama" {
+		path = "/api/tags"
+	}
+	endpoint := localEndpoint(p.Type, p.Endpoint)
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+path, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	resp, err := http.DefaultClient.Do(hreq)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't reach the %s server at %s", p.Type, endpoint)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, errors.WithStack(&serverError{Status: resp.StatusCode, Message: serverErrorMessage(msg)})
+	}
+
+	var list struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, errors.Wrap(err, "couldn't parse the model list")
+	}
+	// Recent llama-server versions send both lists; the OpenAI one is
+	// authoritative there.
+	var names []string
+	for _, m := range list.Data {
+		names = append(names, m.ID)
+	}
+	if len(names) > 0 {
+		return names, nil
+	}
+	for _, m := range list.Models {
+		names = append(names, m.Name)
+	}
+	return names, nil
+}
@@ -0,0 +1,209 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Tags delimiting prompt-based tool calls and their results.
+const (
+	toolCallOpen  = "<tool_call>"
+	toolCallClose = "</tool_call>"
+)
+
+// chatPromptTools runs a request with tools against a model without native
+// tool calling: the tools are described in the system prompt, earlier calls
+// and results are rendered as text, and the calls are parsed back out of the
+// model's answer. Text is streamed up to the first tool call.
+func (m *localModel) chatPromptTools(ctx context.Context, req Request, onText func(delta string)) (*Turn, error) {
+	var filter *toolCallFilter
+	if onText != nil {
+		filter = &toolCallFilter{onText: onText}
+		onText = filter.write
+	}
+	turn, err := m.chat(ctx, promptToolsRequest(req), onText)
+	if err != nil {
+		return nil, err
+	}
+	if filter != nil {
+		filter.flush()
+	}
+
+	prose, calls := parseToolCalls(textOf(turn.Blocks))
+	turn.Blocks = nil
+	if strings.TrimSpace(prose) != "" {
+		turn.Blocks = append(turn.Blocks, TextBlock(prose))
+	}
+	turn.Blocks = append(turn.Blocks, calls...)
+	turn.StopReason = StopEnd
+	if len(calls) > 0 {
+		turn.StopReason = StopToolUse
+	}
+	return turn, nil
+}
+
+// promptToolsRequest rewrites a request with tools into a plain-text one.
+func promptToolsRequest(req Request) Request {
+	out := Request{
+		System:      strings.TrimSpace(req.System + "\n\n" + toolPrompt(req.Tools)),
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+	}
+	names := map[string]string{}
+	for _, msg := range req.Messages {
+		var b strings.Builder
+		for _, blk := range msg.Blocks {
+			switch blk.Type {
+			case BlockText:
+				b.WriteString(blk.Text)
+			case BlockToolUse:
+				names[blk.ToolCallID] = blk.ToolName
+				call, _ := json.Marshal(map[string]any{
+					"name":      blk.ToolName,
+					"arguments": json.RawMessage(toolArguments(blk.Input)),
+				})
+				fmt.Fprintf(&b, "\n%s%s%s", toolCallOpen, call, toolCallClose)
+			case BlockToolResult:
+				errAttr := ""
+				if blk.IsError {
+					errAttr = ` error="true"`
+				}
+				fmt.Fprintf(&b, "<tool_result name=%q%s>\n%s\n</tool_result>\n", names[blk.ToolCallID], errAttr, blk.Text)
+			}
+		}
+		role := msg.Role
+		if role == RoleTool {
+			role = RoleUser
+		}
+		out.Messages = append(out.Messages, Message{Role: role, Blocks: []Block{TextBlock(strings.TrimSpace(b.String()))}})
+	}
+	return out
+}
+
+// toolPrompt describes the tools and the expected call format.
+func toolPrompt(tools []Tool) string {
+	var b strings.Builder
+	b.WriteString("You can call the tools listed below. To call a tool, answer with a tool call in exactly this format:\n")
+	fmt.Fprintf(&b, "%s{\"name\": \"<tool name>\", \"arguments\": {<arguments as JSON>}}%s\n", toolCallOpen, toolCallClose)
+	b.WriteString("You may make several calls in one answer; write nothing after them and wait for the results, ")
+	b.WriteString("which are sent back in <tool_result> tags. Only call the tools listed here.\n\nTools:\n")
+	for _, t := range tools {
+		info := t.Info()
+		schema, _ := json.Marshal(map[string]any{
+			"type":       "object",
+			"properties": info.Parameters,
+			"required":   info.Required,
+		})
+		fmt.Fprintf(&b, "- %s: %s\n  arguments schema: %s\n", info.Name, firstLine(info.Description), schema)
+	}
+	return b.String()
+}
+
+// firstLine returns the first line of s.
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return line
+}
+
+// parseToolCalls splits a prompt-based answer into its prose and its tool
+// calls. A call missing its closing tag at the end of the answer is accepted;
+// calls that aren't valid JSON are left in the prose.
+func parseToolCalls(text string) (string, []Block) {
+	var (
+		prose strings.Builder
+		calls []Block
+	)
+	rest := text
+	for {
+		start := strings.Index(rest, toolCallOpen)
+		if start < 0 {
+			prose.WriteString(rest)
+			break
+		}
+		prose.WriteString(rest[:start])
+		body := rest[start+len(toolCallOpen):]
+		raw, after, closed := strings.Cut(body, toolCallClose)
+		if !closed {
+			after = ""
+		}
+
+		var call struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimSpace(raw)), &call); err != nil || call.Name == "" {
+			prose.WriteString(rest[start : len(rest)-len(after)])
+		} else {
+			calls = append(calls, Block{
+				Type:       BlockToolUse,
+				ToolCallID: localCallID(),
+				ToolName:   call.Name,
+				Input:      promptArguments(call.Arguments),
+			})
+		}
+		rest = after
+	}
+	return strings.TrimSpace(prose.String()), calls
+}
+
+// promptArguments normalizes the arguments of a parsed call. Some models
+// encode the arguments object as a string.
+func promptArguments(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return toolArguments(s)
+	}
+	return toolArguments(string(raw))
+}
+
+// toolCallFilter forwards streamed text until a tool call starts. The end of
+// the text is held back while it could be the beginning of the opening tag.
+type toolCallFilter struct {
+	onText  func(delta string)
+	text    strings.Builder
+	sent    int
+	stopped bool
+}
+
+func (f *toolCallFilter) write(delta string) {
+	if f.stopped {
+		return
+	}
+	f.text.WriteString(delta)
+	s := f.text.String()
+	end := len(s)
+	if i := strings.Index(s[f.sent:], toolCallOpen); i >= 0 {
+		end = f.sent + i
+		f.stopped = true
+	} else {
+		end -= partialTag(s, toolCallOpen)
+	}
+	if end > f.sent {
+		f.onText(s[f.sent:end])
+		f.sent = end
+	}
+}
+
+// flush forwards the text held back at the end of the answer.
+func (f *toolCallFilter) flush() {
+	if f.stopped {
+		return
+	}
+	if s := f.text.String(); len(s) > f.sent {
+		f.onText(s[f.sent:])
+		f.sent = len(s)
+	}
+}
+
+// partialTag returns the length of the longest suffix of s that is a proper
+// prefix of tag.
+func partialTag(s, tag string) int {
+	for n := min(len(tag)-1, len(s)); n > 0; n-- {
+		if strings.HasSuffix(s, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
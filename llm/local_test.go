@@ -0,0 +1,240 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/elek/rai/config"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeOllama answers /api/chat with scripted NDJSON bodies, one per request,
+// and records the decoded requests. A response starting with "!" is sent as a
+// 400 error with the rest as its message.
+type fakeOllama struct {
+	t         *testing.T
+	mu        sync.Mutex
+	responses []string
+	requests  []map[string]any
+}
+
+func (f *fakeOllama) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/tags" {
+		_, _ = io.WriteString(w, `{"models":[{"name":"llama3.2:latest"},{"name":"qwen3:8b"}]}`)
+		return
+	}
+	require.Equal(f.t, "/api/chat", r.URL.Path)
+	var body map[string]any
+	require.NoError(f.t, json.NewDecoder(r.Body).Decode(&body))
+
+	f.mu.Lock()
+	f.requests = append(f.requests, body)
+	resp := f.responses[0]
+	f.responses = f.responses[1:]
+	f.mu.Unlock()
+
+	if msg, ok := strings.CutPrefix(resp, "!"); ok {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = io.WriteString(w, `{"error":"`+msg+`"}`)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	_, _ = io.WriteString(w, resp)
+}
+
+func newFakeOllama(t *testing.T, toolCalls string, responses ...string) (*fakeOllama, *httptest.Server, Model) {
+	t.Helper()
+	fake := &fakeOllama{t: t, responses: responses}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	m, err := NewOllamaModel(srv.URL, "llama3.2", toolCalls, 128, false)
+	require.NoError(t, err)
+	return fake, srv, m
+}
+
+// ollamaLines renders streamed message contents as Ollama NDJSON, with the
+// eval counts on the final line.
+func ollamaLines(prompt, eval int, contents ...string) string {
+	var b strings.Builder
+	for _, c := range contents {
+		data, _ := json.Marshal(map[string]any{"message": map[string]any{"role": "assistant", "content": c}, "done": false})
+		b.Write(data)
+		b.WriteString("\n")
+	}
+	data, _ := json.Marshal(map[string]any{
+		"message":           map[string]any{"role": "assistant", "content": ""},
+		"done":              true,
+		"prompt_eval_count": prompt,
+		"eval_count":        eval,
+	})
+	b.Write(data)
+	b.WriteString("\n")
+	return b.String()
+}
+
+type localEchoIn struct {
+	Text string `json:"text"`
+}
+
+func localEchoTool() Tool {
+	return NewTool[localEchoIn]("echo", "Echoes its input.", func(_ context.Context, in localEchoIn) (string, error) {
+		return "echoed " + in.Text, nil
+	})
+}
+
+func TestOllamaStreamsTextWithEvalCounts(t *testing.T) {
+	fake, _, m := newFakeOllama(t, "", ollamaLines(21, 3, "Hel", "lo"))
+
+	var streamed strings.Builder
+	turn, err := m.Stream(context.Background(), Request{
+		System:   "be brief",
+		Messages: []Message{UserMessage("hi")},
+	}, func(d string) { streamed.WriteString(d) })
+	require.NoError(t, err)
+
+	assert.Equal(t, "Hello", streamed.String())
+	assert.Equal(t, "Hello", textOf(turn.Blocks))
+	assert.Equal(t, Usage{InputTokens: 21, OutputTokens: 3, TotalTokens: 24}, turn.Usage)
+
+	req := fake.requests[0]
+	assert.Equal(t, "llama3.2", req["model"])
+	assert.Equal(t, map[string]any{"num_predict": float64(128)}, req["options"])
+	assert.Equal(t, "system", req["messages"].([]any)[0].(map[string]any)["role"])
+}
+
+func TestOllamaNativeToolCalls(t *testing.T) {
+	call := `{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"echo","arguments":{"text":"hi"}}}]},"done":true,"prompt_eval_count":5,"eval_count":2}` + "\n"
+	fake, _, m := newFakeOllama(t, "", call, ollamaLines(9, 1, "done"))
+
+	res, err := NewAgent(m, "", []Tool{localEchoTool()}).Run(context.Background(), "go", RunOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "done", res.Text)
+	assert.Equal(t, Usage{InputTokens: 14, OutputTokens: 3, TotalTokens: 17}, res.Usage)
+
+	assert.Len(t, fake.requests[0]["tools"], 1)
+	msgs := fake.requests[1]["messages"].([]any)
+	result := msgs[len(msgs)-1].(map[string]any)
+	assert.Equal(t, "tool", result["role"])
+	assert.Equal(t, "echo", result["tool_name"])
+	assert.Equal(t, "echoed hi", result["content"])
+}
+
+func TestOllamaFallsBackToPromptToolCalls(t *testing.T) {
+	fake, _, m := newFakeOllama(t, "",
+		"!registry.ollama.ai/library/gemma:2b does not support tools",
+		ollamaLines(30, 8, "Let me check. <tool", `_call>{"name": "echo", "arguments": {"text": "hi"}}</tool_call>`),
+		ollamaLines(40, 2, "done"),
+	)
+
+	var streamed strings.Builder
+	res, err := NewAgent(m, "", []Tool{localEchoTool()}).Run(context.Background(), "go", RunOptions{
+		OnTextDelta: func(d string) { streamed.WriteString(d) },
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "done", res.Text)
+	assert.Equal(t, "Let me check. done", streamed.String(), "the tool call markup is not streamed")
+
+	// The retry describes the tools in the system prompt instead.
+	require.Len(t, fake.requests, 3)
+	assert.NotContains(t, fake.requests[1], "tools")
+	system := fake.requests[1]["messages"].([]any)[0].(map[string]any)["content"].(string)
+	assert.Contains(t, system, "- echo: Echoes its input.")
+
+	// The call and its result are sent back as text.
+	msgs := fake.requests[2]["messages"].([]any)
+	assistant := msgs[2].(map[string]any)
+	assert.Equal(t, "assistant", assistant["role"])
+	assert.Contains(t, assistant["content"], `<tool_call>{"arguments":{"text":"hi"},"name":"echo"}</tool_call>`)
+	result := msgs[3].(map[string]any)
+	assert.Equal(t, "user", result["role"])
+	assert.Equal(t, "<tool_result name=\"echo\">\nechoed hi\n</tool_result>", result["content"])
+}
+
+func TestOllamaNativeModeRefusesWithoutToolSupport(t *testing.T) {
+	_, _, m := newFakeOllama(t, ToolCallsNative, "!gemma:2b does not support tools")
+
+	_, err := m.Stream(context.Background(), Request{
+		Messages: []Message{UserMessage("go")},
+		Tools:    []Tool{localEchoTool()},
+	}, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "doesn't support native tool calls")
+	assert.Contains(t, err.Error(), "tool_calls")
+}
+
+func TestListLocalModels(t *testing.T) {
+	_, srv, _ := newFakeOllama(t, "")
+	models, err := ListLocalModels(context.Background(), config.Provider{Type: "ollama", Endpoint: srv.URL})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"llama3.2:latest", "qwen3:8b"}, models)
+}
+
+func TestLlamaCppUsesTimingsForUsage(t *testing.T) {
+	var body map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/chat/completions", r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: "+`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"echo","arguments":"{\"te"}}]}}]}`+"\n\n"+
+			"data: "+`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"xt\":\"hi\"}"}}]}}]}`+"\n\n"+
+			"data: "+`{"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}],"timings":{"prompt_n":17,"predicted_n":6}}`+"\n\n"+
+			"data: [DONE]\n\n")
+	}))
+	t.Cleanup(srv.Close)
+
+	m, err := NewLlamaCppModel(srv.URL, "qwen", "", 0, false)
+	require.NoError(t, err)
+	turn, err := m.Stream(context.Background(), Request{
+		Messages: []Message{UserMessage("go")},
+		Tools:    []Tool{localEchoTool()},
+	}, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, StopToolUse, turn.StopReason)
+	assert.Equal(t, []Block{{Type: BlockToolUse, ToolCallID: "call_1", ToolName: "echo", Input: `{"text":"hi"}`}}, turn.Blocks)
+	assert.Equal(t, Usage{InputTokens: 17, OutputTokens: 6, TotalTokens: 23}, turn.Usage)
+	assert.Len(t, body["tools"], 1)
+}
+
+func TestParseToolCalls(t *testing.T) {
+	prose, calls := parseToolCalls("Sure.\n<tool_call>{\"name\":\"a\",\"arguments\":\"{\\\"x\\\":1}\"}</tool_call>\n<tool_call>not json</tool_call><tool_call>{\"name\":\"b\"}")
+	assert.Equal(t, "Sure.\n\n<tool_call>not json</tool_call>", prose)
+	require.Len(t, calls, 2)
+	assert.Equal(t, "a", calls[0].ToolName)
+	assert.Equal(t, `{"x":1}`, calls[0].Input)
+	assert.Equal(t, "b", calls[1].ToolName)
+	assert.Equal(t, "{}", calls[1].Input)
+
+	_, again := parseToolCalls("<tool_call>{\"name\":\"a\"}</tool_call>")
+	assert.NotEqual(t, calls[0].ToolCallID, again[0].ToolCallID, "the IDs of later turns don't repeat")
+	assert.NotEqual(t, calls[0].ToolCallID, calls[1].ToolCallID)
+}
+
+func TestLocalToolsWithoutParameters(t *testing.T) {
+	none := NewTool[struct{}]("now", "Tells the time.", func(context.Context, struct{}) (string, error) { return "noon", nil })
+	data, err := json.Marshal(toLocalTools([]Tool{none, localEchoTool()}))
+	require.NoError(t, err)
+	var tools []struct {
+		Function struct {
+			Parameters map[string]any `json:"parameters"`
+		} `json:"function"`
+	}
+	require.NoError(t, json.Unmarshal(data, &tools))
+	assert.Equal(t, map[string]any{"type": "object", "properties": map[string]any{}}, tools[0].Function.Parameters)
+	assert.Contains(t, tools[1].Function.Parameters, "required")
+}
+
+func TestToolsUnsupported(t *testing.T) {
+	assert.True(t, toolsUnsupported(&serverError{Status: 400, Message: "registry.ollama.ai/library/gemma:2b does not support tools"}))
+	assert.True(t, toolsUnsupported(&serverError{Status: 500, Message: "tools param requires --jinja flag"}))
+	assert.False(t, toolsUnsupported(&serverError{Status: 400, Message: "invalid tool call arguments"}))
+	assert.False(t, toolsUnsupported(errors.New("does not support tools")))
+}
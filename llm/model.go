@@ -10,6 +10,8 @@ const (
 	StopEnd StopReason = "end"
 	// StopToolUse means the model is requesting one or more tool calls.
 	StopToolUse StopReason = "tool_use"
+	// StopMaxTokens means the response was cut off at the token limit.
+	StopMaxTokens StopReason = "max_tokens"
 )
 
 // Request is a single model invocation: the system prompt, the conversation so
@@ -20,6 +22,9 @@ type Request struct {
 	Tools       []Tool
 	MaxTokens   int64
 	Temperature float64
+	// OnThinking, when set, receives the streamed reasoning text of models
+	// that expose it (Anthropic extended thinking).
+	OnThinking func(delta string)
 }
 
 // Turn is the assistant's response to a Request: its content blocks (text and
@@ -2,6 +2,7 @@ package llm
 
 import (
 	"context"
+	"strconv"
 
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
@@ -12,9 +13,13 @@ import (
 // openaiModel implements Model against the official OpenAI SDK (chat completions).
 type openaiModel struct {
 	client    openai.Client
+	provider  string
 	model     string
 	maxTokens int64
 	debug     bool
+	// extra holds request options added to every request, for providers that
+	// extend the chat completions API (such as OpenRouter's routing fields).
+	extra []option.RequestOption
 }
 
 // NewOpenAIModel creates a Model backed by the OpenAI chat completions API. A
@@ -27,13 +32,14 @@ func NewOpenAIModel(apiKey, baseURL, model string, maxTokens int64, debug bool)
 	}
 	return &openaiModel{
 		client:    openai.NewClient(opts...),
+		provider:  "openai",
 		model:     model,
 		maxTokens: maxTokens,
 		debug:     debug,
 	}
 }
 
-func (m *openaiModel) Provider() string { return "openai" }
+func (m *openaiModel) Provider() string { return m.provider }
 func (m *openaiModel) Name() string     { return m.model }
 
 func (m *openaiModel) Stream(ctx context.Context, req Request, onText func(delta string)) (*Turn, error) {
@@ -57,11 +63,13 @@ func (m *openaiModel) Stream(ctx context.Context, req Request, onText func(delta
 		debugRequest(m.Provider(), m.model, req)
 	}
 
-	stream := m.client.Chat.Completions.NewStreaming(ctx, params)
+	stream := m.client.Chat.Completions.NewStreaming(ctx, params, m.extra...)
 	acc := openai.ChatCompletionAccumulator{}
+	var cost float64
 	for stream.Next() {
 		chunk := stream.Current()
 		acc.AddChunk(chunk)
+		cost += chunkCost(chunk)
 		if onText != nil && len(chunk.Choices) > 0 {
 			if d := chunk.Choices[0].Delta.Content; d != "" {
 				onText(d)
@@ -73,12 +81,28 @@ func (m *openaiModel) Stream(ctx context.Context, req Request, onText func(delta
 	}
 
 	turn := turnFromOpenAI(&acc)
+	turn.Usage.Cost = cost
 	if m.debug {
 		debugTurn(m.Provider(), m.model, turn)
 	}
 	return turn, nil
 }
 
+// chunkCost returns the request cost (in USD) reported in the usage of a
+// streamed chunk. This is not part of the OpenAI API: OpenRouter adds it, and
+// other endpoints leave it out, which yields zero.
+func chunkCost(chunk openai.ChatCompletionChunk) float64 {
+	field, ok := chunk.Usage.JSON.ExtraFields["cost"]
+	if !ok {
+		return 0
+	}
+	cost, err := strconv.ParseFloat(field.Raw(), 64)
+	if err != nil {
+		return 0
+	}
+	return cost
+}
+
 // toOpenAIMessages converts neutral messages into OpenAI chat message params,
 // prepending the system prompt when present.
 func toOpenAIMessages(system string, msgs []Message) []openai.ChatCompletionMessageParamUnion {
@@ -132,6 +132,10 @@ func toResponsesInput(system string, msgs []Message) responses.ResponseInputPara
 				}
 				input = append(input, responses.ResponseInputItemParamOfMessage(b.Text, role))
 			case BlockReasoning:
+				if b.Kind() != ReasoningEncrypted {
+					// Reasoning of another provider.
+					continue
+				}
 				item := responses.ResponseInputItemParamOfReasoning(b.ToolCallID, []responses.ResponseReasoningItemSummaryParam{})
 				item.OfReasoning.EncryptedContent = openai.String(b.Text)
 				input = append(input, item)
@@ -189,7 +193,7 @@ func blocksFromResponseItems(items []responses.ResponseOutputItemUnion) ([]Block
 		switch item.Type {
 		case "reasoning":
 			r := item.AsReasoning()
-			blocks = append(blocks, Block{Type: BlockReasoning, ToolCallID: r.ID, Text: r.EncryptedContent})
+			blocks = append(blocks, Block{Type: BlockReasoning, Reasoning: ReasoningEncrypted, ToolCallID: r.ID, Text: r.EncryptedContent})
 		case "function_call":
 			fn := item.AsFunctionCall()
 			blocks = append(blocks, Block{Type: BlockToolUse, ToolCallID: fn.CallID, ToolName: fn.Name, Input: fn.Arguments})
@@ -0,0 +1,70 @@
+package llm
+
+import (
+	"github.com/elek/rai/config"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// openRouterURL is the OpenRouter endpoint of the chat completions API.
+const openRouterURL = "https://openrouter.ai/api/v1"
+
+// NewOpenRouterModel creates a Model backed by OpenRouter, which serves models
+// of many vendors through the OpenAI chat completions API. Requests identify
+// rai to OpenRouter (for its app rankings), carry the routing preferences of
+// routing (which may be nil), and ask for the request cost, which is reported
+// in Usage.Cost. A non-empty baseURL replaces the public endpoint.
+func NewOpenRouterModel(apiKey, baseURL, model string, routing *config.Routing, maxTokens int64, debug bool) Model {
+	if baseURL == "" {
+		baseURL = openRouterURL
+	}
+	return &openaiModel{
+		client: openai.NewClient(
+			option.WithAPIKey(apiKey),
+			option.WithBaseURL(baseURL),
+			option.WithHeader("HTTP-Referer", "https://github.com/elek/rai"),
+			option.WithHeader("X-Title", "rai"),
+		),
+		provider:  "openrouter",
+		model:     model,
+		maxTokens: maxTokens,
+		debug:     debug,
+		extra:     openRouterOptions(routing),
+	}
+}
+
+// openRouterOptions returns the request fields OpenRouter adds to the chat
+// completions API: usage accounting, upstream provider preferences, and
+// fallback models.
+func openRouterOptions(routing *config.Routing) []option.RequestOption {
+	opts := []option.RequestOption{
+		option.WithJSONSet("usage", map[string]any{"include": true}),
+	}
+	if routing == nil {
+		return opts
+	}
+
+	prefs := map[string]any{}
+	if len(routing.Order) > 0 {
+		prefs["order"] = routing.Order
+	}
+	if len(routing.Only) > 0 {
+		prefs["only"] = routing.Only
+	}
+	if len(routing.Ignore) > 0 {
+		prefs["ignore"] = routing.Ignore
+	}
+	if routing.AllowFallbacks != nil {
+		prefs["allow_fallbacks"] = *routing.AllowFallbacks
+	}
+	if routing.Sort != "" {
+		prefs["sort"] = routing.Sort
+	}
+	if len(prefs) > 0 {
+		opts = append(opts, option.WithJSONSet("provider", prefs))
+	}
+	if len(routing.Fallbacks) > 0 {
+		opts = append(opts, option.WithJSONSet("models", routing.Fallbacks))
+	}
+	return opts
+}
@@ -0,0 +1,67 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/elek/rai/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenRouterSendsRoutingAndReportsCost(t *testing.T) {
+	var (
+		header http.Header
+		body   map[string]any
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		data, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, &body))
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: "+`{"id":"gen-1","object":"chat.completion.chunk","created":1,"model":"anthropic/claude-sonnet-4","choices":[{"index":0,"delta":{"role":"assistant","content":"Paris"}}]}`+"\n\n"+
+			"data: "+`{"id":"gen-1","object":"chat.completion.chunk","created":1,"model":"anthropic/claude-sonnet-4","choices":[{"index":0,"delta":{},"finish_reason":"stop"}],"usage":{"prompt_tokens":12,"completion_tokens":1,"total_tokens":13,"cost":0.00042}}`+"\n\n"+
+			"data: [DONE]\n\n")
+	}))
+	t.Cleanup(srv.Close)
+
+	noFallbacks := false
+	routing := &config.Routing{
+		Order:          []string{"anthropic", "amazon-bedrock"},
+		AllowFallbacks: &noFallbacks,
+		Sort:           "price",
+		Fallbacks:      []string{"openai/gpt-4o"},
+	}
+	m := NewOpenRouterModel("or-key", srv.URL, "anthropic/claude-sonnet-4", routing, 256, false)
+	assert.Equal(t, "openrouter", m.Provider())
+
+	turn, err := m.Stream(context.Background(), Request{Messages: []Message{UserMessage("Capital of France?")}}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Paris", textOf(turn.Blocks))
+	assert.Equal(t, Usage{InputTokens: 12, OutputTokens: 1, TotalTokens: 13, Cost: 0.00042}, turn.Usage)
+
+	assert.Equal(t, "Bearer or-key", header.Get("Authorization"))
+	assert.Equal(t, "https://github.com/elek/rai", header.Get("HTTP-Referer"))
+	assert.Equal(t, "rai", header.Get("X-Title"))
+
+	assert.Equal(t, "anthropic/claude-sonnet-4", body["model"])
+	assert.Equal(t, map[string]any{"include": true}, body["usage"])
+	assert.Equal(t, map[string]any{
+		"order":           []any{"anthropic", "amazon-bedrock"},
+		"allow_fallbacks": false,
+		"sort":            "price",
+	}, body["provider"])
+	assert.Equal(t, []any{"openai/gpt-4o"}, body["models"])
+}
+
+func TestOpenRouterWithoutRoutingOmitsPreferences(t *testing.T) {
+	opts := openRouterOptions(nil)
+	assert.Len(t, opts, 1, "only usage accounting is requested")
+	assert.Len(t, openRouterOptions(&config.Routing{}), 1)
+}
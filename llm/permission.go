@@ -0,0 +1,336 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/elek/rai/config"
+	"github.com/pkg/errors"
+)
+
+// Permission is what a policy decides about a tool call.
+type Permission string
+
+const (
+	// PermissionAllow runs the call.
+	PermissionAllow Permission = "allow"
+	// PermissionAsk runs the call only if the user approves it.
+	PermissionAsk Permission = "ask"
+	// PermissionDeny refuses the call; the model gets an error result instead.
+	PermissionDeny Permission = "deny"
+)
+
+// shellOperators are the characters that chain, substitute, or redirect shell
+// commands. An allow rule never matches a command containing any of them, so
+// allowing "git status*" doesn't allow "git status; rm -rf ~".
+const shellOperators = ";&|`$<>\n"
+
+// Policy decides which tool calls run, which are refused, and which need the
+// user's approval. It is built from the permissions section of the config.
+type Policy struct {
+	rules []permissionRule
+	def   Permission
+}
+
+type permissionRule struct {
+	tool    *regexp.Regexp
+	pattern *regexp.Regexp
+	action  Permission
+}
+
+// NewPolicy compiles the configured permissions. Without any configuration,
+// every call is allowed.
+func NewPolicy(cfg config.Permissions) (*Policy, error) {
+	p := &Policy{def: PermissionAllow}
+	if cfg.Default != "" {
+		def, err := parsePermission(cfg.Default)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid default permission")
+		}
+		p.def = def
+	}
+	for i, r := range cfg.Rules {
+		action, err := parsePermission(r.Action)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid permission rule %d", i+1)
+		}
+		tool := r.Tool
+		if tool == "" {
+			tool = "*"
+		}
+		rule := permissionRule{tool: globRegexp(tool), action: action}
+		if r.Pattern != "" {
+			rule.pattern = globRegexp(r.Pattern)
+		}
+		p.rules = append(p.rules, rule)
+	}
+	return p, nil
+}
+
+func parsePermission(s string) (Permission, error) {
+	switch p := Permission(s); p {
+	case PermissionAllow, PermissionAsk, PermissionDeny:
+		return p, nil
+	}
+	return "", errors.Errorf("unknown action %q (expected allow, ask, or deny)", s)
+}
+
+// globRegexp compiles a glob where * matches any text (slashes and spaces
+// included) and ? any single character.
+func globRegexp(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString(`(?s)^`)
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// Decide returns what the policy says about call. A command is split into the
+// commands it chains: it is denied if any of them is, needs approval if any of
+// them does, and is allowed only if all of them are. A command that can't be
+// split reliably is never allowed without approval.
+func (p *Policy) Decide(call ToolCall) Permission {
+	subject, command := callSubject(call)
+	if !command {
+		return p.decide(call.Name, subject, false)
+	}
+	parts, ok := splitCommand(subject)
+	if len(parts) == 0 {
+		parts = []string{""}
+	}
+	res := PermissionAllow
+	for _, part := range parts {
+		switch p.decide(call.Name, part, true) {
+		case PermissionDeny:
+			return PermissionDeny
+		case PermissionAsk:
+			res = PermissionAsk
+		}
+	}
+	if !ok {
+		res = PermissionAsk
+	}
+	return res
+}
+
+// decide returns the action of the first rule matching the subject of a call
+// of the named tool.
+func (p *Policy) decide(name string, subject string, command bool) Permission {
+	for _, r := range p.rules {
+		if !r.tool.MatchString(name) {
+			continue
+		}
+		if r.pattern != nil {
+			if !r.pattern.MatchString(subject) {
+				continue
+			}
+			if r.action == PermissionAllow && command && strings.ContainsAny(subject, shellOperators) {
+				continue
+			}
+		}
+		return r.action
+	}
+	return p.def
+}
+
+// splitCommand splits a shell command line into the commands it runs: at ;,
+// &, &&, |, ||, newlines and parentheses, and around $(...) and `...`
+// substitutions, outside of quotes. ok is false when the command can't be
+// split reliably: with unbalanced quotes, or a substitution within double
+// quotes.
+func splitCommand(command string) (parts []string, ok bool) {
+	var cur strings.Builder
+	flush := func() {
+		if s := strings.TrimSpace(cur.String()); s != "" {
+			parts = append(parts, s)
+		}
+		cur.Reset()
+	}
+	ok = true
+	var quote rune
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			cur.WriteRune(r)
+			if r == '\'' {
+				quote = 0
+			}
+		case r == '\\' && i+1 < len(runes):
+			cur.WriteRune(r)
+			cur.WriteRune(runes[i+1])
+			i++
+		case quote == '"':
+			cur.WriteRune(r)
+			if r == '"' {
+				quote = 0
+			} else if r == '`' || r == '$' && i+1 < len(runes) && runes[i+1] == '(' {
+				ok = false
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			cur.WriteRune(r)
+		case strings.ContainsRune(";&|\n()`", r):
+			flush()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if quote != 0 {
+		ok = false
+	}
+	flush()
+	return parts, ok
+}
+
+// callSubject returns what the patterns of a call are matched against: the
+// command of shell-like tools (for git, as the git tool runs it), the cleaned
+// path of file tools, or else the raw JSON input. command reports whether the
+// subject is a command.
+func callSubject(call ToolCall) (subject string, command bool) {
+	var args map[string]any
+	if json.Unmarshal([]byte(call.Input), &args) == nil {
+		if s, ok := args["command"].(string); ok {
+			if call.Name == "git" {
+				return GitCommand(s), true
+			}
+			return strings.TrimSpace(s), true
+		}
+		if s, ok := args["path"].(string); ok {
+			return filepath.Clean(s), false
+		}
+	}
+	return call.Input, false
+}
+
+// GitCommand returns the command line the git tool runs for command, which
+// may leave out git itself.
+func GitCommand(command string) string {
+	command = strings.TrimSpace(command)
+	if command != "git" && !strings.HasPrefix(command, "git ") {
+		command = "git " + command
+	}
+	return command
+}
+
+// Approval is the user's answer to a tool call that needs approval.
+type Approval int
+
+const (
+	// ApproveOnce runs this call.
+	ApproveOnce Approval = iota
+	// ApproveAlways runs this call and every later call of the same tool on
+	// the same subject (command or path) that would need approval.
+	ApproveAlways
+	// RejectOnce refuses this call.
+	RejectOnce
+	// RejectAlways refuses this call and every later call of the same tool
+	// on the same subject that would need approval.
+	RejectAlways
+)
+
+// ApprovalRequest describes a tool call waiting for the user's approval.
+type ApprovalRequest struct {
+	Call ToolCall
+	// Subject is the command or path the call acts on, or its raw input.
+	Subject string
+}
+
+// String describes the call in one line, e.g. "git: git push".
+func (r ApprovalRequest) String() string {
+	return r.Call.Name + ": " + r.Subject
+}
+
+// Approver asks the user about a tool call. It is called for one call at a
+// time.
+type Approver func(ctx context.Context, req ApprovalRequest) (Approval, error)
+
+// Guard wraps tools so that each call is checked against the policy before it
+// runs. Calls that need approval are passed to approve; without an approver
+// they are refused. An "always" answer holds for as long as the returned tools
+// are used. When the policy allows everything, tools are returned unchanged.
+func (p *Policy) Guard(tools []Tool, approve Approver) []Tool {
+	if p.def == PermissionAllow && len(p.rules) == 0 {
+		return tools
+	}
+	g := &gate{policy: p, approve: approve, always: map[string]Approval{}}
+	out := make([]Tool, 0, len(tools))
+	for _, t := range tools {
+		out = append(out, guardedTool{Tool: t, gate: g})
+	}
+	return out
+}
+
+// gate holds the state shared by the tools of one Guard call: the approver
+// and the "always" answers given so far.
+type gate struct {
+	policy  *Policy
+	approve Approver
+
+	mu sync.Mutex
+	// always holds the "always" answers by the request they were given for.
+	always map[string]Approval
+}
+
+// check returns an error if call may not run.
+func (g *gate) check(ctx context.Context, call ToolCall) error {
+	switch g.policy.Decide(call) {
+	case PermissionAllow:
+		return nil
+	case PermissionDeny:
+		return errors.Errorf("permission denied: the %s tool call is not allowed by the permission policy", call.Name)
+	}
+
+	// Ask one question at a time, even when calls run in parallel.
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	// An "always" answer is kept for the subject it was given for, so that
+	// allowing `git status` doesn't allow `git push --force` too.
+	subject, _ := callSubject(call)
+	req := ApprovalRequest{Call: call, Subject: subject}
+	answer, known := g.always[req.String()]
+	if !known {
+		if g.approve == nil {
+			return errors.Errorf("permission denied: the %s tool call needs approval, but there is no one to ask", call.Name)
+		}
+		var err error
+		answer, err = g.approve(ctx, req)
+		if err != nil {
+			return errors.Wrap(err, "permission denied: the tool call couldn't be approved")
+		}
+		if answer == ApproveAlways || answer == RejectAlways {
+			g.always[req.String()] = answer
+		}
+	}
+	if answer == RejectOnce || answer == RejectAlways {
+		return errors.Errorf("permission denied: the user rejected the %s tool call", call.Name)
+	}
+	return nil
+}
+
+// guardedTool checks each call of the wrapped tool against its gate.
+type guardedTool struct {
+	Tool
+	gate *gate
+}
+
+func (t guardedTool) Run(ctx context.Context, call ToolCall) (ToolResult, error) {
+	if err := t.gate.check(ctx, call); err != nil {
+		return ToolResult{Content: err.Error(), IsError: true}, nil
+	}
+	return t.Tool.Run(ctx, call)
+}
@@ -0,0 +1,145 @@
+package llm
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/elek/rai/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gitCall(command string) ToolCall {
+	return ToolCall{ID: "call-1", Name: "git", Input: `{"command":"` + command + `"}`}
+}
+
+func TestPolicyDecide(t *testing.T) {
+	policy, err := NewPolicy(config.Permissions{
+		Default: "ask",
+		Rules: []config.PermissionRule{
+			{Tool: "git", Pattern: "git status*", Action: "allow"},
+			{Tool: "git", Pattern: "git diff*", Action: "allow"},
+			{Tool: "bash", Pattern: "rm *", Action: "deny"},
+			{Tool: "cat", Action: "allow"},
+			{Tool: "create", Pattern: "/etc/*", Action: "deny"},
+		},
+	})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		call ToolCall
+		want Permission
+	}{
+		{gitCall("git status"), PermissionAllow},
+		{gitCall("git diff src/main.go"), PermissionAllow},
+		{gitCall("git push origin main"), PermissionAsk},
+		{gitCall("git status; rm -rf ~"), PermissionAsk},
+		{gitCall("git diff > patch"), PermissionAsk},
+		{ToolCall{Name: "bash", Input: `{"command":"rm -rf build"}`}, PermissionDeny},
+		{ToolCall{Name: "cat", Input: `{"path":"/etc/passwd"}`}, PermissionAllow},
+		{ToolCall{Name: "create", Input: `{"path":"/etc/hosts","file_text":"x"}`}, PermissionDeny},
+		{ToolCall{Name: "create", Input: `{"path":"main.go","file_text":"x"}`}, PermissionAsk},
+	} {
+		assert.Equal(t, tc.want, policy.Decide(tc.call), tc.call.Input)
+	}
+}
+
+func TestPolicyDecideChainedCommands(t *testing.T) {
+	policy, err := NewPolicy(config.Permissions{
+		Rules: []config.PermissionRule{
+			{Tool: "bash", Pattern: "rm -rf *", Action: "deny"},
+			{Tool: "bash", Pattern: "go test*", Action: "allow"},
+			{Tool: "git", Pattern: "git push*", Action: "ask"},
+			{Tool: "git", Pattern: "git reset --hard*", Action: "deny"},
+			{Tool: "cat", Pattern: "/tmp/*", Action: "allow"},
+			{Tool: "cat", Action: "deny"},
+		},
+	})
+	require.NoError(t, err)
+	bash := func(command string) ToolCall {
+		return ToolCall{Name: "bash", Input: `{"command":` + strconv.Quote(command) + `}`}
+	}
+
+	for _, tc := range []struct {
+		call ToolCall
+		want Permission
+	}{
+		{bash("true; rm -rf /"), PermissionDeny},
+		{bash("ls && rm -rf /"), PermissionDeny},
+		{bash("false || rm -rf /"), PermissionDeny},
+		{bash("echo y | rm -rf /"), PermissionDeny},
+		{bash("echo\nrm -rf /"), PermissionDeny},
+		{bash("echo $(rm -rf /)"), PermissionDeny},
+		{bash("echo `rm -rf /`"), PermissionDeny},
+		{bash("(rm -rf /)"), PermissionDeny},
+		{bash("echo 'rm -rf /; ok'"), PermissionAllow},
+		{bash("go test ./... && go test -race ./..."), PermissionAllow},
+		{bash(`echo "$(rm -rf /)"`), PermissionAsk},
+		{bash(`echo "unbalanced`), PermissionAsk},
+		{gitCall("git status && git push"), PermissionAsk},
+		{gitCall("status; git push origin main"), PermissionAsk},
+		{gitCall("push origin main"), PermissionAsk},
+		{gitCall("reset --hard HEAD~1"), PermissionDeny},
+		{gitCall("log"), PermissionAllow},
+		{ToolCall{Name: "cat", Input: `{"path":"/tmp/notes"}`}, PermissionAllow},
+		{ToolCall{Name: "cat", Input: `{"path":"/tmp/../home/u/.bashrc"}`}, PermissionDeny},
+	} {
+		assert.Equal(t, tc.want, policy.Decide(tc.call), tc.call.Input)
+	}
+}
+
+func TestPolicyRejectsUnknownAction(t *testing.T) {
+	_, err := NewPolicy(config.Permissions{Rules: []config.PermissionRule{{Tool: "git", Action: "maybe"}}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "rule 1")
+}
+
+func TestGuardWithoutRulesKeepsTools(t *testing.T) {
+	policy, err := NewPolicy(config.Permissions{})
+	require.NoError(t, err)
+	tools := []Tool{localEchoTool()}
+	assert.Equal(t, tools, policy.Guard(tools, nil))
+}
+
+func TestGuardAsksAndRemembersAlways(t *testing.T) {
+	policy, err := NewPolicy(config.Permissions{Default: "ask"})
+	require.NoError(t, err)
+
+	var asked []string
+	answers := []Approval{RejectOnce, ApproveAlways, ApproveOnce}
+	tools := policy.Guard([]Tool{localEchoTool()}, func(_ context.Context, req ApprovalRequest) (Approval, error) {
+		asked = append(asked, req.String())
+		answer := answers[0]
+		answers = answers[1:]
+		return answer, nil
+	})
+	echo := tools[0]
+
+	res, err := echo.Run(context.Background(), ToolCall{Name: "echo", Input: `{"text":"a"}`})
+	require.NoError(t, err)
+	assert.True(t, res.IsError)
+	assert.Contains(t, res.Content, "the user rejected the echo tool call")
+
+	for _, text := range []string{"b", "b", "c"} {
+		res, err = echo.Run(context.Background(), ToolCall{Name: "echo", Input: `{"text":"` + text + `"}`})
+		require.NoError(t, err)
+		assert.Equal(t, ToolResult{Content: "echoed " + text}, res)
+	}
+	assert.Equal(t, []string{`echo: {"text":"a"}`, `echo: {"text":"b"}`, `echo: {"text":"c"}`}, asked, "an always answer is not asked again, for the same call only")
+}
+
+func TestGuardRefusesWithoutApprover(t *testing.T) {
+	policy, err := NewPolicy(config.Permissions{
+		Default: "deny",
+		Rules:   []config.PermissionRule{{Tool: "echo", Action: "ask"}},
+	})
+	require.NoError(t, err)
+	tools := policy.Guard([]Tool{localEchoTool(), Parallel(localEchoTool())}, nil)
+
+	res, err := tools[0].Run(context.Background(), ToolCall{Name: "echo", Input: `{"text":"a"}`})
+	require.NoError(t, err)
+	assert.True(t, res.IsError)
+	assert.Contains(t, res.Content, "no one to ask")
+	assert.True(t, tools[1].Info().Parallel, "the guard keeps the tool info")
+}
@@ -18,7 +18,9 @@ type WithModel struct {
 
 // NewModel creates a Model from the given config and model definition. Supported
 // provider types in this phase are: anthropic, openai (and openai-compatible),
-// openai-responses (the OpenAI Responses API, for reasoning models), and fake.
+// openai-responses (the OpenAI Responses API, for reasoning models), google
+// (Gemini, on Vertex AI when the provider has a project), openrouter, ollama and
+// llamacpp (local model servers), and fake.
 func NewModel(ctx context.Context, cfg config.Config, model config.Model) (Model, error) {
 	p, found := cfg.FindProvider(model.Provider)
 	if !found {
@@ -30,13 +32,22 @@ func NewModel(ctx context.Context, cfg config.Config, model config.Model) (Model
 	case "fake":
 		return NewFakeModel(model.Provider, model.Model), nil
 	case "anthropic":
-		return NewAnthropicModel(p.Key, p.Endpoint, model.Model, maxTokens, model.Debug), nil
+		return NewAnthropicModel(p.Key, p.Endpoint, model.Model, maxTokens, int64(model.ThinkingBudget), model.Debug), nil
 	case "openai", "openaicompat":
 		return NewOpenAIModel(p.Key, p.Endpoint, model.Model, maxTokens, model.Debug), nil
 	case "openai-responses":
 		return NewOpenAIResponsesModel(p.Key, p.Endpoint, model.Model, maxTokens, model.Debug), nil
-	case "google", "openrouter":
-		return nil, errors.New("provider type not supported in this phase: " + p.Type)
+	case "google":
+		if p.Project != "" {
+			return NewVertexGeminiModel(ctx, p.Project, p.Location, p.CredentialFile, p.Endpoint, model.Model, maxTokens, model.Debug)
+		}
+		return NewGeminiModel(ctx, p.Key, p.Endpoint, model.Model, maxTokens, model.Debug)
+	case "openrouter":
+		return NewOpenRouterModel(p.Key, p.Endpoint, model.Model, model.Routing, maxTokens, model.Debug), nil
+	case "ollama":
+		return NewOllamaModel(p.Endpoint, model.Model, p.ToolCalls, maxTokens, model.Debug)
+	case "llamacpp":
+		return NewLlamaCppModel(p.Endpoint, model.Model, p.ToolCalls, maxTokens, model.Debug)
 	default:
 		return nil, errors.New("unknown provider type: " + p.Type)
 	}
@@ -50,14 +61,20 @@ func (w WithModel) ResolveModel(cfg config.Config) (config.Model, error) {
 	if w.Model == "" {
 		return config.Model{}, nil
 	}
-	if mod, found := cfg.FindModel(w.Model); found {
+	return LookupModel(cfg, w.Model)
+}
+
+// LookupModel resolves a model name to its config.Model: either a model defined
+// in the config or an ad-hoc "provider/model" reference.
+func LookupModel(cfg config.Config, name string) (config.Model, error) {
+	if mod, found := cfg.FindModel(name); found {
 		return mod, nil
 	}
-	prov, modName, ok := strings.Cut(w.Model, "/")
+	prov, modName, ok := strings.Cut(name, "/")
 	if !ok {
-		return config.Model{}, errors.Errorf("model %q not found in config and not in provider/model format", w.Model)
+		return config.Model{}, errors.Errorf("model %q not found in config and not in provider/model format", name)
 	}
-	return config.Model{Name: w.Model, Provider: prov, Model: modName}, nil
+	return config.Model{Name: name, Provider: prov, Model: modName}, nil
 }
 
 // CreateModel resolves the configured model and instantiates it.
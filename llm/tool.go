@@ -18,6 +18,9 @@ type ToolInfo struct {
 	Parameters map[string]any
 	// Required lists the names of required parameters.
 	Required []string
+	// Parallel marks the tool as safe to run concurrently with the other tool
+	// calls of a turn, because it only reads. Other tools run one at a time.
+	Parallel bool
 }
 
 // ToolCall is a request from the model to invoke a tool.
@@ -39,6 +42,23 @@ type Tool interface {
 	Run(ctx context.Context, call ToolCall) (ToolResult, error)
 }
 
+// parallelTool wraps a tool to declare it safe to run concurrently.
+type parallelTool struct {
+	Tool
+}
+
+func (t parallelTool) Info() ToolInfo {
+	info := t.Tool.Info()
+	info.Parallel = true
+	return info
+}
+
+// Parallel declares t safe to run concurrently with the other tool calls of a
+// turn, such as a tool that only reads files.
+func Parallel(t Tool) Tool {
+	return parallelTool{Tool: t}
+}
+
 // typedTool adapts a strongly-typed callback into a Tool, generating the input
 // schema from the struct tags of T via reflection.
 type typedTool[T any] struct {
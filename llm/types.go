@@ -1,22 +1,34 @@
 // Package llm defines a provider-neutral interface for large language models
 // and an agent loop that drives multi-turn tool calling on top of it. Concrete
-// implementations are provided for Anthropic, OpenAI, and a fake model used in
-// tests.
+// implementations are provided for Anthropic, OpenAI, Gemini, OpenRouter, and a
+// fake model used in tests.
 package llm
 
 // Usage reports token consumption for one or more model turns.
 type Usage struct {
+	// InputTokens counts the input tokens that were neither written to nor read
+	// from the prompt cache.
 	InputTokens  int64
 	OutputTokens int64
-	TotalTokens  int64
+	// CacheCreationTokens counts the input tokens written to the prompt cache.
+	CacheCreationTokens int64
+	// CacheReadTokens counts the input tokens read from the prompt cache.
+	CacheReadTokens int64
+	TotalTokens     int64
+	// Cost is the price of the request in USD as reported by the provider
+	// (OpenRouter does). It is zero when the provider doesn't report it.
+	Cost float64
 }
 
 // Add returns the element-wise sum of two Usage values.
 func (u Usage) Add(o Usage) Usage {
 	return Usage{
-		InputTokens:  u.InputTokens + o.InputTokens,
-		OutputTokens: u.OutputTokens + o.OutputTokens,
-		TotalTokens:  u.TotalTokens + o.TotalTokens,
+		InputTokens:         u.InputTokens + o.InputTokens,
+		OutputTokens:        u.OutputTokens + o.OutputTokens,
+		CacheCreationTokens: u.CacheCreationTokens + o.CacheCreationTokens,
+		CacheReadTokens:     u.CacheReadTokens + o.CacheReadTokens,
+		TotalTokens:         u.TotalTokens + o.TotalTokens,
+		Cost:                u.Cost + o.Cost,
 	}
 }
 
@@ -39,33 +51,73 @@ const (
 	BlockToolUse BlockType = "tool_use"
 	// BlockToolResult is the result of a tool call, sent back to the model.
 	BlockToolResult BlockType = "tool_result"
-	// BlockReasoning carries a reasoning item produced by a reasoning model. Its
-	// content is opaque (provider-encrypted) and exists only to be sent back on
-	// later turns so the model retains its chain of thought across tool calls.
-	// It is never streamed or displayed; providers that don't understand it
-	// ignore it. ToolCallID holds the item id, Text holds the encrypted content.
+	// BlockReasoning carries reasoning produced by a reasoning model. It exists
+	// to be sent back on later turns so the model retains its chain of thought
+	// across tool calls, and each provider only sends back its own kind (see
+	// ReasoningKind).
 	BlockReasoning BlockType = "reasoning"
 )
 
+// ReasoningKind tells apart the reasoning blocks of the providers.
+type ReasoningKind string
+
+const (
+	// ReasoningEncrypted is an OpenAI reasoning item: ToolCallID holds the
+	// item id, Text the encrypted content.
+	ReasoningEncrypted ReasoningKind = "encrypted"
+	// ReasoningThoughtSignature is a Gemini thought signature: ToolCallID
+	// holds the ID of the tool call it belongs to, Signature the signature.
+	ReasoningThoughtSignature ReasoningKind = "thought_signature"
+	// ReasoningThinking is Anthropic thinking: Text holds the thinking,
+	// Signature its signature. It is the only kind readable by the user.
+	ReasoningThinking ReasoningKind = "thinking"
+	// ReasoningRedacted is redacted Anthropic thinking, with the encrypted
+	// data in Text.
+	ReasoningRedacted ReasoningKind = "redacted_thinking"
+)
+
 // Block is a single piece of message content. Which fields are meaningful
 // depends on Type:
 //
 //   - BlockText:       Text
 //   - BlockToolUse:    ToolCallID, ToolName, Input
 //   - BlockToolResult: ToolCallID, Text, IsError
+//   - BlockReasoning:  Reasoning, and see ReasoningKind
 type Block struct {
-	Type       BlockType
-	Text       string
-	ToolCallID string
-	ToolName   string
-	Input      string
-	IsError    bool
+	Type       BlockType `json:"type"`
+	Text       string    `json:"text,omitempty"`
+	ToolCallID string    `json:"tool_call_id,omitempty"`
+	ToolName   string    `json:"tool_name,omitempty"`
+	Input      string    `json:"input,omitempty"`
+	IsError    bool      `json:"is_error,omitempty"`
+	Signature  string    `json:"signature,omitempty"`
+	// Reasoning is the kind of a BlockReasoning block. Redacted marks
+	// redacted thinking in sessions stored before the kind was recorded.
+	Reasoning ReasoningKind `json:"reasoning,omitempty"`
+	Redacted  bool          `json:"redacted,omitempty"`
+}
+
+// Kind returns the kind of a BlockReasoning block. Blocks stored before the
+// kind was recorded get it from the fields they have.
+func (b Block) Kind() ReasoningKind {
+	switch {
+	case b.Reasoning != "":
+		return b.Reasoning
+	case b.Redacted:
+		return ReasoningRedacted
+	case b.Signature != "" && b.ToolCallID != "":
+		return ReasoningThoughtSignature
+	case b.Signature != "":
+		return ReasoningThinking
+	default:
+		return ReasoningEncrypted
+	}
 }
 
 // Message is a single turn in a conversation.
 type Message struct {
-	Role   Role
-	Blocks []Block
+	Role   Role    `json:"role"`
+	Blocks []Block `json:"blocks"`
 }
 
 // TextBlock builds a plain text Block.
@@ -9,10 +9,14 @@ import (
 )
 
 type CLI struct {
-	Ask    cmd.Ask    `cmd:"" help:"Ask a question to a model."`
-	Do     cmd.Do     `cmd:"" help:"Run a command with custom prompts."`
-	Models cmd.Models `cmd:"" help:"Models available models"`
-	Acp    cmd.Acp    `cmd:"" help:"Start ACP (Agent Client Protocol) server."`
+	Ask      cmd.Ask      `cmd:"" help:"Ask a question to a model."`
+	Do       cmd.Do       `cmd:"" help:"Run a command with custom prompts."`
+	Run      cmd.Run      `cmd:"" help:"Start an interactive conversation."`
+	Resume   cmd.Resume   `cmd:"" help:"Continue a stored conversation."`
+	Sessions cmd.Sessions `cmd:"" help:"List and inspect stored conversations."`
+	Models   cmd.Models   `cmd:"" help:"Models available models"`
+	Acp      cmd.Acp      `cmd:"" help:"Start ACP (Agent Client Protocol) server."`
+	McpServe cmd.McpServe `cmd:"" help:"Offer the tools and templates as an MCP server over stdio."`
 }
 
 func main() {
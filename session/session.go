@@ -0,0 +1,117 @@
+// Package session persists conversations — the message transcript together
+// with the model and system prompt that produced it — so they can be listed,
+// inspected, resumed, or forked later, from the CLI or from an ACP client.
+package session
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/elek/rai/config"
+	"github.com/elek/rai/llm"
+	"github.com/google/uuid"
+)
+
+// titleLength bounds the length of a session title.
+const titleLength = 60
+
+// Session is a stored conversation.
+type Session struct {
+	ID string `json:"id"`
+	// Parent is the ID of the session this one was forked from, if any.
+	Parent   string        `json:"parent,omitempty"`
+	Created  time.Time     `json:"created"`
+	Updated  time.Time     `json:"updated"`
+	Model    config.Model  `json:"model"`
+	System   string        `json:"system,omitempty"`
+	Messages []llm.Message `json:"messages"`
+}
+
+// New creates an empty session for the given model and system prompt.
+func New(model config.Model, system string) *Session {
+	now := time.Now()
+	return &Session{
+		ID:      uuid.New().String(),
+		Created: now,
+		Updated: now,
+		Model:   model,
+		System:  system,
+	}
+}
+
+// Fork returns a copy of the session under a new ID, so the conversation can
+// branch off without changing the original.
+func (s *Session) Fork() *Session {
+	fork := New(s.Model, s.System)
+	fork.Parent = s.ID
+	fork.Messages = append([]llm.Message(nil), s.Messages...)
+	return fork
+}
+
+// Title returns the first line of the first user message, shortened to fit a
+// listing.
+func (s *Session) Title() string {
+	for _, m := range s.Messages {
+		if m.Role != llm.RoleUser {
+			continue
+		}
+		for _, b := range m.Blocks {
+			if b.Type != llm.BlockText {
+				continue
+			}
+			if title := strings.TrimSpace(b.Text); title != "" {
+				title, _, _ = strings.Cut(title, "\n")
+				if runes := []rune(title); len(runes) > titleLength {
+					title = string(runes[:titleLength]) + "…"
+				}
+				return title
+			}
+		}
+	}
+	return "(empty)"
+}
+
+// ShortID returns the first eight characters of the ID, which is enough to
+// address a session on the command line.
+func (s *Session) ShortID() string {
+	if len(s.ID) > 8 {
+		return s.ID[:8]
+	}
+	return s.ID
+}
+
+// WriteTranscript renders the session as human-readable text: a header with
+// the model and system prompt, then every message with its text, tool calls,
+// and tool results.
+func (s *Session) WriteTranscript(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Session %s (%s/%s)\n", s.ID, s.Model.Provider, s.Model.Model)
+	if s.Parent != "" {
+		fmt.Fprintf(&b, "Forked from %s\n", s.Parent)
+	}
+	fmt.Fprintf(&b, "Created %s, updated %s\n", s.Created.Format(time.DateTime), s.Updated.Format(time.DateTime))
+	if s.System != "" {
+		fmt.Fprintf(&b, "\n--- system ---\n%s\n", strings.TrimSpace(s.System))
+	}
+	for _, m := range s.Messages {
+		fmt.Fprintf(&b, "\n--- %s ---\n", m.Role)
+		for _, blk := range m.Blocks {
+			switch blk.Type {
+			case llm.BlockText:
+				fmt.Fprintf(&b, "%s\n", strings.TrimSpace(blk.Text))
+			case llm.BlockToolUse:
+				fmt.Fprintf(&b, "[tool call %s: %s]\n", blk.ToolName, blk.Input)
+			case llm.BlockToolResult:
+				marker := "tool result"
+				if blk.IsError {
+					marker = "tool error"
+				}
+				fmt.Fprintf(&b, "[%s]\n%s\n", marker, strings.TrimSpace(blk.Text))
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
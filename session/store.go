@@ -0,0 +1,183 @@
+package session
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elek/rai/config"
+	"github.com/elek/rai/llm"
+	"github.com/pkg/errors"
+)
+
+// Store keeps sessions as JSON files (one per session, named after its ID) in
+// a directory.
+type Store struct {
+	dir string
+}
+
+// NewStore creates a store backed by dir. The directory is created on the
+// first Save.
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// DefaultDir returns the directory where sessions are stored:
+// ~/.config/rai/sessions.
+func DefaultDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "rai", "sessions")
+}
+
+// Save writes the session, stamping its Updated time. The file is replaced
+// atomically, so a crash never leaves a half-written transcript behind.
+func (s *Store) Save(sess *Session) error {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return errors.WithStack(err)
+	}
+	sess.Updated = time.Now()
+	// Debug tracing is a property of one invocation, not of the conversation.
+	sess.Model.Debug = false
+
+	data, err := json.MarshalIndent(sess, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	tmp, err := os.CreateTemp(s.dir, sess.ID+".*.tmp")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return errors.WithStack(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(tmp.Name(), s.path(sess.ID)))
+}
+
+// Record stores a finished conversation as a new session. Its signature
+// matches llm.Recorder, so it can be handed to Executor.SetRecorder.
+func (s *Store) Record(model config.Model, system string, messages []llm.Message) error {
+	sess := New(model, system)
+	sess.Messages = messages
+	return s.Save(sess)
+}
+
+// Load reads a session by its ID. A unique prefix of the ID (such as the one
+// printed by ShortID) is accepted as well.
+func (s *Store) Load(id string) (*Session, error) {
+	if id == "" {
+		return nil, errors.New("session id is required")
+	}
+	data, err := os.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		full, err := s.resolvePrefix(id)
+		if err != nil {
+			return nil, err
+		}
+		data, err = os.ReadFile(s.path(full))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var sess Session
+	if err := json.Unmarshal(data, &sess); err != nil {
+		return nil, errors.Wrapf(err, "session %s is corrupt", id)
+	}
+	return &sess, nil
+}
+
+// Latest returns the most recently updated session.
+func (s *Store) Latest() (*Session, error) {
+	all, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	if len(all) == 0 {
+		return nil, errors.New("no stored sessions")
+	}
+	return all[0], nil
+}
+
+// List returns every stored session, most recently updated first. Files that
+// cannot be parsed are skipped.
+func (s *Store) List() ([]*Session, error) {
+	ids, err := s.ids()
+	if err != nil {
+		return nil, err
+	}
+	var out []*Session
+	for _, id := range ids {
+		sess, err := s.Load(id)
+		if err != nil {
+			continue
+		}
+		out = append(out, sess)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Updated.After(out[j].Updated)
+	})
+	return out, nil
+}
+
+// Delete removes a stored session.
+func (s *Store) Delete(id string) error {
+	return errors.WithStack(os.Remove(s.path(id)))
+}
+
+// ids returns the IDs of all stored sessions.
+func (s *Store) ids() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var ids []string
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(e.Name(), ".json"))
+	}
+	return ids, nil
+}
+
+// resolvePrefix expands an ID prefix to the one session ID it matches.
+func (s *Store) resolvePrefix(prefix string) (string, error) {
+	ids, err := s.ids()
+	if err != nil {
+		return "", err
+	}
+	var matches []string
+	for _, id := range ids {
+		if strings.HasPrefix(id, prefix) {
+			matches = append(matches, id)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", errors.Errorf("session %q not found", prefix)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", errors.Errorf("session id %q is ambiguous: %s", prefix, strings.Join(matches, ", "))
+	}
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, filepath.Base(id)+".json")
+}
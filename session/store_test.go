@@ -0,0 +1,105 @@
+package session
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elek/rai/config"
+	"github.com/elek/rai/llm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSession() *Session {
+	s := New(config.Model{Name: "claude", Provider: "anthropic", Model: "claude-haiku-4-5", Debug: true}, "be brief")
+	s.Messages = []llm.Message{
+		llm.UserMessage("list the files\nin this repo"),
+		{Role: llm.RoleAssistant, Blocks: []llm.Block{{Type: llm.BlockToolUse, ToolCallID: "t1", ToolName: "files", Input: `{"path":"."}`}}},
+		{Role: llm.RoleTool, Blocks: []llm.Block{{Type: llm.BlockToolResult, ToolCallID: "t1", Text: "go.mod"}}},
+		{Role: llm.RoleAssistant, Blocks: []llm.Block{llm.TextBlock("There is a go.mod.")}},
+	}
+	return s
+}
+
+func TestStoreSaveLoadRoundTrip(t *testing.T) {
+	store := NewStore(t.TempDir())
+	s := testSession()
+	require.NoError(t, store.Save(s))
+
+	loaded, err := store.Load(s.ID)
+	require.NoError(t, err)
+	assert.Equal(t, s.Messages, loaded.Messages)
+	assert.Equal(t, "be brief", loaded.System)
+	assert.Equal(t, "claude-haiku-4-5", loaded.Model.Model)
+	assert.False(t, loaded.Model.Debug, "debug must not be persisted")
+
+	// A unique prefix addresses the session too.
+	byPrefix, err := store.Load(s.ShortID())
+	require.NoError(t, err)
+	assert.Equal(t, s.ID, byPrefix.ID)
+}
+
+func TestStoreLoadUnknown(t *testing.T) {
+	_, err := NewStore(t.TempDir()).Load("nope")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not found")
+}
+
+func TestStoreListsMostRecentFirst(t *testing.T) {
+	store := NewStore(t.TempDir())
+	older, newer := testSession(), testSession()
+	require.NoError(t, store.Save(older))
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, store.Save(newer))
+
+	all, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	assert.Equal(t, newer.ID, all[0].ID)
+
+	latest, err := store.Latest()
+	require.NoError(t, err)
+	assert.Equal(t, newer.ID, latest.ID)
+
+	require.NoError(t, store.Delete(newer.ID))
+	all, err = store.List()
+	require.NoError(t, err)
+	assert.Len(t, all, 1)
+}
+
+func TestStoreListEmptyDir(t *testing.T) {
+	all, err := NewStore(t.TempDir() + "/missing").List()
+	require.NoError(t, err)
+	assert.Empty(t, all)
+}
+
+func TestForkCopiesConversation(t *testing.T) {
+	s := testSession()
+	fork := s.Fork()
+	assert.NotEqual(t, s.ID, fork.ID)
+	assert.Equal(t, s.ID, fork.Parent)
+	assert.Equal(t, s.Messages, fork.Messages)
+
+	fork.Messages = append(fork.Messages, llm.UserMessage("more"))
+	assert.Len(t, s.Messages, 4, "appending to the fork must not touch the original")
+}
+
+func TestTitleAndTranscript(t *testing.T) {
+	s := testSession()
+	assert.Equal(t, "list the files", s.Title())
+	assert.Equal(t, "(empty)", New(config.Model{}, "").Title())
+
+	long := New(config.Model{}, "")
+	long.Messages = []llm.Message{llm.UserMessage(strings.Repeat("é", 70))}
+	assert.Equal(t, strings.Repeat("é", 60)+"…", long.Title(), "titles are cut between characters")
+
+	var buf bytes.Buffer
+	require.NoError(t, s.WriteTranscript(&buf))
+	out := buf.String()
+	assert.Contains(t, out, "be brief")
+	assert.Contains(t, out, `[tool call files: {"path":"."}]`)
+	assert.Contains(t, out, "go.mod")
+	assert.Contains(t, out, "There is a go.mod.")
+}
@@ -3,7 +3,9 @@ package templates
 import (
 	"context"
 	"encoding/xml"
+	"fmt"
 	"io"
+	"os"
 	"strings"
 
 	"github.com/elek/rai/config"
@@ -44,6 +46,8 @@ func ParseTemplate(ctx context.Context, cfg config.Config, tmplStr string, data
 
 	var elementStack []xml.StartElement
 	var textStack []string
+	// mcpClients are the MCP servers of the <mcp> elements so far.
+	var mcpClients []*tool.McpClient
 	result := &ParsedTemplate{}
 
 	for {
@@ -83,19 +87,44 @@ func ParseTemplate(ctx context.Context, cfg config.Config, tmplStr string, data
 					}
 				}
 			case "mcp":
-				cmd := getAttr(scope.Attr, "command")
-				parts, err := shlex.Split(cmd)
+				server, err := mcpServer(cfg, scope.Attr)
+				if err != nil {
+					return nil, err
+				}
+				client, err := tool.ConnectMcp(ctx, server)
 				if err != nil {
 					return nil, errors.WithStack(err)
 				}
-				agentTools, closer, err := tool.NewMcpAgentTool(ctx, parts[0], parts[1:])
+				result.Closers = append(result.Closers, client.Close)
+				agentTools, err := client.Tools(ctx)
 				if err != nil {
 					return nil, errors.WithStack(err)
 				}
-				result.Closers = append(result.Closers, closer)
+				for _, agentTool := range agentTools {
+					name := agentTool.Info().Name
+					for _, tl := range allTools {
+						if tl.Info().Name == name {
+							result.Close()
+							return nil, errors.Errorf("the MCP tool %s has the name of a built-in tool, set an alias on the <mcp> element", name)
+						}
+					}
+				}
+				mcpClients = append(mcpClients, client)
 				result.Tools = append(result.Tools, agentTools...)
+			case "mcp-resource":
+				text, err := readMcpResource(ctx, cfg, scope.Attr, mcpClients)
+				if err != nil {
+					return nil, err
+				}
+				textStack[len(textStack)-2] = textStack[len(textStack)-2] + text
 			case "lsp":
 				cmd := getAttr(scope.Attr, "command")
+				if cmd == "" {
+					agentTools, closer := tool.NewLSPRouterTools(".", cfg.LSP)
+					result.Closers = append(result.Closers, closer)
+					result.Tools = append(result.Tools, agentTools...)
+					break
+				}
 				agentTools, closer, err := tool.NewLSPAgentTool(ctx, cmd, ".")
 				if err != nil {
 					return nil, errors.WithStack(err)
@@ -129,9 +158,196 @@ func ParseTemplate(ctx context.Context, cfg config.Config, tmplStr string, data
 		}
 	}
 
+	seen := map[string]bool{}
+	for _, tl := range result.Tools {
+		name := tl.Info().Name
+		if seen[name] {
+			result.Close()
+			return nil, errors.Errorf("the tool %s is defined more than once, set an alias on the <mcp> elements", name)
+		}
+		seen[name] = true
+	}
+	return result, nil
+}
+
+// mcpServer returns the MCP server of an <mcp> element: the server of the
+// config called name, or the one given by command or url. Headers of the
+// element are added to the ones of the config, and its alias, allow and deny
+// replace them.
+func mcpServer(cfg config.Config, attr []xml.Attr) (tool.McpServer, error) {
+	server := config.MCPServer{
+		Command:     getAttr(attr, "command"),
+		URL:         getAttr(attr, "url"),
+		Transport:   getAttr(attr, "transport"),
+		BearerToken: getAttr(attr, "token"),
+	}
+	if name := getAttr(attr, "name"); name != "" {
+		found, ok := cfg.FindMCPServer(name)
+		if !ok {
+			return tool.McpServer{}, errors.New("MCP server couldn't be found: " + name)
+		}
+		server = found
+	}
+	headers := map[string]string{}
+	for name, value := range server.Headers {
+		headers[name] = value
+	}
+	for _, header := range strings.FieldsFunc(getAttr(attr, "headers"), func(r rune) bool { return r == ';' || r == '\n' }) {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			return tool.McpServer{}, errors.Errorf("invalid MCP header %q, use Name: value", strings.TrimSpace(header))
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	server.Headers = headers
+	if alias := getAttr(attr, "alias"); alias != "" {
+		server.Alias = alias
+	}
+	if allow := globs(getAttr(attr, "allow")); len(allow) > 0 {
+		server.Allow = allow
+	}
+	if deny := globs(getAttr(attr, "deny")); len(deny) > 0 {
+		server.Deny = deny
+	}
+	return toolMcpServer(server)
+}
+
+// globs splits a comma or space separated list of globs.
+func globs(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' })
+}
+
+// readMcpResource returns the text of the resource of an <mcp-resource>
+// element. The resource is read from the server given by the element (with
+// the attributes of <mcp>), or else from the first <mcp> server before it that
+// has the resource.
+func readMcpResource(ctx context.Context, cfg config.Config, attr []xml.Attr, clients []*tool.McpClient) (string, error) {
+	uri := getAttr(attr, "uri")
+	if uri == "" {
+		return "", errors.New("<mcp-resource> needs a uri")
+	}
+	if getAttr(attr, "name") != "" || getAttr(attr, "url") != "" || getAttr(attr, "command") != "" {
+		server, err := mcpServer(cfg, attr)
+		if err != nil {
+			return "", err
+		}
+		client, err := tool.ConnectMcp(ctx, server)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		defer client.Close()
+		return client.ReadResource(ctx, uri)
+	}
+	if len(clients) == 0 {
+		return "", errors.Errorf("no MCP server to read %s from, add an <mcp> element before <mcp-resource>", uri)
+	}
+	var err error
+	for _, client := range clients {
+		var text string
+		if text, err = client.ReadResource(ctx, uri); err == nil {
+			return text, nil
+		}
+	}
+	return "", err
+}
+
+// ParseMcpPrompt turns the prompt of an MCP server of the config into a
+// template: the text of the prompt, with the tools of the server. The name is
+// "server/prompt"; args are the arguments of the prompt, as "name=value" or
+// in order.
+func ParseMcpPrompt(ctx context.Context, cfg config.Config, name string, args []string) (*ParsedTemplate, error) {
+	serverName, promptName, ok := strings.Cut(name, "/")
+	found, exists := cfg.FindMCPServer(serverName)
+	if !ok || !exists {
+		return nil, errors.Errorf("%s is not a prompt of an MCP server of the config", name)
+	}
+	server, err := toolMcpServer(found)
+	if err != nil {
+		return nil, err
+	}
+	client, err := tool.ConnectMcp(ctx, server)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	result := &ParsedTemplate{Closers: []func(){client.Close}}
+	result.Prompt, err = client.Prompt(ctx, promptName, args)
+	if err == nil {
+		result.Tools, err = client.Tools(ctx)
+	}
+	if err != nil {
+		result.Close()
+		return nil, err
+	}
 	return result, nil
 }
 
+// McpPrompts lists the prompts of the MCP servers of the config as
+// "server/prompt" names, with their descriptions. Servers that can't be
+// reached are reported in the descriptions.
+func McpPrompts(ctx context.Context, cfg config.Config) map[string]string {
+	prompts := map[string]string{}
+	for _, s := range cfg.MCP {
+		server, err := toolMcpServer(s)
+		if err != nil {
+			prompts[s.Name+"/"] = err.Error()
+			continue
+		}
+		client, err := tool.ConnectMcp(ctx, server)
+		if err != nil {
+			prompts[s.Name+"/"] = err.Error()
+			continue
+		}
+		list, err := client.Prompts(ctx)
+		client.Close()
+		if err != nil {
+			prompts[s.Name+"/"] = err.Error()
+			continue
+		}
+		for _, p := range list {
+			description := p.Description
+			if len(p.Arguments) > 0 {
+				description = strings.TrimSpace(fmt.Sprintf("%s (arguments: %s)", description, strings.Join(p.Arguments, ", ")))
+			}
+			prompts[s.Name+"/"+p.Name] = description
+		}
+	}
+	return prompts
+}
+
+// toolMcpServer converts an MCP server of the config for the tool package,
+// expanding the environment variables of its headers and token. The tools of
+// a server of the config are prefixed with its name unless it has an alias.
+func toolMcpServer(s config.MCPServer) (tool.McpServer, error) {
+	server := tool.McpServer{
+		URL:       s.URL,
+		Transport: s.Transport,
+		Headers:   map[string]string{},
+		Alias:     s.Alias,
+		Allow:     s.Allow,
+		Deny:      s.Deny,
+	}
+	if server.Alias == "" {
+		server.Alias = s.Name
+	}
+	if s.URL == "" {
+		parts, err := shlex.Split(s.Command)
+		if err != nil {
+			return tool.McpServer{}, errors.WithStack(err)
+		}
+		if len(parts) == 0 {
+			return tool.McpServer{}, errors.New("the MCP server needs a command or a url")
+		}
+		server.Command, server.Args = parts[0], parts[1:]
+	}
+	for name, value := range s.Headers {
+		server.Headers[name] = os.ExpandEnv(value)
+	}
+	if s.BearerToken != "" {
+		server.Headers["Authorization"] = "Bearer " + os.ExpandEnv(s.BearerToken)
+	}
+	return server, nil
+}
+
 func getAttr(attr []xml.Attr, s string) string {
 	for _, a := range attr {
 		if a.Name.Local == s {
@@ -0,0 +1,158 @@
+package templates
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/elek/rai/config"
+	"github.com/elek/rai/tool"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMcpServerElement(t *testing.T) {
+	t.Setenv("MCP_TOKEN", "secret")
+	cfg := config.Config{MCP: []config.MCPServer{{
+		Name:        "internal",
+		URL:         "https://mcp.example.com/mcp",
+		Transport:   "sse",
+		Headers:     map[string]string{"X-Team": "core"},
+		BearerToken: "${MCP_TOKEN}",
+	}}}
+	attrs := func(kv ...string) []xml.Attr {
+		var res []xml.Attr
+		for i := 0; i < len(kv); i += 2 {
+			res = append(res, xml.Attr{Name: xml.Name{Local: kv[i]}, Value: kv[i+1]})
+		}
+		return res
+	}
+
+	server, err := mcpServer(cfg, attrs("name", "internal", "headers", "X-Trace: 1"))
+	require.NoError(t, err)
+	require.Equal(t, tool.McpServer{
+		URL:       "https://mcp.example.com/mcp",
+		Transport: "sse",
+		Headers:   map[string]string{"X-Team": "core", "X-Trace": "1", "Authorization": "Bearer secret"},
+		Alias:     "internal",
+	}, server)
+
+	server, err = mcpServer(cfg, attrs("name", "internal", "alias", "in", "allow", "get_*, list_*", "deny", "*_secret"))
+	require.NoError(t, err)
+	require.Equal(t, "in", server.Alias)
+	require.Equal(t, []string{"get_*", "list_*"}, server.Allow)
+	require.Equal(t, []string{"*_secret"}, server.Deny)
+
+	server, err = mcpServer(cfg, attrs("url", "http://localhost:8080/mcp", "token", "$MCP_TOKEN", "headers", "X-A: a; X-B: b:c"))
+	require.NoError(t, err)
+	require.Equal(t, tool.McpServer{
+		URL:     "http://localhost:8080/mcp",
+		Headers: map[string]string{"X-A": "a", "X-B": "b:c", "Authorization": "Bearer secret"},
+	}, server)
+
+	server, err = mcpServer(cfg, attrs("command", "yvp 'std io'"))
+	require.NoError(t, err)
+	require.Equal(t, tool.McpServer{Command: "yvp", Args: []string{"std io"}, Headers: map[string]string{}}, server)
+
+	_, err = mcpServer(cfg, attrs("name", "missing"))
+	require.ErrorContains(t, err, "MCP server couldn't be found: missing")
+
+	_, err = mcpServer(cfg, attrs("url", "http://localhost", "headers", "broken"))
+	require.ErrorContains(t, err, `invalid MCP header "broken"`)
+
+	_, err = mcpServer(cfg, nil)
+	require.ErrorContains(t, err, "needs a command or a url")
+}
+
+// newDocsMcpServer returns the URL of an MCP server with a resource and a
+// prompt.
+func newDocsMcpServer(t *testing.T) string {
+	server := mcp.NewServer(&mcp.Implementation{Name: "docs", Version: "v1.0.0"}, nil)
+	server.AddResource(&mcp.Resource{URI: "docs://guide", Name: "guide"},
+		func(ctx context.Context, req *mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+			return &mcp.ReadResourceResult{Contents: []*mcp.ResourceContents{{URI: req.Params.URI, Text: "Use <tabs>."}}}, nil
+		})
+	server.AddPrompt(&mcp.Prompt{Name: "summarize", Arguments: []*mcp.PromptArgument{{Name: "file", Required: true}}},
+		func(ctx context.Context, req *mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+			return &mcp.GetPromptResult{Messages: []*mcp.PromptMessage{
+				{Role: "user", Content: &mcp.TextContent{Text: "Summarize {{" + req.Params.Arguments["file"] + "}}"}},
+			}}, nil
+		})
+	ts := httptest.NewServer(mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return server }, nil))
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func TestMcpResourceElement(t *testing.T) {
+	url := newDocsMcpServer(t)
+	parsed, err := ParseTemplate(context.Background(), config.Config{}, `<mcp url="`+url+`"/>Follow this guide: <mcp-resource uri="docs://guide"/>`, nil)
+	require.NoError(t, err)
+	defer parsed.Close()
+	require.Equal(t, "Follow this guide: Use <tabs>.", parsed.Prompt)
+	var names []string
+	for _, tl := range parsed.Tools {
+		names = append(names, tl.Info().Name)
+	}
+	require.Equal(t, []string{"list-resources", "read-resource"}, names)
+
+	cfg := config.Config{MCP: []config.MCPServer{{Name: "docs", URL: url}}}
+	parsed, err = ParseTemplate(context.Background(), cfg, `<mcp-resource name="docs" uri="docs://guide"/>`, nil)
+	require.NoError(t, err)
+	require.Equal(t, "Use <tabs>.", parsed.Prompt)
+	require.Empty(t, parsed.Tools)
+
+	_, err = ParseTemplate(context.Background(), cfg, `<mcp-resource uri="docs://guide"/>`, nil)
+	require.ErrorContains(t, err, "add an <mcp> element")
+}
+
+func TestMcpToolNames(t *testing.T) {
+	url := newDocsMcpServer(t)
+	cfg := config.Config{MCP: []config.MCPServer{{Name: "docs", URL: url}}}
+	toolNames := func(template string) ([]string, error) {
+		parsed, err := ParseTemplate(context.Background(), cfg, template, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer parsed.Close()
+		var names []string
+		for _, tl := range parsed.Tools {
+			names = append(names, tl.Info().Name)
+		}
+		return names, nil
+	}
+
+	names, err := toolNames(`<mcp name="docs"/><mcp url="` + url + `" alias="guide" deny="list-*"/>`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"docs_list-resources", "docs_read-resource", "guide_read-resource"}, names)
+
+	_, err = toolNames(`<mcp url="` + url + `"/><mcp url="` + url + `"/>`)
+	require.ErrorContains(t, err, "the tool list-resources is defined more than once")
+
+	server := mcp.NewServer(&mcp.Implementation{Name: "files", Version: "v1.0.0"}, nil)
+	mcp.AddTool(server, &mcp.Tool{Name: "cat"}, func(ctx context.Context, req *mcp.CallToolRequest, input struct{}) (*mcp.CallToolResult, any, error) {
+		return &mcp.CallToolResult{}, nil, nil
+	})
+	ts := httptest.NewServer(mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return server }, nil))
+	t.Cleanup(ts.Close)
+	_, err = toolNames(`<mcp url="` + ts.URL + `"/>`)
+	require.ErrorContains(t, err, "the MCP tool cat has the name of a built-in tool")
+	names, err = toolNames(`<mcp url="` + ts.URL + `" alias="files"/>`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"files_cat"}, names)
+}
+
+func TestParseMcpPrompt(t *testing.T) {
+	cfg := config.Config{MCP: []config.MCPServer{{Name: "docs", URL: newDocsMcpServer(t)}}}
+	parsed, err := ParseMcpPrompt(context.Background(), cfg, "docs/summarize", []string{"main.go"})
+	require.NoError(t, err)
+	defer parsed.Close()
+	require.Equal(t, "Summarize {{main.go}}", parsed.Prompt, "the prompt is not rendered as a template")
+	require.Len(t, parsed.Tools, 2)
+
+	_, err = ParseMcpPrompt(context.Background(), cfg, "other/summarize", nil)
+	require.ErrorContains(t, err, "not a prompt of an MCP server")
+
+	require.Equal(t, map[string]string{"docs/summarize": "(arguments: file)"}, McpPrompts(context.Background(), cfg))
+}
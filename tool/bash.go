@@ -1,18 +1,17 @@
 package tool
 
 import (
-	"os/exec"
+	"context"
+	"time"
 )
 
 type BashInput struct {
 	Command string `json:"command" description:"The bash command to execute"`
+	Timeout int    `json:"timeout" description:"Optional timeout in seconds (default 120, at most 600); the command and its children are killed when it passes"`
 }
 
-func Bash(input BashInput) string {
-	cmd := exec.Command("/bin/sh", "-c", input.Command)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return "Error: " + err.Error() + "\n" + string(out)
-	}
-	return string(out)
+// Bash runs the command with /bin/sh, in the workspace of ctx when there is
+// one, and in its sandbox when that is enabled.
+func Bash(ctx context.Context, input BashInput) string {
+	return runCommand(ctx, input.Command, time.Duration(input.Timeout)*time.Second, true).String()
 }
@@ -0,0 +1,150 @@
+package tool
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultCommandTimeout applies when a call doesn't set a timeout, and
+	// maxCommandTimeout bounds the ones that do.
+	defaultCommandTimeout = 2 * time.Minute
+	maxCommandTimeout     = 10 * time.Minute
+	// maxCommandOutput is the number of bytes of output kept: the first and
+	// the last half of it.
+	maxCommandOutput = 32 << 10
+	// commandWaitDelay is how long to wait for the output after the command
+	// was killed, in case a process outside its group still holds the pipes.
+	commandWaitDelay = 5 * time.Second
+)
+
+// CommandResult is the outcome of a shell command.
+type CommandResult struct {
+	// Output is the combined stdout and stderr, without the middle if it was
+	// too long.
+	Output string
+	// Truncated is the number of bytes left out of the middle of Output.
+	Truncated int
+	// ExitCode is the exit code of the command, or -1 if it didn't exit by
+	// itself.
+	ExitCode int
+	// TimedOut is set when the command was killed after its timeout.
+	TimedOut bool
+	// Canceled is set when the command was killed as the call was canceled.
+	Canceled bool
+	// Err is set when the command couldn't be started.
+	Err error
+}
+
+// String returns the output of the command, followed by its exit code unless
+// it succeeded.
+func (r CommandResult) String() string {
+	if r.Err != nil {
+		return "Error: " + r.Err.Error() + "\n" + r.Output
+	}
+	var status string
+	switch {
+	case r.TimedOut:
+		status = "the command timed out and was killed"
+	case r.Canceled:
+		status = "the command was canceled and killed"
+	case r.ExitCode != 0:
+		status = fmt.Sprintf("exit code: %d", r.ExitCode)
+	default:
+		return r.Output
+	}
+	out := r.Output
+	if out != "" && !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	return out + "[" + status + "]"
+}
+
+// runCommand runs the shell command in the workspace of ctx, and in its
+// sandbox when that is enabled and sandbox is set. The command and everything
+// it started are killed when ctx is done or timeout (or the default, if it is
+// zero) passes.
+func runCommand(ctx context.Context, command string, timeout time.Duration, sandbox bool) CommandResult {
+	return runArgs(ctx, []string{"/bin/sh", "-c", command}, timeout, sandbox)
+}
+
+// runArgs runs the program args[0] with the arguments args[1:], without a
+// shell, like runCommand.
+func runArgs(ctx context.Context, args []string, timeout time.Duration, sandbox bool) CommandResult {
+	if timeout <= 0 {
+		timeout = defaultCommandTimeout
+	}
+	timeout = min(timeout, maxCommandTimeout)
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ws := WorkspaceFrom(ctx)
+	cmd := exec.CommandContext(runCtx, args[0], args[1:]...)
+	cmd.Dir = ws.Dir()
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
+	cmd.WaitDelay = commandWaitDelay
+	out := &cappedOutput{limit: maxCommandOutput}
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	var err error
+	if sandbox && ws != nil && ws.Sandbox {
+		err = startSandboxed(cmd, ws.Root)
+	} else {
+		err = cmd.Start()
+	}
+	if err != nil {
+		return CommandResult{Err: err, ExitCode: -1}
+	}
+	err = cmd.Wait()
+
+	res := CommandResult{Output: out.String(), Truncated: out.dropped, ExitCode: cmd.ProcessState.ExitCode()}
+	switch {
+	case ctx.Err() != nil:
+		res.Canceled = true
+	case runCtx.Err() != nil:
+		res.TimedOut = true
+	case err != nil && !errors.As(err, new(*exec.ExitError)):
+		res.Err = err
+	}
+	return res
+}
+
+// cappedOutput keeps the first and the last limit/2 bytes written to it.
+type cappedOutput struct {
+	limit   int
+	head    []byte
+	tail    []byte
+	dropped int
+}
+
+func (c *cappedOutput) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := c.limit/2 - len(c.head); room > 0 {
+		k := min(room, len(p))
+		c.head = append(c.head, p[:k]...)
+		p = p[k:]
+	}
+	c.tail = append(c.tail, p...)
+	if over := len(c.tail) - c.limit/2; over > 0 {
+		c.dropped += over
+		c.tail = append(c.tail[:0], c.tail[over:]...)
+	}
+	return n, nil
+}
+
+// String returns the output, with a note in place of the bytes left out.
+func (c *cappedOutput) String() string {
+	if c.dropped == 0 {
+		return string(c.head) + string(c.tail)
+	}
+	return fmt.Sprintf("%s\n[... %d bytes of output omitted ...]\n%s", c.head, c.dropped, c.tail)
+}
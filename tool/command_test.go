@@ -0,0 +1,95 @@
+package tool
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCommandReportsExitCode(t *testing.T) {
+	res := runCommand(context.Background(), "echo out; echo err >&2; exit 3", 0, false)
+	assert.Equal(t, 3, res.ExitCode)
+	assert.Equal(t, "out\nerr\n", res.Output)
+	assert.Equal(t, "out\nerr\n[exit code: 3]", res.String())
+
+	res = runCommand(context.Background(), "echo ok", 0, false)
+	assert.Equal(t, 0, res.ExitCode)
+	assert.Equal(t, "ok\n", res.String())
+}
+
+func TestRunCommandTimesOutAndKillsChildren(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "marker")
+
+	start := time.Now()
+	// The background child would write the marker after the timeout unless
+	// it is killed with the shell.
+	res := runCommand(context.Background(), "(sleep 2; touch "+marker+") & sleep 30", 200*time.Millisecond, false)
+	assert.Less(t, time.Since(start), 10*time.Second)
+	assert.True(t, res.TimedOut)
+	assert.Equal(t, -1, res.ExitCode)
+	assert.Contains(t, res.String(), "[the command timed out and was killed]")
+
+	time.Sleep(2500 * time.Millisecond)
+	assert.NoFileExists(t, marker)
+}
+
+func TestRunCommandIsCanceledWithTheContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	res := runCommand(ctx, "sleep 30", 0, false)
+	assert.True(t, res.Canceled)
+	assert.False(t, res.TimedOut)
+	assert.Contains(t, res.String(), "[the command was canceled and killed]")
+}
+
+func TestRunCommandCapsOutput(t *testing.T) {
+	res := runCommand(context.Background(), "seq 1 100000", 0, false)
+	require.Greater(t, res.Truncated, 0)
+	assert.True(t, strings.HasPrefix(res.Output, "1\n2\n3\n"))
+	assert.True(t, strings.HasSuffix(res.Output, "99999\n100000\n"))
+	assert.Contains(t, res.Output, "bytes of output omitted")
+	assert.Less(t, len(res.Output), maxCommandOutput+100)
+}
+
+func TestRunCommandRunsInTheWorkspace(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "x"), nil, 0o644))
+	ws, err := NewWorkspace(root, false)
+	require.NoError(t, err)
+
+	out := Git(ContextWithWorkspace(context.Background(), ws), GitInput{Command: "git --version"})
+	assert.Contains(t, out, "git version")
+	out = Bash(ContextWithWorkspace(context.Background(), ws), BashInput{Command: "ls"})
+	assert.Equal(t, "x\n", out)
+}
+
+func TestGitRunsWithoutAShell(t *testing.T) {
+	root := t.TempDir()
+	ws, err := NewWorkspace(root, true)
+	require.NoError(t, err)
+	ctx := ContextWithWorkspace(context.Background(), ws)
+	marker := filepath.Join(root, "marker")
+
+	out := Git(ctx, GitInput{Command: "--version; touch " + marker})
+	assert.NotContains(t, out, "git version", "the whole line is passed to git")
+	assert.NoFileExists(t, marker)
+
+	Git(ctx, GitInput{Command: "git --version && touch " + marker})
+	assert.NoFileExists(t, marker)
+
+	out = Git(ctx, GitInput{Command: `git -c "user.name=A B" --version`})
+	assert.Contains(t, out, "git version")
+
+	out = Git(ctx, GitInput{Command: `git log "unbalanced`})
+	assert.Contains(t, out, "Error:")
+}
@@ -19,5 +19,6 @@ func Create(input CreateInput) (string, error) {
 	if err != nil {
 		return "", errors.Wrap(err, "error writing file")
 	}
+	fileChanged(input.Path)
 	return "File created successfully at " + input.Path, nil
 }
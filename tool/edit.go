@@ -0,0 +1,60 @@
+package tool
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type EditInput struct {
+	Path       string `json:"path" description:"The path of the file to edit"`
+	OldStr     string `json:"old_str" description:"The exact text to replace, including whitespace and indentation. It must occur exactly once in the file unless replace_all is set; include surrounding lines to make it unique."`
+	NewStr     string `json:"new_str" description:"The text to replace old_str with (empty to delete it)"`
+	ReplaceAll bool   `json:"replace_all" description:"Replace every occurrence of old_str instead of requiring exactly one"`
+}
+
+// Edit replaces old_str with new_str in a file and returns a unified diff of
+// the change.
+func Edit(input EditInput) (string, error) {
+	if input.Path == "" {
+		return "", errors.New("path is required")
+	}
+	if input.OldStr == "" {
+		return "", errors.New("old_str is required; use create to write a new file")
+	}
+	if input.OldStr == input.NewStr {
+		return "", errors.New("old_str and new_str are the same")
+	}
+	stat, err := os.Stat(input.Path)
+	if err != nil {
+		return "", errors.Wrap(err, "error stating file")
+	}
+	current, err := os.ReadFile(input.Path)
+	if err != nil {
+		return "", errors.Wrap(err, "error reading file")
+	}
+	content := string(current)
+
+	count := strings.Count(content, input.OldStr)
+	switch {
+	case count == 0:
+		return "", errors.Errorf("old_str was not found in %s; it must match the file content exactly, including whitespace", input.Path)
+	case count > 1 && !input.ReplaceAll:
+		return "", errors.Errorf("old_str occurs %d times in %s; include more surrounding lines to make it unique, or set replace_all", count, input.Path)
+	}
+
+	updated := strings.Replace(content, input.OldStr, input.NewStr, -1)
+	err = os.WriteFile(input.Path, []byte(updated), stat.Mode())
+	if err != nil {
+		return "", errors.Wrap(err, "error writing file")
+	}
+	fileChanged(input.Path)
+
+	replacements := "1 replacement"
+	if count > 1 {
+		replacements = fmt.Sprintf("%d replacements", count)
+	}
+	return fmt.Sprintf("File updated successfully at %s (%s):\n%s", input.Path, replacements, unifiedDiff(input.Path, content, updated)), nil
+}
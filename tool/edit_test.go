@@ -0,0 +1,86 @@
+package tool
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "main.go")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestEditReplacesUniqueString(t *testing.T) {
+	path := writeTestFile(t, "package main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n")
+
+	out, err := Edit(EditInput{Path: path, OldStr: `println("hi")`, NewStr: "println(\"hello\")\n\tprintln(\"world\")"})
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "package main\n\nfunc main() {\n\tprintln(\"hello\")\n\tprintln(\"world\")\n}\n", string(content))
+	assert.Equal(t, "File updated successfully at "+path+" (1 replacement):\n"+
+		"--- "+path+"\n+++ "+path+"\n"+
+		"@@ -1,5 +1,6 @@\n"+
+		" package main\n \n func main() {\n-\tprintln(\"hi\")\n+\tprintln(\"hello\")\n+\tprintln(\"world\")\n }\n", out)
+}
+
+func TestEditRejectsMissingAndAmbiguousStrings(t *testing.T) {
+	path := writeTestFile(t, "a := 1\nb := 1\n")
+
+	_, err := Edit(EditInput{Path: path, OldStr: "c := 1", NewStr: "c := 2"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not found")
+
+	_, err = Edit(EditInput{Path: path, OldStr: " := 1", NewStr: " := 2"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "occurs 2 times")
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "a := 1\nb := 1\n", string(content), "a failed edit leaves the file alone")
+}
+
+func TestEditReplaceAll(t *testing.T) {
+	path := writeTestFile(t, "a := 1\nb := 1\n")
+
+	out, err := Edit(EditInput{Path: path, OldStr: " := 1", NewStr: " := 2", ReplaceAll: true})
+	require.NoError(t, err)
+	assert.Contains(t, out, "(2 replacements)")
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "a := 2\nb := 2\n", string(content))
+}
+
+func TestUnifiedDiffSplitsDistantChanges(t *testing.T) {
+	var lines []string
+	for i := 1; i <= 20; i++ {
+		lines = append(lines, strings.Repeat("x", i))
+	}
+	old := strings.Join(lines, "\n") + "\n"
+	lines[1] = "second"
+	lines[18] = "nineteenth"
+	lines = append(lines[:10], lines[11:]...)
+	updated := strings.Join(lines, "\n") + "\n"
+
+	diff := unifiedDiff("f", old, updated)
+	assert.Equal(t, []string{"@@ -1,5 +1,5 @@", "@@ -8,7 +8,6 @@", "@@ -16,5 +15,5 @@"}, hunkHeaders(diff))
+}
+
+func hunkHeaders(diff string) []string {
+	var headers []string
+	for _, line := range strings.Split(diff, "\n") {
+		if strings.HasPrefix(line, "@@") {
+			headers = append(headers, line)
+		}
+	}
+	return headers
+}
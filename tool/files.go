@@ -2,17 +2,28 @@ package tool
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
+// defaultFileLimit is the number of files listed when the input doesn't say
+// otherwise; the rest is only counted.
+const defaultFileLimit = 200
+
 // FileListInput defines the input parameters for the Files tool
 type FileListInput struct {
 	Path      string `json:"path" description:"The directory path to list files from"`
 	Recursive bool   `json:"recursive" description:"Whether to list files recursively (including subdirectories)"`
-	Pattern   string `json:"pattern" description:"Optional glob pattern to filter files (e.g., *.go, *.md)"`
+	Pattern   string `json:"pattern" description:"Optional glob pattern to filter files, matched against the file name (*.go) or, if it has a slash, the path relative to the directory (**/*.go, cmd/*/main.go)"`
+	Depth     int    `json:"depth" description:"Optional maximum directory depth of a recursive listing (1 lists only the directory itself); 0 means unlimited"`
+	Tree      bool   `json:"tree" description:"Whether to show the files as an indented tree of directories instead of a flat list"`
+	Limit     int    `json:"limit" description:"Optional maximum number of files to list (default 200); the rest is only counted"`
 }
 
+// ListFiles lists the files of a directory, skipping the files ignored by
+// .gitignore and .raiignore files, and the .git directory.
 func ListFiles(input FileListInput) string {
 	if input.Path == "" {
 		return "Error: Path is required"
@@ -28,69 +39,54 @@ func ListFiles(input FileListInput) string {
 		return fmt.Sprintf("Error: %s is not a directory", input.Path)
 	}
 
-	var files []string
-	var walkErr error
-
-	// List files based on recursive flag
-	if input.Recursive {
-		// Walk through all subdirectories
-		walkErr = filepath.Walk(input.Path, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-
-			// Skip directories themselves unless it's the root
-			if info.IsDir() && path != input.Path {
-				return nil
-			}
-
-			// Check pattern if provided
-			if input.Pattern != "" {
-				match, err := filepath.Match(input.Pattern, filepath.Base(path))
-				if err != nil {
-					return err
-				}
-				if !match {
-					return nil
-				}
-			}
+	depth := input.Depth
+	if !input.Recursive {
+		depth = 1
+	}
+	limit := input.Limit
+	if limit <= 0 {
+		limit = defaultFileLimit
+	}
 
-			// Add the file to our list
-			if !info.IsDir() {
-				files = append(files, path)
-			}
+	root, err := filepath.Abs(input.Path)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err)
+	}
+	ig := newIgnorer(root)
 
-			return nil
-		})
-	} else {
-		// Non-recursive listing
-		entries, err := os.ReadDir(input.Path)
+	// files holds the slash separated paths relative to root.
+	var files []string
+	total := 0
+	walkErr := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
-			return fmt.Sprintf("Error reading directory: %v", err)
+			return err
 		}
-
-		for _, entry := range entries {
-			// Skip directories if we're not including them
-			if entry.IsDir() {
-				continue
+		if path == root {
+			ig.visit(path, d)
+			return nil
+		}
+		rel := filepath.ToSlash(strings.TrimPrefix(path, root+string(filepath.Separator)))
+		if ig.visit(path, d) {
+			if d.IsDir() {
+				return filepath.SkipDir
 			}
-
-			filename := entry.Name()
-
-			// Check pattern if provided
-			if input.Pattern != "" {
-				match, err := filepath.Match(input.Pattern, filename)
-				if err != nil {
-					return fmt.Sprintf("Error with pattern matching: %v", err)
-				}
-				if !match {
-					continue
-				}
+			return nil
+		}
+		if d.IsDir() {
+			if depth > 0 && strings.Count(rel, "/")+1 >= depth {
+				return filepath.SkipDir
 			}
-
-			files = append(files, filepath.Join(input.Path, filename))
+			return nil
 		}
-	}
+		if input.Pattern != "" && !matchGlob(input.Pattern, rel) {
+			return nil
+		}
+		total++
+		if len(files) < limit {
+			files = append(files, rel)
+		}
+		return nil
+	})
 
 	if walkErr != nil {
 		return fmt.Sprintf("Error walking directory: %v", walkErr)
@@ -101,10 +97,37 @@ func ListFiles(input FileListInput) string {
 		return "No files found matching the criteria"
 	}
 
-	result := fmt.Sprintf("Found %d files:\n", len(files))
-	for _, file := range files {
-		result += fmt.Sprintf("- %s\n", file)
+	var b strings.Builder
+	fmt.Fprintf(&b, "Found %d files:\n", total)
+	if input.Tree {
+		writeFileTree(&b, input.Path, files)
+	} else {
+		for _, file := range files {
+			fmt.Fprintf(&b, "- %s\n", filepath.Join(input.Path, filepath.FromSlash(file)))
+		}
 	}
+	if total > len(files) {
+		fmt.Fprintf(&b, "... and %d more files (narrow the listing with path, pattern or depth)\n", total-len(files))
+	}
+	return b.String()
+}
 
-	return result
+// writeFileTree writes the sorted relative paths of files below dir as a tree,
+// indenting the contents of each directory by two spaces.
+func writeFileTree(b *strings.Builder, dir string, files []string) {
+	fmt.Fprintf(b, "%s/\n", strings.TrimSuffix(dir, string(filepath.Separator)))
+	var open []string
+	for _, file := range files {
+		parts := strings.Split(file, "/")
+		dirs := parts[:len(parts)-1]
+		common := 0
+		for common < len(open) && common < len(dirs) && open[common] == dirs[common] {
+			common++
+		}
+		for i := common; i < len(dirs); i++ {
+			fmt.Fprintf(b, "%s%s/\n", strings.Repeat("  ", i+1), dirs[i])
+		}
+		open = dirs
+		fmt.Fprintf(b, "%s%s\n", strings.Repeat("  ", len(dirs)+1), parts[len(parts)-1])
+	}
 }
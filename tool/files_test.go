@@ -0,0 +1,88 @@
+package tool
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFileTree creates the given files below a new directory and returns it.
+// A file given as name=line holds that line, the others are empty.
+func newFileTree(t *testing.T, files ...string) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, file := range files {
+		name, content, ok := strings.Cut(file, "=")
+		if ok {
+			content += "\n"
+		}
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	return root
+}
+
+func TestListFilesSkipsIgnoredFiles(t *testing.T) {
+	root := newFileTree(t,
+		".gitignore=node_modules/",
+		".raiignore=vendor/",
+		".git/HEAD",
+		"go.mod",
+		"cmd/main.go",
+		"node_modules/x/index.js",
+		"vendor/lib/lib.go",
+	)
+
+	out := ListFiles(FileListInput{Path: root, Recursive: true})
+	assert.Equal(t, "Found 4 files:\n"+
+		"- "+filepath.Join(root, ".gitignore")+"\n"+
+		"- "+filepath.Join(root, ".raiignore")+"\n"+
+		"- "+filepath.Join(root, "cmd", "main.go")+"\n"+
+		"- "+filepath.Join(root, "go.mod")+"\n", out)
+}
+
+func TestListFilesPatternDepthAndTree(t *testing.T) {
+	root := newFileTree(t,
+		"go.mod",
+		"main.go",
+		"cmd/run.go",
+		"cmd/sub/deep.go",
+		"docs/index.md",
+	)
+
+	out := ListFiles(FileListInput{Path: root, Recursive: true, Pattern: "**/*.go", Tree: true})
+	assert.Equal(t, "Found 3 files:\n"+root+"/\n"+
+		"  cmd/\n"+
+		"    run.go\n"+
+		"    sub/\n"+
+		"      deep.go\n"+
+		"  main.go\n", out)
+
+	out = ListFiles(FileListInput{Path: root, Recursive: true, Pattern: "cmd/*.go"})
+	assert.Equal(t, "Found 1 files:\n- "+filepath.Join(root, "cmd", "run.go")+"\n", out)
+
+	out = ListFiles(FileListInput{Path: root, Recursive: true, Depth: 2, Pattern: "*.go"})
+	assert.Contains(t, out, "run.go")
+	assert.NotContains(t, out, "deep.go")
+
+	out = ListFiles(FileListInput{Path: root, Pattern: "*.go"})
+	assert.Equal(t, "Found 1 files:\n- "+filepath.Join(root, "main.go")+"\n", out)
+}
+
+func TestListFilesIsCapped(t *testing.T) {
+	var files []string
+	for i := 0; i < 30; i++ {
+		files = append(files, filepath.Join("dir", strings.Repeat("f", i+1)))
+	}
+	root := newFileTree(t, files...)
+
+	out := ListFiles(FileListInput{Path: root, Recursive: true, Limit: 10})
+	assert.True(t, strings.HasPrefix(out, "Found 30 files:\n"), out)
+	assert.Equal(t, 10, strings.Count(out, "- "))
+	assert.Contains(t, out, "... and 20 more files")
+}
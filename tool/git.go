@@ -1,22 +1,27 @@
 package tool
 
 import (
-	"os/exec"
-	"strings"
+	"context"
+	"time"
+
+	"github.com/elek/rai/llm"
+	"github.com/google/shlex"
 )
 
 type GitInput struct {
-	Command string `json:"command" description:"The git command to execute including git itself as. For example: git status"`
+	Command string `json:"command" description:"The git command to execute including git itself as. For example: git status. It runs without a shell, so pipes, redirections and chaining with ; or && are not supported"`
+	Timeout int    `json:"timeout" description:"Optional timeout in seconds (default 120, at most 600)"`
 }
 
-func Git(input GitInput) string {
-	if !strings.HasPrefix(input.Command, "git ") {
-		input.Command = "git " + input.Command
-	}
-	cmd := exec.Command("/bin/sh", "-c", input.Command)
-	out, err := cmd.CombinedOutput()
+// Git runs a git command, in the workspace of ctx when there is one. The
+// command is split into arguments like a shell would, and runs without one.
+// Git itself still runs other programs (aliases, hooks, core.fsmonitor, ...)
+// and takes other directories with -C or --git-dir, so it runs in the sandbox
+// of the workspace, like bash, when that is enabled.
+func Git(ctx context.Context, input GitInput) string {
+	args, err := shlex.Split(llm.GitCommand(input.Command))
 	if err != nil {
-		return "Error: " + err.Error() + "\n" + string(out)
+		return CommandResult{Err: err, ExitCode: -1}.String()
 	}
-	return string(out)
+	return runArgs(ctx, args, time.Duration(input.Timeout)*time.Second, true).String()
 }
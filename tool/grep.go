@@ -0,0 +1,213 @@
+package tool
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultGrepResults is the number of matching lines returned when the
+	// input doesn't say otherwise, maxGrepResults the most that can be asked for.
+	defaultGrepResults = 100
+	maxGrepResults     = 1000
+	// maxGrepContext bounds the context lines around each match.
+	maxGrepContext = 10
+	// maxGrepLineLength truncates long (e.g. minified) lines in the output.
+	maxGrepLineLength = 300
+	// maxGrepFileSize skips files too large to be source code.
+	maxGrepFileSize = 10 << 20
+)
+
+type GrepInput struct {
+	Pattern    string `json:"pattern" description:"The regular expression (RE2 syntax) to search for in the file contents"`
+	Path       string `json:"path" description:"The file or directory to search in; empty searches the current directory"`
+	Include    string `json:"include" description:"Optional glob of the files to search, matched against the file name (*.go) or, if it has a slash, the path below the searched directory (cmd/**/*.go)"`
+	IgnoreCase bool   `json:"ignore_case" description:"Whether to match case insensitively"`
+	Context    int    `json:"context" description:"Number of lines to show before and after each match (at most 10)"`
+	MaxResults int    `json:"max_results" description:"Maximum number of matching lines to return (default 100, at most 1000)"`
+}
+
+// Grep searches the contents of the files under a path for a regular
+// expression. Files ignored by .gitignore or .raiignore, hidden directories,
+// and binary files are skipped. Matches are printed as path:line:text, context
+// lines as path-line-text, and non-adjacent groups are separated by --.
+func Grep(ctx context.Context, input GrepInput) (string, error) {
+	if input.Pattern == "" {
+		return "", errors.New("pattern is required")
+	}
+	expr := input.Pattern
+	if input.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid pattern")
+	}
+	display := input.Path
+	if display == "" {
+		display = "."
+	}
+	root, err := WorkspaceFrom(ctx).Resolve(display)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(root) {
+		if root, err = filepath.Abs(root); err != nil {
+			return "", errors.WithStack(err)
+		}
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	g := grep{
+		re:      re,
+		context: min(max(input.Context, 0), maxGrepContext),
+		limit:   input.MaxResults,
+	}
+	if g.limit <= 0 {
+		g.limit = defaultGrepResults
+	}
+	g.limit = min(g.limit, maxGrepResults)
+
+	if !info.IsDir() {
+		g.file(root, display)
+		return g.result(), nil
+	}
+
+	ig := newIgnorer(root)
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable entries are skipped rather than failing the search.
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if path == root {
+			ig.visit(path, d)
+			return nil
+		}
+		if ig.visit(path, d) || (d.IsDir() && strings.HasPrefix(d.Name(), ".")) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel := filepath.ToSlash(strings.TrimPrefix(path, root+string(filepath.Separator)))
+		if input.Include != "" && !matchGlob(input.Include, rel) {
+			return nil
+		}
+		g.file(path, filepath.Join(display, rel))
+		if g.capped {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return g.result(), nil
+}
+
+// grep collects the matches of a search.
+type grep struct {
+	re      *regexp.Regexp
+	context int
+	limit   int
+
+	out     strings.Builder
+	matches int
+	files   int
+	capped  bool
+}
+
+// file searches one file, printing it as name. Files that can't be read,
+// or look binary, are skipped.
+func (g *grep) file(path, name string) {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() > maxGrepFileSize {
+		return
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if bytes.IndexByte(content[:min(len(content), 8000)], 0) >= 0 {
+		return
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(nil, maxGrepFileSize)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	// printed is the index after the last line printed, to avoid printing
+	// context twice and to know when to separate groups.
+	printed := -1
+	found := false
+	for i, line := range lines {
+		if !g.re.MatchString(line) {
+			continue
+		}
+		if g.matches == g.limit {
+			g.capped = true
+			break
+		}
+		g.matches++
+		if !found {
+			found = true
+			g.files++
+		}
+		from := max(i-g.context, printed, 0)
+		if g.context > 0 && (printed >= 0 && from > printed || printed < 0 && g.out.Len() > 0) {
+			g.out.WriteString("--\n")
+		}
+		for j := from; j < i; j++ {
+			g.line(name, j, '-', lines[j])
+		}
+		g.line(name, i, ':', line)
+		printed = i + 1
+		// The context after a match is printed up to the next match, which
+		// prints its own line.
+		for j := i + 1; j <= i+g.context && j < len(lines) && !g.re.MatchString(lines[j]); j++ {
+			g.line(name, j, '-', lines[j])
+			printed = j + 1
+		}
+	}
+}
+
+func (g *grep) line(name string, i int, sep byte, text string) {
+	if runes := []rune(text); len(runes) > maxGrepLineLength {
+		text = string(runes[:maxGrepLineLength]) + "..."
+	}
+	fmt.Fprintf(&g.out, "%s%c%d%c%s\n", name, sep, i+1, sep, text)
+}
+
+func (g *grep) result() string {
+	if g.matches == 0 {
+		return "No matches found"
+	}
+	res := g.out.String()
+	if g.capped {
+		res += fmt.Sprintf("(showing the first %d matches; narrow the search with path or include to see the rest)\n", g.matches)
+	} else {
+		res += fmt.Sprintf("(%d matches in %d files)\n", g.matches, g.files)
+	}
+	return res
+}
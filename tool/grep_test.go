@@ -0,0 +1,164 @@
+package tool
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newGrepTree creates a small repository to search in and returns a context
+// confined to it.
+func newGrepTree(t *testing.T) context.Context {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		".gitignore":            "build/\n*.log\n!keep.log\n",
+		"main.go":               "package main\n\nfunc main() {\n\tHello()\n}\n",
+		"hello.go":              "package main\n\n// Hello greets.\nfunc Hello() {\n\tprintln(\"hello\")\n}\n",
+		"cmd/tool/tool.go":      "package tool\n\nfunc hello() {}\n",
+		"cmd/.gitignore":        "tool/generated.go\n",
+		"cmd/tool/generated.go": "package tool\n\nfunc hello2() {}\n",
+		"build/out.go":          "func hello() {}\n",
+		"debug.log":             "hello\n",
+		"keep.log":              "hello\n",
+		".git/config":           "hello\n",
+		"image.bin":             "hello\x00world\n",
+	} {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	ws, err := NewWorkspace(root, false)
+	require.NoError(t, err)
+	return ContextWithWorkspace(context.Background(), ws)
+}
+
+func TestGrepRespectsGitignore(t *testing.T) {
+	ctx := newGrepTree(t)
+
+	out, err := Grep(ctx, GrepInput{Pattern: "hello", IgnoreCase: true})
+	require.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"cmd/tool/tool.go:3:func hello() {}",
+		"hello.go:3:// Hello greets.",
+		"hello.go:4:func Hello() {",
+		`hello.go:5:	println("hello")`,
+		"keep.log:1:hello",
+		"main.go:4:	Hello()",
+		"(6 matches in 4 files)",
+		"",
+	}, "\n"), out)
+}
+
+func TestGrepIncludeAndPath(t *testing.T) {
+	ctx := newGrepTree(t)
+
+	out, err := Grep(ctx, GrepInput{Pattern: `func \w+\(`, Include: "*.go"})
+	require.NoError(t, err)
+	assert.Contains(t, out, "main.go:3:func main() {")
+	assert.NotContains(t, out, "keep.log")
+
+	out, err = Grep(ctx, GrepInput{Pattern: "func", Path: "cmd", Include: "tool/**/*.go"})
+	require.NoError(t, err)
+	assert.Equal(t, "cmd/tool/tool.go:3:func hello() {}\n(1 matches in 1 files)\n", out)
+
+	out, err = Grep(ctx, GrepInput{Pattern: "Hello", Path: "main.go"})
+	require.NoError(t, err)
+	assert.Equal(t, "main.go:4:\tHello()\n(1 matches in 1 files)\n", out)
+
+	out, err = Grep(ctx, GrepInput{Pattern: "nothing like this"})
+	require.NoError(t, err)
+	assert.Equal(t, "No matches found", out)
+
+	_, err = Grep(ctx, GrepInput{Pattern: "x", Path: "../"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "outside of the workspace")
+
+	_, err = Grep(ctx, GrepInput{Pattern: "("})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid pattern")
+}
+
+func TestGrepContextAndCap(t *testing.T) {
+	root := t.TempDir()
+	var lines []string
+	for i := 1; i <= 20; i++ {
+		line := "line"
+		if i == 5 || i == 7 || i == 15 {
+			line = "match"
+		}
+		lines = append(lines, line)
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte(strings.Join(lines, "\n")+"\n"), 0o644))
+	ws, err := NewWorkspace(root, false)
+	require.NoError(t, err)
+	ctx := ContextWithWorkspace(context.Background(), ws)
+
+	out, err := Grep(ctx, GrepInput{Pattern: "match", Context: 1})
+	require.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"a.txt-4-line",
+		"a.txt:5:match",
+		"a.txt-6-line",
+		"a.txt:7:match",
+		"a.txt-8-line",
+		"--",
+		"a.txt-14-line",
+		"a.txt:15:match",
+		"a.txt-16-line",
+		"(3 matches in 1 files)",
+		"",
+	}, "\n"), out)
+
+	out, err = Grep(ctx, GrepInput{Pattern: "match", MaxResults: 2})
+	require.NoError(t, err)
+	assert.Equal(t, "a.txt:5:match\na.txt:7:match\n(showing the first 2 matches; narrow the search with path or include to see the rest)\n", out)
+}
+
+func TestGrepCutsLongLinesBetweenCharacters(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte("x"+strings.Repeat("é", 400)+"\n"), 0o644))
+	ws, err := NewWorkspace(root, false)
+	require.NoError(t, err)
+
+	out, err := Grep(ContextWithWorkspace(context.Background(), ws), GrepInput{Pattern: "x"})
+	require.NoError(t, err)
+	assert.True(t, utf8.ValidString(out))
+	assert.Contains(t, out, "a.txt:1:x"+strings.Repeat("é", maxGrepLineLength-1)+"...\n")
+}
+
+func TestIgnoreRules(t *testing.T) {
+	ig := &ignorer{}
+	for _, line := range []string{"*.o", "/vendor", "docs/**/*.tmp", "out/", "!important.o"} {
+		rule, ok := parseIgnoreRule("", line)
+		require.True(t, ok, line)
+		ig.rules = append(ig.rules, rule)
+	}
+	rule, ok := parseIgnoreRule("sub", "local")
+	require.True(t, ok)
+	ig.rules = append(ig.rules, rule)
+
+	for rel, want := range map[string]bool{
+		"a.o":            true,
+		"x/y/a.o":        true,
+		"important.o":    false,
+		"vendor":         true,
+		"x/vendor":       false,
+		"docs/a.tmp":     true,
+		"docs/x/y/a.tmp": true,
+		"a.tmp":          false,
+		"sub/local":      true,
+		"local":          false,
+		"main.go":        false,
+	} {
+		assert.Equal(t, want, ig.ignored(rel, false), rel)
+	}
+	assert.True(t, ig.ignored("x/out", true))
+	assert.False(t, ig.ignored("x/out", false), "out/ only matches directories")
+}
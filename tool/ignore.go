@@ -0,0 +1,215 @@
+package tool
+
+import (
+	"bufio"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreFiles are the files holding ignore patterns, read in every directory.
+// .raiignore hides files from the tools without changing what git tracks.
+var ignoreFiles = []string{".gitignore", ".raiignore"}
+
+// ignoreRule is one pattern of an ignore file.
+type ignoreRule struct {
+	// base is the directory of the ignore file, relative to the root of the
+	// ignorer and slash separated; empty for the root itself.
+	base    string
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignorer decides which files are ignored by the ignore files of a tree,
+// with the semantics of git: the last matching pattern wins, a pattern with a
+// slash is relative to its directory, and one without matches at any depth.
+type ignorer struct {
+	root  string
+	rules []ignoreRule
+}
+
+// newIgnorer returns an ignorer for walking dir. The patterns of the ignore
+// files between the enclosing git repository (if any) and dir are loaded
+// right away; the ones below dir are loaded by visit as the walk reaches them.
+func newIgnorer(dir string) *ignorer {
+	dir = filepath.Clean(dir)
+	ig := &ignorer{root: dir}
+	for parent := dir; ; {
+		if _, err := os.Stat(filepath.Join(parent, ".git")); err == nil {
+			ig.root = parent
+			break
+		}
+		next := filepath.Dir(parent)
+		if next == parent {
+			break
+		}
+		parent = next
+	}
+	var ancestors []string
+	for parent := dir; parent != ig.root; {
+		parent = filepath.Dir(parent)
+		ancestors = append([]string{parent}, ancestors...)
+	}
+	for _, a := range ancestors {
+		ig.load(a)
+	}
+	return ig
+}
+
+// load reads the ignore files of dir.
+func (ig *ignorer) load(dir string) {
+	base := ig.rel(dir)
+	for _, name := range ignoreFiles {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if rule, ok := parseIgnoreRule(base, scanner.Text()); ok {
+				ig.rules = append(ig.rules, rule)
+			}
+		}
+		_ = f.Close()
+	}
+}
+
+// rel returns path relative to the root, slash separated.
+func (ig *ignorer) rel(path string) string {
+	rel, err := filepath.Rel(ig.root, path)
+	if err != nil || rel == "." {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// visit is called for every entry of a walk. It loads the ignore files of
+// directories, and reports whether the entry is ignored, in which case a
+// directory shouldn't be descended into.
+func (ig *ignorer) visit(path string, d fs.DirEntry) bool {
+	if d.IsDir() && d.Name() == ".git" {
+		return true
+	}
+	if ig.ignored(ig.rel(path), d.IsDir()) {
+		return true
+	}
+	if d.IsDir() {
+		ig.load(path)
+	}
+	return false
+}
+
+// ignored reports whether the slash separated path relative to the root is
+// ignored.
+func (ig *ignorer) ignored(rel string, dir bool) bool {
+	if rel == "" {
+		return false
+	}
+	ignored := false
+	for _, r := range ig.rules {
+		if r.dirOnly && !dir {
+			continue
+		}
+		p := rel
+		if r.base != "" {
+			if !strings.HasPrefix(rel, r.base+"/") {
+				continue
+			}
+			p = strings.TrimPrefix(rel, r.base+"/")
+		}
+		if r.re.MatchString(p) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// parseIgnoreRule parses a line of an ignore file in the directory base.
+func parseIgnoreRule(base, line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return ignoreRule{}, false
+	}
+	expr := globExpr(line)
+	if !anchored {
+		expr = "(.*/)?" + expr
+	}
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	rule.re = re
+	return rule, true
+}
+
+// matchGlob reports whether the slash separated path matches pattern. A
+// pattern without a slash is matched against the last element of the path;
+// `**` matches any number of directories.
+func matchGlob(pattern, path string) bool {
+	if !strings.Contains(pattern, "/") {
+		path = path[strings.LastIndex(path, "/")+1:]
+	}
+	re, err := regexp.Compile("^" + globExpr(pattern) + "$")
+	return err == nil && re.MatchString(path)
+}
+
+// globExpr translates a glob with `*`, `?`, `[...]` and `**` to a regular
+// expression.
+func globExpr(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			b.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
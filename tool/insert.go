@@ -36,5 +36,6 @@ func Insert(input InsertInput) (string, error) {
 	if err != nil {
 		return "", errors.Wrap(err, "error writing file")
 	}
+	fileChanged(input.Path)
 	return "File updated successfully at " + input.Path, nil
 }
@@ -6,9 +6,9 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/elek/lspc/powernap/pkg/config"
-	"github.com/elek/lspc/powernap/pkg/lsp"
 	"github.com/elek/lspc/powernap/pkg/lsp/protocol"
 	"github.com/elek/lspc/powernap/pkg/registry"
 	"github.com/elek/rai/llm"
@@ -25,29 +25,98 @@ func NewLSPAgentTool(ctx context.Context, command string, root string) ([]llm.To
 		return nil, func() {}, err
 	}
 
+	return lspAgentTools(server), server.Close, nil
+}
+
+// lspTools are the operations behind the LSP tools, implemented by a single
+// server and by the router that picks a server per file.
+type lspTools interface {
+	Symbols(context.Context, SymbolInput) (string, error)
+	Definition(context.Context, PositionInput) (string, error)
+	References(context.Context, PositionInput) (string, error)
+	Hover(context.Context, PositionInput) (string, error)
+	WorkspaceSymbols(context.Context, WorkspaceSymbolInput) (string, error)
+	Diagnostics(context.Context, DiagnosticsInput) (string, error)
+	Rename(context.Context, RenameInput) (string, error)
+	CodeAction(context.Context, CodeActionInput) (string, error)
+}
+
+func lspAgentTools(s lspTools) []llm.Tool {
 	var res []llm.Tool
 
-	res = append(res, ToAgentTool[SymbolInput]("list-symbols", "List all available symbol names from a source code file (structs, functions, methods...) ", server.Symbols))
+	res = append(res, llm.NewTool[SymbolInput]("list-symbols", "List all available symbol names from a source code file (structs, functions, methods...) ", s.Symbols))
+	res = append(res, llm.Parallel(llm.NewTool[PositionInput]("goto-definition", "Find where a symbol used in a source file is defined", s.Definition)))
+	res = append(res, llm.Parallel(llm.NewTool[PositionInput]("find-references", "Find all references to a symbol in the project", s.References)))
+	res = append(res, llm.Parallel(llm.NewTool[PositionInput]("hover", "Show the type, signature and documentation of a symbol used in a source file", s.Hover)))
+	res = append(res, llm.Parallel(llm.NewTool[WorkspaceSymbolInput]("workspace-symbol", "Search the symbols (types, functions, methods, ...) of the whole project by name", s.WorkspaceSymbols)))
+	res = append(res, llm.NewTool[DiagnosticsInput]("diagnostics", "Show the compile errors and warnings the language server reports for source files, e.g. after editing them", s.Diagnostics))
+	res = append(res, llm.NewTool[RenameInput]("rename-symbol", "Rename a symbol everywhere it is used in the project, and write the changed files", s.Rename))
+	res = append(res, llm.NewTool[CodeActionInput]("code-action", "List the code actions (quick fixes, refactorings, organize imports, ...) the language server offers for a file or line, or apply one by its title", s.CodeAction))
+
+	return res
+}
 
-	return res, server.Close, nil
+// lspClient is the part of the language server client the tools use.
+type lspClient interface {
+	Shutdown(ctx context.Context) error
+	DocumentSymbols(ctx context.Context, path string) ([]protocol.DocumentSymbol, error)
+	Call(ctx context.Context, method string, params any, result any) error
+	Notify(ctx context.Context, method string, params any) error
 }
 
 type LSPServer struct {
-	client  *lsp.Client
+	client  lspClient
 	project string
+	// base is the directory the paths in the output are relative to.
+	base string
+
+	// syncMu serializes sending documents to the server, mu guards the
+	// fields below.
+	syncMu sync.Mutex
+	mu     sync.Mutex
+	// versions and texts are the version and content of the open documents,
+	// by URI.
+	versions map[string]int
+	texts    map[string]string
+	// diagnostics are the last ones published for each URI, stale marks the
+	// URIs changed since, and publish is closed (and replaced) on each one.
+	diagnostics map[string][]lspDiagnostic
+	stale       map[string]bool
+	publish     chan struct{}
+	unwatch     func()
+}
+
+// newLSPServer wraps a started client for the project root.
+func newLSPServer(client lspClient, project string) *LSPServer {
+	s := &LSPServer{
+		client:      client,
+		project:     project,
+		base:        project,
+		versions:    map[string]int{},
+		texts:       map[string]string{},
+		diagnostics: map[string][]lspDiagnostic{},
+		stale:       map[string]bool{},
+		publish:     make(chan struct{}),
+	}
+	s.unwatch = watchFiles(s.fileChanged)
+	return s
 }
 
 func (s *LSPServer) Close() {
+	s.unwatch()
 	ctx := context.TODO()
 	_ = s.client.Shutdown(ctx)
 }
 
-func (s *LSPServer) Symbols(i SymbolInput) (string, error) {
+func (s *LSPServer) Symbols(ctx context.Context, i SymbolInput) (string, error) {
 	buff := bytes.NewBuffer([]byte{})
 
-	ctx := context.TODO()
 	for _, path := range i.Paths {
-		resp, err := s.client.DocumentSymbols(ctx, path)
+		abs, err := absPath(ctx, path)
+		if err != nil {
+			return "", err
+		}
+		resp, err := s.client.DocumentSymbols(ctx, abs)
 		if err != nil {
 			return "", errors.WithStack(err)
 		}
@@ -104,10 +173,9 @@ func NewLSPServer(ctx context.Context, command string, root string) (*LSPServer,
 		return nil, errors.WithStack(err)
 	}
 
-	return &LSPServer{
-		client:  cl,
-		project: project,
-	}, nil
+	s := newLSPServer(cl, project)
+	cl.RegisterNotificationHandler("textDocument/publishDiagnostics", s.publishDiagnostics)
+	return s, nil
 }
 
 func ToAgentTool[I any](name string, desc string, f func(I) (string, error)) llm.Tool {
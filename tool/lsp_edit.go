@@ -0,0 +1,468 @@
+package tool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// RenameInput defines the input of the rename-symbol tool.
+type RenameInput struct {
+	Path    string `json:"path" description:"Path of the source file"`
+	Line    int    `json:"line" description:"The 1-based line number where the symbol appears"`
+	Symbol  string `json:"symbol" description:"The identifier on that line to rename"`
+	NewName string `json:"new_name" description:"The new name of the symbol"`
+}
+
+// CodeActionInput defines the input of the code-action tool.
+type CodeActionInput struct {
+	Path  string `json:"path" description:"Path of the source file"`
+	Line  int    `json:"line" description:"Optional 1-based line number to get the actions of; 0 means the whole file"`
+	Kind  string `json:"kind" description:"Optional kind of actions to get, e.g. quickfix, refactor or source.organizeImports"`
+	Title string `json:"title" description:"Optional title of the action to apply; leave it empty to list the available actions first"`
+}
+
+// lspTextEdit replaces a range of a document with new text.
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+// lspWorkspaceEdit is the WorkspaceEdit returned by rename and code actions.
+type lspWorkspaceEdit struct {
+	Changes map[string][]lspTextEdit `json:"changes"`
+	// DocumentChanges are either TextDocumentEdits or create, rename and
+	// delete file operations.
+	DocumentChanges []struct {
+		Kind         string `json:"kind"`
+		TextDocument struct {
+			URI string `json:"uri"`
+		} `json:"textDocument"`
+		Edits   []lspTextEdit `json:"edits"`
+		URI     string        `json:"uri"`
+		OldURI  string        `json:"oldUri"`
+		NewURI  string        `json:"newUri"`
+		Options struct {
+			Overwrite         bool `json:"overwrite"`
+			IgnoreIfExists    bool `json:"ignoreIfExists"`
+			IgnoreIfNotExists bool `json:"ignoreIfNotExists"`
+		} `json:"options"`
+	} `json:"documentChanges"`
+}
+
+// lspCodeAction is a CodeAction, or a bare Command (when Command is a string).
+type lspCodeAction struct {
+	Title    string            `json:"title"`
+	Kind     string            `json:"kind"`
+	Edit     *lspWorkspaceEdit `json:"edit"`
+	Command  json.RawMessage   `json:"command"`
+	Data     json.RawMessage   `json:"data"`
+	Disabled *struct {
+		Reason string `json:"reason"`
+	} `json:"disabled"`
+	raw json.RawMessage
+}
+
+// commandName returns the name of the server command the action runs, if any.
+func (a lspCodeAction) commandName() string {
+	var name string
+	if json.Unmarshal(a.Command, &name) == nil {
+		return name
+	}
+	var cmd struct {
+		Command string `json:"command"`
+	}
+	_ = json.Unmarshal(a.Command, &cmd)
+	return cmd.Command
+}
+
+// Rename renames a symbol everywhere in the project, and writes the changes.
+func (s *LSPServer) Rename(ctx context.Context, input RenameInput) (string, error) {
+	if input.NewName == "" {
+		return "", errors.New("new_name is required")
+	}
+	params, err := s.position(ctx, PositionInput{Path: input.Path, Line: input.Line, Symbol: input.Symbol})
+	if err != nil {
+		return "", err
+	}
+	params["newName"] = input.NewName
+	var edit *lspWorkspaceEdit
+	if err := s.client.Call(ctx, "textDocument/rename", params, &edit); err != nil {
+		return "", errors.WithStack(err)
+	}
+	if edit == nil {
+		return "", errors.Errorf("the language server can't rename %s", input.Symbol)
+	}
+	summary, err := s.applyWorkspaceEdit(ctx, edit)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Renamed %s to %s in %s", input.Symbol, input.NewName, summary), nil
+}
+
+// CodeAction lists the code actions the server offers for a file or a line,
+// or applies the one with the given title.
+func (s *LSPServer) CodeAction(ctx context.Context, input CodeActionInput) (string, error) {
+	path, err := absPath(ctx, input.Path)
+	if err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	lines := strings.Split(string(content), "\n")
+	if input.Line < 0 || input.Line > len(lines) {
+		return "", errors.Errorf("line %d is out of range, %s has %d lines", input.Line, input.Path, len(lines))
+	}
+	if err := s.sync(ctx, path); err != nil {
+		return "", err
+	}
+	uri := fileURI(path)
+	// Quick fixes are offered for the diagnostics sent along, so they have
+	// to be the ones of the current content.
+	s.waitDiagnostics(ctx, uri)
+
+	rng := lspRange{End: lspPosition{Line: len(lines)}}
+	if input.Line > 0 {
+		rng = lspRange{Start: lspPosition{Line: input.Line - 1}, End: lspPosition{Line: input.Line}}
+	}
+	diagnostics := []lspDiagnostic{}
+	s.mu.Lock()
+	for _, d := range s.diagnostics[uri] {
+		if d.Range.Start.Line < rng.End.Line && d.Range.End.Line >= rng.Start.Line {
+			diagnostics = append(diagnostics, d)
+		}
+	}
+	s.mu.Unlock()
+	actionContext := map[string]any{"diagnostics": diagnostics}
+	if input.Kind != "" {
+		actionContext["only"] = []string{input.Kind}
+	}
+	var raws []json.RawMessage
+	err = s.client.Call(ctx, "textDocument/codeAction", map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"range":        rng,
+		"context":      actionContext,
+	}, &raws)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	var actions []lspCodeAction
+	for _, raw := range raws {
+		var a lspCodeAction
+		if err := json.Unmarshal(raw, &a); err != nil {
+			return "", errors.WithStack(err)
+		}
+		a.raw = raw
+		actions = append(actions, a)
+	}
+	if len(actions) == 0 {
+		return "No code actions available", nil
+	}
+
+	if input.Title == "" {
+		var b strings.Builder
+		fmt.Fprintf(&b, "%d code actions:\n", len(actions))
+		for _, a := range actions {
+			b.WriteString("- ")
+			if a.Kind != "" {
+				fmt.Fprintf(&b, "[%s] ", a.Kind)
+			}
+			b.WriteString(a.Title)
+			if a.Disabled != nil {
+				fmt.Fprintf(&b, " (disabled: %s)", a.Disabled.Reason)
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString("Call code-action again with the title of the action to apply.")
+		return b.String(), nil
+	}
+
+	action, err := findCodeAction(actions, input.Title)
+	if err != nil {
+		return "", err
+	}
+	if action.Disabled != nil {
+		return "", errors.Errorf("the code action %q is disabled: %s", action.Title, action.Disabled.Reason)
+	}
+	if action.Edit == nil && action.Data != nil {
+		resolved := lspCodeAction{}
+		if err := s.client.Call(ctx, "codeAction/resolve", action.raw, &resolved); err != nil {
+			return "", errors.WithStack(err)
+		}
+		action.Edit, action.Command = resolved.Edit, resolved.Command
+	}
+	if action.Edit == nil {
+		if name := action.commandName(); name != "" {
+			return "", errors.Errorf("the code action %q runs the server command %s, which is not supported; only actions with edits can be applied", action.Title, name)
+		}
+		return "", errors.Errorf("the code action %q doesn't change anything", action.Title)
+	}
+	summary, err := s.applyWorkspaceEdit(ctx, action.Edit)
+	if err != nil {
+		return "", err
+	}
+	out := fmt.Sprintf("Applied %q to %s", action.Title, summary)
+	if name := action.commandName(); name != "" {
+		out += fmt.Sprintf("\nThe server command %s of the action was not run.", name)
+	}
+	return out, nil
+}
+
+// findCodeAction returns the action with the given title, or the only one
+// whose title contains it.
+func findCodeAction(actions []lspCodeAction, title string) (lspCodeAction, error) {
+	var matches []lspCodeAction
+	for _, a := range actions {
+		if a.Title == title {
+			return a, nil
+		}
+		if strings.Contains(strings.ToLower(a.Title), strings.ToLower(title)) {
+			matches = append(matches, a)
+		}
+	}
+	switch len(matches) {
+	case 1:
+		return matches[0], nil
+	case 0:
+		return lspCodeAction{}, errors.Errorf("there is no code action %q", title)
+	default:
+		var titles []string
+		for _, a := range matches {
+			titles = append(titles, a.Title)
+		}
+		return lspCodeAction{}, errors.Errorf("%q matches %d code actions, use the full title: %s", title, len(matches), strings.Join(titles, "; "))
+	}
+}
+
+// applyWorkspaceEdit writes the changes of a WorkspaceEdit to the files of the
+// workspace of ctx, all or none of them, and summarizes them with diffs.
+func (s *LSPServer) applyWorkspaceEdit(ctx context.Context, edit *lspWorkspaceEdit) (string, error) {
+	ws := WorkspaceFrom(ctx)
+	files := map[string]*patchFile{}
+	texts := map[string]string{}
+	// sources are the paths renamed files were moved from, by their target.
+	sources := map[string]string{}
+	var order []string
+	load := func(uri string) (string, *patchFile, error) {
+		path, err := ws.Resolve(uriPath(uri))
+		if err != nil {
+			return "", nil, err
+		}
+		if f, ok := files[path]; ok {
+			return path, f, nil
+		}
+		f := &patchFile{display: s.display(path), mode: 0644}
+		content, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			stat, err := os.Stat(path)
+			if err != nil {
+				return "", nil, errors.WithStack(err)
+			}
+			f.existed, f.original, f.mode = true, content, stat.Mode()
+			texts[path] = string(content)
+		case os.IsNotExist(err):
+			f.deleted = true
+		default:
+			return "", nil, errors.WithStack(err)
+		}
+		files[path] = f
+		order = append(order, path)
+		return path, f, nil
+	}
+	change := func(uri string, edits []lspTextEdit) error {
+		path, f, err := load(uri)
+		if err != nil {
+			return err
+		}
+		if f.deleted {
+			return errors.Errorf("%s: can't edit the file, it doesn't exist", f.display)
+		}
+		texts[path], err = applyTextEdits(texts[path], edits)
+		return errors.Wrap(err, f.display)
+	}
+
+	uris := make([]string, 0, len(edit.Changes))
+	for uri := range edit.Changes {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	for _, uri := range uris {
+		if err := change(uri, edit.Changes[uri]); err != nil {
+			return "", err
+		}
+	}
+	for _, dc := range edit.DocumentChanges {
+		switch dc.Kind {
+		case "":
+			if err := change(dc.TextDocument.URI, dc.Edits); err != nil {
+				return "", err
+			}
+		case "create":
+			path, f, err := load(dc.URI)
+			if err != nil {
+				return "", err
+			}
+			if !f.deleted && dc.Options.IgnoreIfExists {
+				continue
+			}
+			if !f.deleted && !dc.Options.Overwrite {
+				return "", errors.Errorf("%s: can't create the file, it already exists", f.display)
+			}
+			f.deleted, texts[path] = false, ""
+		case "delete":
+			path, f, err := load(dc.URI)
+			if err != nil {
+				return "", err
+			}
+			if f.deleted {
+				if dc.Options.IgnoreIfNotExists {
+					continue
+				}
+				return "", errors.Errorf("%s: can't delete the file, it doesn't exist", f.display)
+			}
+			f.deleted = true
+			delete(texts, path)
+		case "rename":
+			from, f, err := load(dc.OldURI)
+			if err != nil {
+				return "", err
+			}
+			to, target, err := load(dc.NewURI)
+			if err != nil {
+				return "", err
+			}
+			if f.deleted {
+				return "", errors.Errorf("%s: can't rename the file, it doesn't exist", f.display)
+			}
+			if !target.deleted && !dc.Options.Overwrite {
+				if dc.Options.IgnoreIfExists {
+					continue
+				}
+				return "", errors.Errorf("%s: can't rename the file to %s, it already exists", f.display, target.display)
+			}
+			target.deleted, target.mode, target.movedFrom = false, f.mode, f.display
+			texts[to], sources[to] = texts[from], from
+			f.deleted, f.moved = true, true
+			delete(texts, from)
+		default:
+			return "", errors.Errorf("unknown document change %q", dc.Kind)
+		}
+	}
+
+	for _, path := range order {
+		f := files[path]
+		if f.deleted {
+			f.removed = len(splitLines(string(f.original)))
+			continue
+		}
+		f.lines = splitLines(texts[path])
+		f.noEOL = texts[path] != "" && !strings.HasSuffix(texts[path], "\n")
+		before := string(f.original)
+		if from, ok := sources[path]; ok {
+			before = string(files[from].original)
+		}
+		for _, op := range diffLines(splitLines(before), f.lines) {
+			switch op.kind {
+			case '+':
+				f.added++
+			case '-':
+				f.removed++
+			}
+		}
+	}
+	if err := writePatchFiles(files, order); err != nil {
+		return "", err
+	}
+	for _, path := range order {
+		fileChanged(path)
+	}
+
+	var summary, diffs strings.Builder
+	var changed int
+	for _, path := range order {
+		f := files[path]
+		status, display := "", f.display
+		switch {
+		case f.moved && f.deleted:
+			continue
+		case f.movedFrom != "":
+			status, display = "R", f.movedFrom+" -> "+f.display
+		case f.existed && f.deleted:
+			status = "D"
+		case !f.existed && !f.deleted:
+			status = "A"
+		case f.existed && texts[path] != string(f.original):
+			status = "M"
+			diffs.WriteString(unifiedDiff(f.display, string(f.original), texts[path]))
+		default:
+			continue
+		}
+		changed++
+		fmt.Fprintf(&summary, "%s %s (+%d -%d)\n", status, display, f.added, f.removed)
+	}
+	out := &cappedOutput{limit: maxCommandOutput}
+	_, _ = out.Write([]byte(diffs.String()))
+	return fmt.Sprintf("%d files:\n%s%s", changed, summary.String(), out.String()), nil
+}
+
+// applyTextEdits applies non-overlapping edits, whose ranges refer to the
+// original text, to text.
+func applyTextEdits(text string, edits []lspTextEdit) (string, error) {
+	type span struct {
+		start, end int
+		text       string
+	}
+	spans := make([]span, len(edits))
+	for i, e := range edits {
+		spans[i] = span{offset(text, e.Range.Start), offset(text, e.Range.End), e.NewText}
+		if spans[i].end < spans[i].start {
+			return "", errors.Errorf("edit %d has an invalid range", i+1)
+		}
+	}
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	var b strings.Builder
+	last := 0
+	for _, sp := range spans {
+		if sp.start < last {
+			return "", errors.New("the edits overlap")
+		}
+		b.WriteString(text[last:sp.start])
+		b.WriteString(sp.text)
+		last = sp.end
+	}
+	b.WriteString(text[last:])
+	return b.String(), nil
+}
+
+// offset returns the byte offset of an LSP position in text. Positions past
+// the end of a line or of the text are clamped.
+func offset(text string, pos lspPosition) int {
+	start := 0
+	for line := 0; line < pos.Line; line++ {
+		i := strings.IndexByte(text[start:], '\n')
+		if i < 0 {
+			return len(text)
+		}
+		start += i + 1
+	}
+	end := strings.IndexByte(text[start:], '\n')
+	if end < 0 {
+		end = len(text) - start
+	}
+	units := 0
+	for i, r := range text[start : start+end] {
+		if units >= pos.Character {
+			return start + i
+		}
+		units += utf16Len(string(r))
+	}
+	return start + end
+}
@@ -0,0 +1,314 @@
+package tool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// maxLocations bounds the locations listed by the navigation tools.
+const maxLocations = 100
+
+// PositionInput points to a symbol in a source file.
+type PositionInput struct {
+	Path   string `json:"path" description:"Path of the source file"`
+	Line   int    `json:"line" description:"The 1-based line number where the symbol appears"`
+	Symbol string `json:"symbol" description:"The identifier on that line to look up, e.g. a function, type or variable name"`
+}
+
+// WorkspaceSymbolInput defines the input of the workspace-symbol tool.
+type WorkspaceSymbolInput struct {
+	Query string `json:"query" description:"The name, or part of the name, of the symbols to find"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+	// TargetURI and TargetSelectionRange are set instead for a LocationLink.
+	TargetURI            string   `json:"targetUri"`
+	TargetSelectionRange lspRange `json:"targetSelectionRange"`
+}
+
+var symbolKinds = []string{"", "file", "module", "namespace", "package", "class", "method", "property", "field",
+	"constructor", "enum", "interface", "function", "variable", "constant", "string", "number", "boolean", "array",
+	"object", "key", "null", "enum member", "struct", "event", "operator", "type parameter"}
+
+// absPath returns the absolute path of a path given to a tool, confined to the
+// workspace of ctx; relative paths are relative to the workspace or, without
+// one, to the current directory.
+func absPath(ctx context.Context, path string) (string, error) {
+	if path == "" {
+		return "", errors.New("path is required")
+	}
+	resolved, err := WorkspaceFrom(ctx).Resolve(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(resolved)
+}
+
+// display returns path relative to the base directory, when it is inside of
+// it.
+func (s *LSPServer) display(path string) string {
+	if rel, err := filepath.Rel(s.base, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+// position finds the symbol of input in its file, syncs the file to the
+// server, and returns the textDocumentPositionParams pointing at it.
+func (s *LSPServer) position(ctx context.Context, input PositionInput) (map[string]any, error) {
+	path, err := absPath(ctx, input.Path)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	lines := strings.Split(string(content), "\n")
+	if input.Line < 1 || input.Line > len(lines) {
+		return nil, errors.Errorf("line %d is out of range, %s has %d lines", input.Line, input.Path, len(lines))
+	}
+	line := lines[input.Line-1]
+	col := symbolColumn(line, input.Symbol)
+	if col < 0 {
+		return nil, errors.Errorf("symbol %q is not on line %d of %s: %q", input.Symbol, input.Line, input.Path, strings.TrimSpace(line))
+	}
+	if err := s.sync(ctx, path); err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"textDocument": map[string]any{"uri": fileURI(path)},
+		"position":     lspPosition{Line: input.Line - 1, Character: utf16Len(line[:col])},
+	}, nil
+}
+
+// symbolColumn returns the byte offset of symbol in line, preferring an
+// occurrence that is a whole identifier, or -1. An empty symbol points to the
+// first non-blank character.
+func symbolColumn(line, symbol string) int {
+	if symbol == "" {
+		return len(line) - len(strings.TrimLeft(line, " \t"))
+	}
+	first := -1
+	for from := 0; ; {
+		i := strings.Index(line[from:], symbol)
+		if i < 0 {
+			return first
+		}
+		i += from
+		if first < 0 {
+			first = i
+		}
+		before, _ := utf8.DecodeLastRuneInString(line[:i])
+		after, _ := utf8.DecodeRuneInString(line[i+len(symbol):])
+		if !isIdentRune(before) && !isIdentRune(after) {
+			return i
+		}
+		from = i + 1
+	}
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// utf16Len returns the length of s in UTF-16 code units, the unit of LSP
+// character offsets.
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// Definition returns where the symbol is defined.
+func (s *LSPServer) Definition(ctx context.Context, input PositionInput) (string, error) {
+	params, err := s.position(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	var raw json.RawMessage
+	if err := s.client.Call(ctx, "textDocument/definition", params, &raw); err != nil {
+		return "", errors.WithStack(err)
+	}
+	locations, err := parseLocations(raw)
+	if err != nil {
+		return "", err
+	}
+	if len(locations) == 0 {
+		return fmt.Sprintf("No definition found for %s", input.Symbol), nil
+	}
+	return s.formatLocations(locations), nil
+}
+
+// References returns every reference to the symbol, with its declaration.
+func (s *LSPServer) References(ctx context.Context, input PositionInput) (string, error) {
+	params, err := s.position(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	params["context"] = map[string]any{"includeDeclaration": true}
+	var raw json.RawMessage
+	if err := s.client.Call(ctx, "textDocument/references", params, &raw); err != nil {
+		return "", errors.WithStack(err)
+	}
+	locations, err := parseLocations(raw)
+	if err != nil {
+		return "", err
+	}
+	if len(locations) == 0 {
+		return fmt.Sprintf("No references found for %s", input.Symbol), nil
+	}
+	return fmt.Sprintf("%d references:\n%s", len(locations), s.formatLocations(locations)), nil
+}
+
+// Hover returns the documentation the server shows for the symbol.
+func (s *LSPServer) Hover(ctx context.Context, input PositionInput) (string, error) {
+	params, err := s.position(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	var hover struct {
+		Contents json.RawMessage `json:"contents"`
+	}
+	if err := s.client.Call(ctx, "textDocument/hover", params, &hover); err != nil {
+		return "", errors.WithStack(err)
+	}
+	text := strings.TrimSpace(hoverText(hover.Contents))
+	if text == "" {
+		return fmt.Sprintf("No information found for %s", input.Symbol), nil
+	}
+	return text, nil
+}
+
+// hoverText returns the text of hover contents: MarkupContent, a MarkedString
+// or a list of MarkedStrings.
+func hoverText(raw json.RawMessage) string {
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return text
+	}
+	var list []json.RawMessage
+	if json.Unmarshal(raw, &list) == nil {
+		var parts []string
+		for _, item := range list {
+			parts = append(parts, hoverText(item))
+		}
+		return strings.Join(parts, "\n\n")
+	}
+	var content struct {
+		Kind     string `json:"kind"`
+		Language string `json:"language"`
+		Value    string `json:"value"`
+	}
+	if json.Unmarshal(raw, &content) == nil {
+		if content.Language != "" {
+			return "```" + content.Language + "\n" + content.Value + "\n```"
+		}
+		return content.Value
+	}
+	return ""
+}
+
+// WorkspaceSymbols searches the symbols of the project.
+func (s *LSPServer) WorkspaceSymbols(ctx context.Context, input WorkspaceSymbolInput) (string, error) {
+	if input.Query == "" {
+		return "", errors.New("query is required")
+	}
+	var symbols []struct {
+		Name          string      `json:"name"`
+		Kind          int         `json:"kind"`
+		ContainerName string      `json:"containerName"`
+		Location      lspLocation `json:"location"`
+	}
+	if err := s.client.Call(ctx, "workspace/symbol", map[string]any{"query": input.Query}, &symbols); err != nil {
+		return "", errors.WithStack(err)
+	}
+	if len(symbols) == 0 {
+		return fmt.Sprintf("No symbols found for %s", input.Query), nil
+	}
+	var b strings.Builder
+	for i, sym := range symbols {
+		if i == maxLocations {
+			fmt.Fprintf(&b, "... and %d more symbols\n", len(symbols)-i)
+			break
+		}
+		kind := "symbol"
+		if sym.Kind > 0 && sym.Kind < len(symbolKinds) {
+			kind = symbolKinds[sym.Kind]
+		}
+		name := sym.Name
+		if sym.ContainerName != "" {
+			name = sym.ContainerName + "." + name
+		}
+		fmt.Fprintf(&b, "%s %s %s:%d\n", kind, name, s.display(uriPath(sym.Location.URI)), sym.Location.Range.Start.Line+1)
+	}
+	return b.String(), nil
+}
+
+// parseLocations parses a result of Location, []Location or []LocationLink.
+func parseLocations(raw json.RawMessage) ([]lspLocation, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var locations []lspLocation
+	if strings.HasPrefix(strings.TrimSpace(string(raw)), "{") {
+		var l lspLocation
+		if err := json.Unmarshal(raw, &l); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		locations = append(locations, l)
+	} else if err := json.Unmarshal(raw, &locations); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for i, l := range locations {
+		if l.TargetURI != "" {
+			locations[i].URI, locations[i].Range = l.TargetURI, l.TargetSelectionRange
+		}
+	}
+	return locations, nil
+}
+
+// formatLocations lists locations as path:line:column, each with the source
+// line it points to.
+func (s *LSPServer) formatLocations(locations []lspLocation) string {
+	var b strings.Builder
+	files := map[string][]string{}
+	for i, l := range locations {
+		if i == maxLocations {
+			fmt.Fprintf(&b, "... and %d more\n", len(locations)-i)
+			break
+		}
+		path := uriPath(l.URI)
+		lines, ok := files[path]
+		if !ok {
+			content, _ := os.ReadFile(path)
+			lines = strings.Split(string(content), "\n")
+			files[path] = lines
+		}
+		fmt.Fprintf(&b, "%s:%d:%d", s.display(path), l.Range.Start.Line+1, l.Range.Start.Character+1)
+		if l.Range.Start.Line < len(lines) {
+			fmt.Fprintf(&b, ": %s", strings.TrimSpace(lines[l.Range.Start.Line]))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,408 @@
+package tool
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elek/rai/config"
+	"github.com/elek/rai/llm"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultLSPIdleTimeout is how long an unused language server keeps
+	// running, unless configured otherwise.
+	defaultLSPIdleTimeout = 5 * time.Minute
+	// maxDetectFiles bounds the files looked at to find the languages of a
+	// workspace.
+	maxDetectFiles = 10000
+)
+
+// defaultLanguageServers are the servers used for the common languages. The
+// names are the ones of the LSP server registry.
+var defaultLanguageServers = []config.LanguageServer{
+	{Name: "gopls", Extensions: []string{".go"}, RootMarkers: []string{"go.work", "go.mod"}},
+	{Name: "rust_analyzer", Extensions: []string{".rs"}, RootMarkers: []string{"Cargo.toml"}},
+	{Name: "pyright", Extensions: []string{".py"}, RootMarkers: []string{"pyproject.toml", "setup.py", "setup.cfg", "requirements.txt"}},
+	{Name: "ts_ls", Extensions: []string{".ts", ".tsx", ".js", ".jsx"}, RootMarkers: []string{"tsconfig.json", "jsconfig.json", "package.json"}},
+	{Name: "clangd", Extensions: []string{".c", ".h", ".cc", ".cpp", ".hpp"}, RootMarkers: []string{"compile_commands.json", "CMakeLists.txt"}},
+	{Name: "jdtls", Extensions: []string{".java"}, RootMarkers: []string{"pom.xml", "build.gradle", "build.gradle.kts"}},
+}
+
+// LSPRouter serves the LSP tools with several language servers: the server of
+// a file is selected by its extension and started on demand in the project
+// root of the file, and servers that are not used for a while are stopped.
+type LSPRouter struct {
+	root    string
+	servers []config.LanguageServer
+	idle    time.Duration
+	// start starts the named server in a project root.
+	start func(ctx context.Context, name, root string) (*LSPServer, error)
+
+	mu      sync.Mutex
+	running map[lspKey]*routedServer
+	closed  bool
+	done    chan struct{}
+}
+
+// lspKey identifies a running server: a server is started once per project.
+type lspKey struct {
+	name, root string
+}
+
+type routedServer struct {
+	// ready is closed when the server is started, or failed to start.
+	ready    chan struct{}
+	server   *LSPServer
+	err      error
+	users    int
+	lastUsed time.Time
+}
+
+// NewLSPRouterTools returns the LSP tools backed by the configured (and the
+// default) language servers, and the function that stops the servers.
+func NewLSPRouterTools(root string, cfg config.LSP) ([]llm.Tool, func()) {
+	r := newLSPRouter(root, cfg, NewLSPServer)
+	return lspAgentTools(r), r.Close
+}
+
+func newLSPRouter(root string, cfg config.LSP, start func(ctx context.Context, name, root string) (*LSPServer, error)) *LSPRouter {
+	abs, _ := filepath.Abs(root)
+	r := &LSPRouter{
+		root:    abs,
+		servers: append(append([]config.LanguageServer{}, cfg.Servers...), defaultLanguageServers...),
+		idle:    cfg.IdleTimeout,
+		start:   start,
+		running: map[lspKey]*routedServer{},
+		done:    make(chan struct{}),
+	}
+	if r.idle <= 0 {
+		r.idle = defaultLSPIdleTimeout
+	}
+	go r.stopIdleServers()
+	return r
+}
+
+// Close stops all servers.
+func (r *LSPRouter) Close() {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.closed = true
+	close(r.done)
+	running := r.running
+	r.running = map[lspKey]*routedServer{}
+	r.mu.Unlock()
+
+	for _, rs := range running {
+		<-rs.ready
+		if rs.server != nil {
+			rs.server.Close()
+		}
+	}
+}
+
+// stopIdleServers stops the servers that were not used for the idle timeout,
+// until the router is closed.
+func (r *LSPRouter) stopIdleServers() {
+	ticker := time.NewTicker(r.idle / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+		var idle []*LSPServer
+		r.mu.Lock()
+		for key, rs := range r.running {
+			if rs.server != nil && rs.users == 0 && time.Since(rs.lastUsed) >= r.idle {
+				idle = append(idle, rs.server)
+				delete(r.running, key)
+			}
+		}
+		r.mu.Unlock()
+		for _, s := range idle {
+			s.Close()
+		}
+	}
+}
+
+// languageServer returns the server configured for the extension of path.
+func (r *LSPRouter) languageServer(path string) (config.LanguageServer, bool) {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, ls := range r.servers {
+		for _, e := range ls.Extensions {
+			if strings.ToLower(e) == ext {
+				return ls, true
+			}
+		}
+	}
+	return config.LanguageServer{}, false
+}
+
+// top returns the directory above which project roots are not searched: the
+// workspace of ctx, or the root of the router.
+func (r *LSPRouter) top(ctx context.Context) string {
+	if ws := WorkspaceFrom(ctx); ws != nil {
+		return ws.Root
+	}
+	return r.root
+}
+
+// acquire returns the server of the file at the absolute path, starting it if
+// needed, and the function to call when it is no longer used.
+func (r *LSPRouter) acquire(ctx context.Context, path string) (*LSPServer, func(), error) {
+	ls, ok := r.languageServer(path)
+	if !ok {
+		return nil, nil, errors.Errorf("no language server is configured for %s", filepath.Base(path))
+	}
+	return r.use(ctx, lspKey{name: ls.Name, root: projectRoot(path, ls.RootMarkers, r.top(ctx))})
+}
+
+func (r *LSPRouter) use(ctx context.Context, key lspKey) (*LSPServer, func(), error) {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil, nil, errors.New("the language servers are stopped")
+	}
+	rs, running := r.running[key]
+	if !running {
+		rs = &routedServer{ready: make(chan struct{})}
+		r.running[key] = rs
+	}
+	rs.users++
+	r.mu.Unlock()
+
+	release := func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		rs.users--
+		rs.lastUsed = time.Now()
+	}
+	if !running {
+		// The server outlives the tool call that starts it.
+		server, err := r.start(context.WithoutCancel(ctx), key.name, key.root)
+		if server != nil {
+			server.base = r.top(ctx)
+		}
+		r.mu.Lock()
+		rs.server, rs.err = server, err
+		if err != nil && r.running[key] == rs {
+			delete(r.running, key)
+		}
+		r.mu.Unlock()
+		close(rs.ready)
+	}
+	select {
+	case <-rs.ready:
+	case <-ctx.Done():
+		release()
+		return nil, nil, errors.WithStack(ctx.Err())
+	}
+	if rs.err != nil {
+		release()
+		return nil, nil, errors.Wrapf(rs.err, "error starting the language server %s in %s", key.name, key.root)
+	}
+	return rs.server, release, nil
+}
+
+// projectRoot returns the closest directory above path with one of the root
+// markers, looking no higher than top when path is inside it. Without a
+// marker, it is top, or the directory of path when that is outside of top.
+func projectRoot(path string, markers []string, top string) string {
+	dir := filepath.Dir(path)
+	inside := dir == top || strings.HasPrefix(dir, strings.TrimSuffix(top, string(filepath.Separator))+string(filepath.Separator))
+	for d := dir; ; d = filepath.Dir(d) {
+		for _, m := range markers {
+			if _, err := os.Stat(filepath.Join(d, m)); err == nil {
+				return d
+			}
+		}
+		if (inside && d == top) || filepath.Dir(d) == d {
+			break
+		}
+	}
+	if inside {
+		return top
+	}
+	return dir
+}
+
+// detectLanguages returns the servers of the languages used in the files of
+// top, each with the first file found for it.
+func (r *LSPRouter) detectLanguages(top string) map[string]string {
+	found := map[string]string{}
+	ig := newIgnorer(top)
+	files := 0
+	_ = filepath.WalkDir(top, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if ig.visit(path, d) || (path != top && strings.HasPrefix(d.Name(), ".")) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if ls, ok := r.languageServer(path); ok {
+			if _, seen := found[ls.Name]; !seen {
+				found[ls.Name] = path
+			}
+		}
+		files++
+		if files >= maxDetectFiles {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return found
+}
+
+// Symbols lists the symbols of files, each with the server of its language.
+func (r *LSPRouter) Symbols(ctx context.Context, input SymbolInput) (string, error) {
+	var b strings.Builder
+	for _, p := range input.Paths {
+		out, err := r.forFile(ctx, p, func(s *LSPServer) (string, error) {
+			return s.Symbols(ctx, SymbolInput{Paths: []string{p}})
+		})
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(out)
+	}
+	return b.String(), nil
+}
+
+// forFile calls fn with the server of the file at path, a path given to a
+// tool.
+func (r *LSPRouter) forFile(ctx context.Context, path string, fn func(s *LSPServer) (string, error)) (string, error) {
+	abs, err := absPath(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	s, release, err := r.acquire(ctx, abs)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return fn(s)
+}
+
+func (r *LSPRouter) Definition(ctx context.Context, input PositionInput) (string, error) {
+	return r.forFile(ctx, input.Path, func(s *LSPServer) (string, error) { return s.Definition(ctx, input) })
+}
+
+func (r *LSPRouter) References(ctx context.Context, input PositionInput) (string, error) {
+	return r.forFile(ctx, input.Path, func(s *LSPServer) (string, error) { return s.References(ctx, input) })
+}
+
+func (r *LSPRouter) Hover(ctx context.Context, input PositionInput) (string, error) {
+	return r.forFile(ctx, input.Path, func(s *LSPServer) (string, error) { return s.Hover(ctx, input) })
+}
+
+func (r *LSPRouter) Rename(ctx context.Context, input RenameInput) (string, error) {
+	return r.forFile(ctx, input.Path, func(s *LSPServer) (string, error) { return s.Rename(ctx, input) })
+}
+
+func (r *LSPRouter) CodeAction(ctx context.Context, input CodeActionInput) (string, error) {
+	return r.forFile(ctx, input.Path, func(s *LSPServer) (string, error) { return s.CodeAction(ctx, input) })
+}
+
+// WorkspaceSymbols searches the symbols with the servers of every language
+// used in the workspace.
+func (r *LSPRouter) WorkspaceSymbols(ctx context.Context, input WorkspaceSymbolInput) (string, error) {
+	if input.Query == "" {
+		return "", errors.New("query is required")
+	}
+	languages := r.detectLanguages(r.top(ctx))
+	if len(languages) == 0 {
+		return "", errors.New("there are no source files with a configured language server in the workspace")
+	}
+	names := make([]string, 0, len(languages))
+	for name := range languages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var results []string
+	for _, name := range names {
+		out, err := r.forFile(ctx, languages[name], func(s *LSPServer) (string, error) { return s.WorkspaceSymbols(ctx, input) })
+		if err != nil {
+			return "", err
+		}
+		if !strings.HasPrefix(out, "No symbols found") {
+			results = append(results, out)
+		}
+	}
+	if len(results) == 0 {
+		return "No symbols found for " + input.Query, nil
+	}
+	return strings.Join(results, ""), nil
+}
+
+// Diagnostics returns the problems of files, asking the server of each. With
+// no paths, it reports the problems known by the running servers.
+func (r *LSPRouter) Diagnostics(ctx context.Context, input DiagnosticsInput) (string, error) {
+	var servers []*LSPServer
+	paths := map[*LSPServer][]string{}
+	for _, p := range input.Paths {
+		abs, err := absPath(ctx, p)
+		if err != nil {
+			return "", err
+		}
+		s, release, err := r.acquire(ctx, abs)
+		if err != nil {
+			return "", err
+		}
+		defer release()
+		if _, ok := paths[s]; !ok {
+			servers = append(servers, s)
+		}
+		paths[s] = append(paths[s], abs)
+	}
+	if len(input.Paths) == 0 {
+		r.mu.Lock()
+		keys := make([]lspKey, 0, len(r.running))
+		for key, rs := range r.running {
+			if rs.server != nil {
+				keys = append(keys, key)
+			}
+		}
+		r.mu.Unlock()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].name+keys[i].root < keys[j].name+keys[j].root })
+		for _, key := range keys {
+			s, release, err := r.use(ctx, key)
+			if err != nil {
+				continue
+			}
+			defer release()
+			servers = append(servers, s)
+		}
+	}
+
+	var results []string
+	for _, s := range servers {
+		out, err := s.Diagnostics(ctx, DiagnosticsInput{Paths: paths[s]})
+		if err != nil {
+			return "", err
+		}
+		if out != "No problems found" {
+			results = append(results, out)
+		}
+	}
+	if len(results) == 0 {
+		return "No problems found", nil
+	}
+	return strings.Join(results, ""), nil
+}
@@ -0,0 +1,139 @@
+package tool
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elek/rai/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServers starts fake language servers, and remembers them by name and
+// root.
+type fakeServers struct {
+	mu      sync.Mutex
+	clients map[string]*fakeLSPClient
+}
+
+func (f *fakeServers) start(_ context.Context, name, root string) (*LSPServer, error) {
+	if name == "broken" {
+		return nil, fmt.Errorf("%s is not installed", name)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	client := &fakeLSPClient{results: map[string]string{
+		"textDocument/hover": fmt.Sprintf(`{"contents":"%s in %s"}`, name, filepath.Base(root)),
+		"workspace/symbol":   fmt.Sprintf(`[{"name":"%s","kind":12,"location":{"uri":"%s","range":{"start":{"line":0}}}}]`, name, fileURI(filepath.Join(root, "x"))),
+	}}
+	f.clients[name+" "+filepath.Base(root)] = client
+	return newLSPServer(client, root), nil
+}
+
+func (f *fakeServers) started() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	for name := range f.clients {
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestLSPRouterSelectsServers(t *testing.T) {
+	root := newFileTree(t,
+		"go.work=go 1.22",
+		"api/go.mod=module api",
+		"api/api.go=package api",
+		"api/internal/x.go=package internal",
+		"cli/go.mod=module cli",
+		"cli/main.go=package main",
+		"tools/gen.py=print(1)",
+		"README.md=# readme",
+	)
+	servers := &fakeServers{clients: map[string]*fakeLSPClient{}}
+	r := newLSPRouter(root, config.LSP{}, servers.start)
+	defer r.Close()
+	ws, err := NewWorkspace(root, false)
+	require.NoError(t, err)
+	ctx := ContextWithWorkspace(context.Background(), ws)
+
+	hover := func(path string) string {
+		out, err := r.Hover(ctx, PositionInput{Path: path, Line: 1, Symbol: ""})
+		require.NoError(t, err)
+		return out
+	}
+	assert.Equal(t, "gopls in api", hover("api/api.go"))
+	assert.Equal(t, "gopls in api", hover("api/internal/x.go"), "the server of the module is reused")
+	assert.Equal(t, "gopls in cli", hover("cli/main.go"))
+	assert.Equal(t, "pyright in "+filepath.Base(root), hover("tools/gen.py"), "without a root marker the workspace is the root")
+	assert.ElementsMatch(t, []string{"gopls api", "gopls cli", "pyright " + filepath.Base(root)}, servers.started())
+
+	_, err = r.Hover(ctx, PositionInput{Path: "README.md", Line: 1})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no language server is configured for README.md")
+
+	r.servers = append([]config.LanguageServer{{Name: "broken", Extensions: []string{".md"}}}, r.servers...)
+	_, err = r.Hover(ctx, PositionInput{Path: "README.md", Line: 1})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error starting the language server broken")
+}
+
+func TestLSPRouterWorkspaceSymbols(t *testing.T) {
+	root := newFileTree(t,
+		"go.mod=module x",
+		"main.go=package main",
+		"vendor/lib.py=print(1)",
+		".gitignore=vendor/",
+	)
+	servers := &fakeServers{clients: map[string]*fakeLSPClient{}}
+	r := newLSPRouter(root, config.LSP{}, servers.start)
+	defer r.Close()
+
+	out, err := r.WorkspaceSymbols(context.Background(), WorkspaceSymbolInput{Query: "x"})
+	require.NoError(t, err)
+	assert.Equal(t, "function gopls x:1\n", out)
+	assert.Equal(t, []string{"gopls " + filepath.Base(root)}, servers.started(), "only the servers of the languages in use are started")
+}
+
+func TestLSPRouterStopsIdleServers(t *testing.T) {
+	root := newFileTree(t, "main.go=package main")
+	servers := &fakeServers{clients: map[string]*fakeLSPClient{}}
+	r := newLSPRouter(root, config.LSP{IdleTimeout: 50 * time.Millisecond}, servers.start)
+	defer r.Close()
+
+	_, err := r.Hover(context.Background(), PositionInput{Path: filepath.Join(root, "main.go"), Line: 1})
+	require.NoError(t, err)
+	client := servers.clients["gopls "+filepath.Base(root)]
+	require.Eventually(t, func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return client.stopped
+	}, 5*time.Second, 10*time.Millisecond)
+
+	r.mu.Lock()
+	assert.Empty(t, r.running)
+	r.mu.Unlock()
+
+	// The next call starts the server again.
+	_, err = r.Hover(context.Background(), PositionInput{Path: filepath.Join(root, "main.go"), Line: 1})
+	require.NoError(t, err)
+	assert.NotSame(t, client, servers.clients["gopls "+filepath.Base(root)])
+}
+
+func TestProjectRoot(t *testing.T) {
+	root := newFileTree(t, "a/go.mod=module a", "a/b/c.go=package b")
+	markers := []string{"go.mod"}
+	assert.Equal(t, filepath.Join(root, "a"), projectRoot(filepath.Join(root, "a", "b", "c.go"), markers, root))
+	assert.Equal(t, root, projectRoot(filepath.Join(root, "d.go"), markers, root))
+	assert.Equal(t, filepath.Join(root, "a", "b"), projectRoot(filepath.Join(root, "a", "b", "c.go"), markers, filepath.Join(root, "a", "b")),
+		"a marker above the top is not used")
+
+	outside := filepath.Join(os.TempDir(), "elsewhere", "e.go")
+	assert.Equal(t, filepath.Dir(outside), projectRoot(outside, markers, root))
+}
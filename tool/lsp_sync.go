@@ -0,0 +1,287 @@
+package tool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// diagnosticsWait is how long the diagnostics tool waits for the server to
+// publish the diagnostics of a file it just synced.
+const diagnosticsWait = 5 * time.Second
+
+var (
+	watchersMu sync.Mutex
+	watchers   = map[int]func(path string){}
+	nextWatch  int
+)
+
+// watchFiles calls fn with the absolute path of every file the file tools
+// change, create or delete, until the returned function is called.
+func watchFiles(fn func(path string)) func() {
+	watchersMu.Lock()
+	defer watchersMu.Unlock()
+	nextWatch++
+	id := nextWatch
+	watchers[id] = fn
+	return func() {
+		watchersMu.Lock()
+		defer watchersMu.Unlock()
+		delete(watchers, id)
+	}
+}
+
+// fileChanged tells the watchers that the file at path was written or deleted.
+func fileChanged(path string) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return
+	}
+	watchersMu.Lock()
+	fns := make([]func(string), 0, len(watchers))
+	for _, fn := range watchers {
+		fns = append(fns, fn)
+	}
+	watchersMu.Unlock()
+	for _, fn := range fns {
+		fn(abs)
+	}
+}
+
+// lspDiagnostic is a problem the language server reported in a file.
+type lspDiagnostic struct {
+	Range    lspRange        `json:"range"`
+	Severity int             `json:"severity"`
+	Code     json.RawMessage `json:"code"`
+	Source   string          `json:"source"`
+	Message  string          `json:"message"`
+}
+
+var severities = []string{"", "error", "warning", "info", "hint"}
+
+// publishDiagnostics handles the textDocument/publishDiagnostics notification.
+func (s *LSPServer) publishDiagnostics(_ context.Context, _ string, params json.RawMessage) {
+	var p struct {
+		URI         string          `json:"uri"`
+		Diagnostics []lspDiagnostic `json:"diagnostics"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.diagnostics[p.URI] = p.Diagnostics
+	delete(s.stale, p.URI)
+	close(s.publish)
+	s.publish = make(chan struct{})
+}
+
+// sync sends the current content of the file at the absolute path to the
+// server: didOpen the first time, didChange when it changed since, and
+// didClose when it was deleted.
+func (s *LSPServer) sync(ctx context.Context, path string) error {
+	// Syncs are serialized so the versions reach the server in order; mu
+	// is not held while notifying, as the server may publish meanwhile.
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	uri := fileURI(path)
+	content, err := os.ReadFile(path)
+	s.mu.Lock()
+	version, open := s.versions[uri]
+	unchanged := open && s.texts[uri] == string(content)
+	s.mu.Unlock()
+
+	var method string
+	var params map[string]any
+	switch {
+	case os.IsNotExist(err):
+		if !open {
+			return nil
+		}
+		s.mu.Lock()
+		delete(s.versions, uri)
+		delete(s.texts, uri)
+		delete(s.diagnostics, uri)
+		delete(s.stale, uri)
+		s.mu.Unlock()
+		method, params = "textDocument/didClose", map[string]any{
+			"textDocument": map[string]any{"uri": uri},
+		}
+	case err != nil:
+		return errors.WithStack(err)
+	case unchanged:
+		return nil
+	case !open:
+		method, params = "textDocument/didOpen", map[string]any{
+			"textDocument": map[string]any{
+				"uri":        uri,
+				"languageId": languageID(path),
+				"version":    1,
+				"text":       string(content),
+			},
+		}
+		version = 1
+	default:
+		version++
+		method, params = "textDocument/didChange", map[string]any{
+			"textDocument":   map[string]any{"uri": uri, "version": version},
+			"contentChanges": []map[string]any{{"text": string(content)}},
+		}
+	}
+	if err == nil {
+		s.mu.Lock()
+		s.versions[uri] = version
+		s.texts[uri] = string(content)
+		s.stale[uri] = true
+		s.mu.Unlock()
+	}
+	return errors.WithStack(s.client.Notify(ctx, method, params))
+}
+
+// fileChanged syncs a file changed by the file tools, if the server has it
+// open. Other files are read from the disk by the server when it needs them.
+func (s *LSPServer) fileChanged(path string) {
+	s.mu.Lock()
+	_, open := s.versions[fileURI(path)]
+	s.mu.Unlock()
+	if !open {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticsWait)
+	defer cancel()
+	_ = s.sync(ctx, path)
+}
+
+// DiagnosticsInput defines the input of the diagnostics tool.
+type DiagnosticsInput struct {
+	Paths []string `json:"paths" description:"Paths of the source files to check; empty reports the problems of all files opened so far"`
+}
+
+// Diagnostics returns the errors and warnings the server reports for files.
+// The files are synced from the disk first, and the server is given some time
+// to check them.
+func (s *LSPServer) Diagnostics(ctx context.Context, input DiagnosticsInput) (string, error) {
+	var uris []string
+	for _, p := range input.Paths {
+		path, err := absPath(ctx, p)
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(path); err != nil {
+			return "", errors.WithStack(err)
+		}
+		if err := s.sync(ctx, path); err != nil {
+			return "", err
+		}
+		uri := fileURI(path)
+		s.waitDiagnostics(ctx, uri)
+		uris = append(uris, uri)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(input.Paths) == 0 {
+		for uri := range s.diagnostics {
+			uris = append(uris, uri)
+		}
+		sort.Strings(uris)
+	}
+	var b strings.Builder
+	problems := 0
+	for _, uri := range uris {
+		for _, d := range s.diagnostics[uri] {
+			problems++
+			severity := "error"
+			if d.Severity > 0 && d.Severity < len(severities) {
+				severity = severities[d.Severity]
+			}
+			fmt.Fprintf(&b, "%s:%d:%d: %s: %s", s.display(uriPath(uri)), d.Range.Start.Line+1, d.Range.Start.Character+1, severity, d.Message)
+			if d.Source != "" {
+				fmt.Fprintf(&b, " (%s)", d.Source)
+			}
+			b.WriteString("\n")
+		}
+	}
+	if problems == 0 {
+		return "No problems found", nil
+	}
+	return b.String(), nil
+}
+
+// waitDiagnostics waits until the server published diagnostics for the
+// current content of uri, or diagnosticsWait passes.
+func (s *LSPServer) waitDiagnostics(ctx context.Context, uri string) {
+	timer := time.NewTimer(diagnosticsWait)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		_, known := s.diagnostics[uri]
+		current, publish := known && !s.stale[uri], s.publish
+		s.mu.Unlock()
+		if current {
+			return
+		}
+		select {
+		case <-publish:
+		case <-timer.C:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// fileURI returns the file:// URI of an absolute path.
+func fileURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// uriPath returns the path of a file:// URI.
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+var languageIDs = map[string]string{
+	".go":   "go",
+	".py":   "python",
+	".rs":   "rust",
+	".js":   "javascript",
+	".jsx":  "javascriptreact",
+	".ts":   "typescript",
+	".tsx":  "typescriptreact",
+	".java": "java",
+	".c":    "c",
+	".h":    "c",
+	".cc":   "cpp",
+	".cpp":  "cpp",
+	".hpp":  "cpp",
+	".rb":   "ruby",
+	".sh":   "shellscript",
+	".json": "json",
+	".yaml": "yaml",
+	".yml":  "yaml",
+	".md":   "markdown",
+}
+
+// languageID returns the LSP language identifier of a file.
+func languageID(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if id, ok := languageIDs[ext]; ok {
+		return id
+	}
+	return strings.TrimPrefix(ext, ".")
+}
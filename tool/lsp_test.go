@@ -1,9 +1,17 @@
 package tool
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/elek/lspc/powernap/pkg/lsp/protocol"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -11,7 +19,264 @@ func TestLspSymbols(t *testing.T) {
 	ctx := t.Context()
 	lsp, err := NewLSPServer(ctx, "gopls", ".")
 	require.NoError(t, err)
-	res, err := lsp.Symbols(SymbolInput{Paths: []string{"tool/lsp.go"}})
+	res, err := lsp.Symbols(ctx, SymbolInput{Paths: []string{"tool/lsp.go"}})
 	require.NoError(t, err)
 	fmt.Println(res)
 }
+
+// fakeLSPClient answers calls with canned results and records notifications.
+type fakeLSPClient struct {
+	mu      sync.Mutex
+	results map[string]string
+	calls   []string
+	params  []any
+	notes   []string
+	stopped bool
+	// onNotify, when set, is called with every notification.
+	onNotify func(method string, params any)
+}
+
+func (f *fakeLSPClient) Shutdown(context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopped = true
+	return nil
+}
+
+func (f *fakeLSPClient) DocumentSymbols(context.Context, string) ([]protocol.DocumentSymbol, error) {
+	return nil, nil
+}
+
+func (f *fakeLSPClient) Call(_ context.Context, method string, params any, result any) error {
+	f.mu.Lock()
+	f.calls = append(f.calls, method)
+	f.params = append(f.params, params)
+	res, ok := f.results[method]
+	f.mu.Unlock()
+	if !ok {
+		res = "null"
+	}
+	return json.Unmarshal([]byte(res), result)
+}
+
+func (f *fakeLSPClient) Notify(_ context.Context, method string, params any) error {
+	f.mu.Lock()
+	f.notes = append(f.notes, method)
+	f.mu.Unlock()
+	if f.onNotify != nil {
+		f.onNotify(method, params)
+	}
+	return nil
+}
+
+func (f *fakeLSPClient) notifications() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.notes...)
+}
+
+// newFakeLSPServer returns a server for a project holding main.go.
+func newFakeLSPServer(t *testing.T, results map[string]string) (*LSPServer, *fakeLSPClient, string) {
+	t.Helper()
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n\nfunc main() {\n\tfmt.Println(helper(1))\n}\n"), 0o644))
+	client := &fakeLSPClient{results: results}
+	s := newLSPServer(client, root)
+	t.Cleanup(s.Close)
+	return s, client, root
+}
+
+func TestLSPNavigation(t *testing.T) {
+	s, client, root := newFakeLSPServer(t, nil)
+	ws, err := NewWorkspace(root, false)
+	require.NoError(t, err)
+	ctx := ContextWithWorkspace(context.Background(), ws)
+	uri := fileURI(filepath.Join(root, "main.go"))
+
+	client.results = map[string]string{
+		"textDocument/definition": `[{"targetUri":"` + uri + `","targetSelectionRange":{"start":{"line":2,"character":5},"end":{"line":2,"character":9}}}]`,
+		"textDocument/references": `[{"uri":"` + uri + `","range":{"start":{"line":3,"character":13}}},{"uri":"` + uri + `","range":{"start":{"line":2,"character":5}}}]`,
+		"textDocument/hover":      `{"contents":{"kind":"markdown","value":"func helper(n int) int"}}`,
+		"workspace/symbol":        `[{"name":"helper","kind":12,"location":{"uri":"` + uri + `","range":{"start":{"line":6}}}}]`,
+	}
+
+	out, err := s.Definition(ctx, PositionInput{Path: "main.go", Line: 4, Symbol: "helper"})
+	require.NoError(t, err)
+	assert.Equal(t, "main.go:3:6: func main() {\n", out)
+	assert.Equal(t, map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"position":     lspPosition{Line: 3, Character: 13},
+	}, client.params[0])
+	assert.Equal(t, []string{"textDocument/didOpen"}, client.notifications(), "the file is opened before the first request")
+
+	out, err = s.References(ctx, PositionInput{Path: "main.go", Line: 4, Symbol: "helper"})
+	require.NoError(t, err)
+	assert.Equal(t, "2 references:\nmain.go:4:14: fmt.Println(helper(1))\nmain.go:3:6: func main() {\n", out)
+
+	out, err = s.Hover(ctx, PositionInput{Path: "main.go", Line: 4, Symbol: "helper"})
+	require.NoError(t, err)
+	assert.Equal(t, "func helper(n int) int", out)
+
+	out, err = s.WorkspaceSymbols(ctx, WorkspaceSymbolInput{Query: "help"})
+	require.NoError(t, err)
+	assert.Equal(t, "function helper main.go:7\n", out)
+
+	_, err = s.Definition(ctx, PositionInput{Path: "main.go", Line: 4, Symbol: "missing"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `symbol "missing" is not on line 4`)
+
+	_, err = s.Definition(ctx, PositionInput{Path: "../x.go", Line: 1})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "outside of the workspace")
+}
+
+func TestLSPDiagnosticsFollowEdits(t *testing.T) {
+	s, client, root := newFakeLSPServer(t, nil)
+	path := filepath.Join(root, "main.go")
+	// The fake server reports one problem per version it got.
+	client.onNotify = func(method string, params any) {
+		if method == "textDocument/didClose" {
+			return
+		}
+		doc := params.(map[string]any)["textDocument"].(map[string]any)
+		go s.publishDiagnostics(context.Background(), "", json.RawMessage(fmt.Sprintf(
+			`{"uri":%q,"diagnostics":[{"range":{"start":{"line":3,"character":1}},"severity":1,"source":"compiler","message":"problem in version %v"}]}`,
+			doc["uri"], doc["version"])))
+	}
+
+	out, err := s.Diagnostics(context.Background(), DiagnosticsInput{Paths: []string{path}})
+	require.NoError(t, err)
+	assert.Equal(t, "main.go:4:2: error: problem in version 1 (compiler)\n", out)
+
+	_, err = Edit(EditInput{Path: path, OldStr: "helper(1)", NewStr: "helper(2)"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"textDocument/didOpen", "textDocument/didChange"}, client.notifications())
+
+	out, err = s.Diagnostics(context.Background(), DiagnosticsInput{Paths: []string{path}})
+	require.NoError(t, err)
+	assert.Equal(t, "main.go:4:2: error: problem in version 2 (compiler)\n", out)
+	assert.Len(t, client.notifications(), 2, "an unchanged file is not sent again")
+
+	require.NoError(t, os.Remove(path))
+	fileChanged(path)
+	assert.Equal(t, "textDocument/didClose", client.notifications()[2])
+}
+
+func TestSymbolColumn(t *testing.T) {
+	assert.Equal(t, 14, symbolColumn("\tx := helpers(helper(1))", "helper"))
+	assert.Equal(t, 1, symbolColumn("\treturn", ""))
+	assert.Equal(t, 5, symbolColumn("func fooBar()", "foo"), "a partial match is used when there is no whole one")
+	assert.Equal(t, -1, symbolColumn("func main()", "helper"))
+	assert.Equal(t, 4, utf16Len("héé𝄞"[:len("héé𝄞")-len("𝄞")])+1)
+}
+
+func TestLSPRename(t *testing.T) {
+	s, client, root := newFakeLSPServer(t, nil)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "util.go"), []byte("package main\n\nfunc helper(n int) int { return n }\n"), 0o644))
+	ws, err := NewWorkspace(root, false)
+	require.NoError(t, err)
+	ctx := ContextWithWorkspace(context.Background(), ws)
+	mainURI, utilURI := fileURI(filepath.Join(root, "main.go")), fileURI(filepath.Join(root, "util.go"))
+	client.results = map[string]string{
+		"textDocument/rename": `{"changes":{
+			"` + mainURI + `":[{"range":{"start":{"line":3,"character":13},"end":{"line":3,"character":19}},"newText":"twice"}],
+			"` + utilURI + `":[{"range":{"start":{"line":2,"character":5},"end":{"line":2,"character":11}},"newText":"twice"}]}}`,
+	}
+
+	out, err := s.Rename(ctx, RenameInput{Path: "main.go", Line: 4, Symbol: "helper", NewName: "twice"})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(out, "Renamed helper to twice in 2 files:\nM main.go (+1 -1)\nM util.go (+1 -1)\n--- main.go\n"), out)
+	assert.Equal(t, "package main\n\nfunc main() {\n\tfmt.Println(twice(1))\n}\n", readTestFile(t, filepath.Join(root, "main.go")))
+	assert.Equal(t, "package main\n\nfunc twice(n int) int { return n }\n", readTestFile(t, filepath.Join(root, "util.go")))
+	assert.Equal(t, []string{"textDocument/didOpen", "textDocument/didChange"}, client.notifications(), "only the open files are synced")
+}
+
+func TestLSPCodeAction(t *testing.T) {
+	s, client, root := newFakeLSPServer(t, nil)
+	ctx := context.Background()
+	path := filepath.Join(root, "main.go")
+	uri := fileURI(path)
+	client.onNotify = func(method string, params any) {
+		go s.publishDiagnostics(ctx, "", json.RawMessage(`{"uri":"`+uri+`","diagnostics":[{"range":{"start":{"line":3,"character":1},"end":{"line":3,"character":4}},"severity":1,"message":"undefined: fmt"}]}`))
+	}
+	client.results = map[string]string{
+		"textDocument/codeAction": `[
+			{"title":"Add import: \"fmt\"","kind":"quickfix","edit":{"documentChanges":[{"textDocument":{"uri":"` + uri + `","version":1},
+				"edits":[{"range":{"start":{"line":1,"character":0},"end":{"line":1,"character":0}},"newText":"\nimport \"fmt\"\n"}]}]}},
+			{"title":"Organize Imports","kind":"source.organizeImports","data":{"id":1}},
+			{"title":"Run tests","command":"test.run"}]`,
+		"codeAction/resolve": `{"title":"Organize Imports","edit":{"changes":{"` + uri + `":[{"range":{"start":{"line":5,"character":0},"end":{"line":6,"character":0}},"newText":""}]}}}`,
+	}
+
+	out, err := s.CodeAction(ctx, CodeActionInput{Path: path, Line: 4})
+	require.NoError(t, err)
+	assert.Equal(t, "3 code actions:\n- [quickfix] Add import: \"fmt\"\n- [source.organizeImports] Organize Imports\n- Run tests\nCall code-action again with the title of the action to apply.", out)
+	params := client.params[len(client.params)-1].(map[string]any)
+	assert.Len(t, params["context"].(map[string]any)["diagnostics"], 1, "the diagnostics of the line are sent along")
+
+	_, err = s.CodeAction(ctx, CodeActionInput{Path: path, Title: "import"})
+	require.Error(t, err, "two titles contain it")
+	assert.Contains(t, err.Error(), "matches 2 code actions")
+
+	out, err = s.CodeAction(ctx, CodeActionInput{Path: path, Title: "add import"})
+	require.NoError(t, err)
+	assert.Contains(t, out, "Applied \"Add import: \\\"fmt\\\"\" to 1 files:\nM main.go (+2 -0)\n")
+	assert.Equal(t, "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(helper(1))\n}\n", readTestFile(t, path))
+
+	_, err = s.CodeAction(ctx, CodeActionInput{Path: path, Title: "Organize Imports"})
+	require.NoError(t, err)
+	assert.Equal(t, "package main\n\nimport \"fmt\"\n\nfunc main() {\n}\n", readTestFile(t, path), "the edit of a resolved action is applied")
+
+	_, err = s.CodeAction(ctx, CodeActionInput{Path: path, Title: "Run tests"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "runs the server command test.run, which is not supported")
+}
+
+func TestApplyWorkspaceEditIsAtomic(t *testing.T) {
+	s, _, root := newFakeLSPServer(t, nil)
+	ws, err := NewWorkspace(root, false)
+	require.NoError(t, err)
+	ctx := ContextWithWorkspace(context.Background(), ws)
+	var edit lspWorkspaceEdit
+	require.NoError(t, json.Unmarshal([]byte(`{"documentChanges":[
+		{"textDocument":{"uri":"`+fileURI(filepath.Join(root, "main.go"))+`"},"edits":[{"range":{"start":{"line":0,"character":8},"end":{"line":0,"character":12}},"newText":"app"}]},
+		{"kind":"rename","oldUri":"`+fileURI(filepath.Join(root, "main.go"))+`","newUri":"`+fileURI(filepath.Join(root, "app.go"))+`"},
+		{"kind":"create","uri":"`+fileURI(filepath.Join(root, "app.go"))+`"}]}`), &edit))
+
+	_, err = s.applyWorkspaceEdit(ctx, &edit)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "app.go: can't create the file, it already exists")
+	assert.Contains(t, readTestFile(t, filepath.Join(root, "main.go")), "package main\n", "nothing is written")
+
+	edit.DocumentChanges = edit.DocumentChanges[:2]
+	out, err := s.applyWorkspaceEdit(ctx, &edit)
+	require.NoError(t, err)
+	assert.Equal(t, "1 files:\nR main.go -> app.go (+1 -1)\n", out)
+	assert.NoFileExists(t, filepath.Join(root, "main.go"))
+	assert.True(t, strings.HasPrefix(readTestFile(t, filepath.Join(root, "app.go")), "package app\n"))
+
+	edit.DocumentChanges = edit.DocumentChanges[:0]
+	edit.Changes = map[string][]lspTextEdit{fileURI("/etc/passwd"): {{NewText: "x"}}}
+	_, err = s.applyWorkspaceEdit(ctx, &edit)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "outside of the workspace")
+}
+
+func TestApplyTextEdits(t *testing.T) {
+	text := "héllo 𝄞 world\nsecond\n"
+	out, err := applyTextEdits(text, []lspTextEdit{
+		{Range: lspRange{Start: lspPosition{Line: 1}, End: lspPosition{Line: 1, Character: 6}}, NewText: "2nd"},
+		{Range: lspRange{Start: lspPosition{Character: 9}, End: lspPosition{Character: 14}}, NewText: "there"},
+		{Range: lspRange{Start: lspPosition{Line: 2}, End: lspPosition{Line: 2}}, NewText: "third\n"},
+		{Range: lspRange{Start: lspPosition{Line: 2}, End: lspPosition{Line: 2}}, NewText: "fourth\n"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "héllo 𝄞 there\n2nd\nthird\nfourth\n", out)
+
+	_, err = applyTextEdits(text, []lspTextEdit{
+		{Range: lspRange{End: lspPosition{Character: 5}}},
+		{Range: lspRange{Start: lspPosition{Character: 3}, End: lspPosition{Character: 7}}},
+	})
+	require.Error(t, err)
+}
@@ -3,6 +3,10 @@ package tool
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"os"
 	"os/exec"
 	"strings"
 
@@ -11,31 +15,219 @@ import (
 	"github.com/pkg/errors"
 )
 
+// McpServer describes how to reach an MCP server: a command started as a
+// subprocess speaking over stdin/stdout, or the URL of a remote server.
+type McpServer struct {
+	Command string
+	Args    []string
+	// Env is added to the environment of the command.
+	Env []string
+	// Dir is the working directory of the command, the current one when
+	// empty.
+	Dir string
+
+	URL string
+	// Transport is the protocol of a URL: "streamable" (or "http") for
+	// streamable HTTP, "sse" for the older HTTP with server-sent events, or
+	// empty to try streamable HTTP first and fall back to SSE.
+	Transport string
+	// Headers are sent with every HTTP request, e.g. Authorization.
+	Headers map[string]string
+
+	// Alias prefixes the names of the tools of the server, as alias_name, so
+	// servers with tools of the same name can be used together.
+	Alias string
+	// Allow and Deny are globs of the tool names (without the alias): when
+	// Allow is set, only the tools matching it are offered, and the tools
+	// matching Deny never are.
+	Allow []string
+	Deny  []string
+}
+
+// offers reports whether the tool with the given name is offered, according
+// to Allow and Deny.
+func (s McpServer) offers(name string) bool {
+	for _, glob := range s.Deny {
+		if matchGlob(glob, name) {
+			return false
+		}
+	}
+	if len(s.Allow) == 0 {
+		return true
+	}
+	for _, glob := range s.Allow {
+		if matchGlob(glob, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxToolName is the length limit of tool names of the model providers.
+const maxToolName = 64
+
+// toolName returns the name a tool of the server is offered as.
+func (s McpServer) toolName(name string) string {
+	if s.Alias != "" {
+		name = s.Alias + "_" + name
+	}
+	return CleanToolName(name)
+}
+
+// CleanToolName returns name as the model providers accept it. They only
+// accept letters, digits, _ and - in tool names, so the others are replaced
+// with _, and a name that is too long is cut, keeping a hash of the full name
+// to tell it apart from the others.
+func CleanToolName(name string) string {
+	clean := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+	if len(clean) > maxToolName {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(name))
+		clean = fmt.Sprintf("%s_%08x", clean[:maxToolName-9], h.Sum32())
+	}
+	return clean
+}
+
 func NewMcpAgentTool(ctx context.Context, command string, args []string) ([]llm.Tool, func(), error) {
-	client := mcp.NewClient(&mcp.Implementation{Name: "mcp-client", Version: "v1.0.0"}, nil)
+	return NewMcpServerAgentTool(ctx, McpServer{Command: command, Args: args})
+}
+
+// NewMcpServerAgentTool connects to an MCP server and returns its tools, and
+// the function that closes the connection.
+func NewMcpServerAgentTool(ctx context.Context, server McpServer) ([]llm.Tool, func(), error) {
+	client, err := ConnectMcp(ctx, server)
+	if err != nil {
+		return nil, func() {}, err
+	}
+	agentTools, err := client.Tools(ctx)
+	if err != nil {
+		client.Close()
+		return nil, func() {}, err
+	}
+	return agentTools, client.Close, nil
+}
+
+// McpClient is a connection to an MCP server.
+type McpClient struct {
+	server  McpServer
+	session *mcp.ClientSession
+}
 
-	transport := &mcp.CommandTransport{Command: exec.Command(command, args...)}
-	session, err := client.Connect(ctx, transport, nil)
+// ConnectMcp starts or connects to an MCP server.
+func ConnectMcp(ctx context.Context, server McpServer) (*McpClient, error) {
+	session, err := connectMcp(ctx, server)
 	if err != nil {
-		return nil, func() {}, errors.WithStack(err)
+		return nil, err
 	}
+	return &McpClient{server: server, session: session}, nil
+}
+
+// Close closes the connection, and stops the server started by it.
+func (c *McpClient) Close() {
+	_ = c.session.Close()
+}
 
+// Tools returns the tools of the server, with the list-resources and
+// read-resource tools when the server has resources. Only the tools allowed
+// by the server config are returned, named with its alias.
+func (c *McpClient) Tools(ctx context.Context) ([]llm.Tool, error) {
 	var agentTools []llm.Tool
-	for tool, err := range session.Tools(ctx, &mcp.ListToolsParams{}) {
-		if err != nil {
-			return nil, func() {}, errors.WithStack(err)
+	if caps := c.capabilities(); caps == nil || caps.Tools != nil {
+		for tool, err := range c.session.Tools(ctx, &mcp.ListToolsParams{}) {
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			if !c.server.offers(tool.Name) {
+				continue
+			}
+			agentTool := NewMcpAgentToolMethod(c.session, tool)
+			agentTool.info.Name = c.server.toolName(tool.Name)
+			agentTools = append(agentTools, agentTool)
 		}
+	}
+	if caps := c.capabilities(); caps != nil && caps.Resources != nil {
+		agentTools = append(agentTools, c.resourceTools()...)
+	}
+	return agentTools, nil
+}
 
-		agentTools = append(agentTools, NewMcpAgentToolMethod(session, tool))
+// capabilities returns what the server declared to support.
+func (c *McpClient) capabilities() *mcp.ServerCapabilities {
+	if init := c.session.InitializeResult(); init != nil {
+		return init.Capabilities
 	}
-	return agentTools, func() {
-		_ = session.Close()
-	}, nil
+	return nil
+}
+
+// connectMcp starts or connects to the server and initializes the session.
+func connectMcp(ctx context.Context, server McpServer) (*mcp.ClientSession, error) {
+	client := mcp.NewClient(&mcp.Implementation{Name: "mcp-client", Version: "v1.0.0"}, nil)
+
+	if server.URL == "" {
+		if server.Command == "" {
+			return nil, errors.New("the MCP server needs a command or a URL")
+		}
+		cmd := exec.Command(server.Command, server.Args...)
+		cmd.Dir = server.Dir
+		if len(server.Env) > 0 {
+			cmd.Env = append(os.Environ(), server.Env...)
+		}
+		session, err := client.Connect(ctx, &mcp.CommandTransport{Command: cmd}, nil)
+		return session, errors.WithStack(err)
+	}
+
+	httpClient := &http.Client{Transport: &headerTransport{base: http.DefaultTransport, headers: server.Headers}}
+	switch server.Transport {
+	case "sse":
+		session, err := client.Connect(ctx, &mcp.SSEClientTransport{Endpoint: server.URL, HTTPClient: httpClient}, nil)
+		return session, errors.Wrapf(err, "error connecting to the MCP server %s", server.URL)
+	case "streamable", "http":
+		session, err := client.Connect(ctx, &mcp.StreamableClientTransport{Endpoint: server.URL, HTTPClient: httpClient}, nil)
+		return session, errors.Wrapf(err, "error connecting to the MCP server %s", server.URL)
+	case "":
+		session, err := client.Connect(ctx, &mcp.StreamableClientTransport{Endpoint: server.URL, HTTPClient: httpClient}, nil)
+		if err == nil {
+			return session, nil
+		}
+		// Servers implementing only the older protocol reject the POST.
+		session, sseErr := client.Connect(ctx, &mcp.SSEClientTransport{Endpoint: server.URL, HTTPClient: httpClient}, nil)
+		if sseErr == nil {
+			return session, nil
+		}
+		return nil, errors.Wrapf(err, "error connecting to the MCP server %s (with SSE: %v)", server.URL, sseErr)
+	default:
+		return nil, errors.Errorf("unknown MCP transport %q, use streamable or sse", server.Transport)
+	}
+}
+
+// headerTransport adds headers to every request.
+type headerTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(t.headers) > 0 {
+		req = req.Clone(req.Context())
+		for name, value := range t.headers {
+			req.Header.Set(name, value)
+		}
+	}
+	return t.base.RoundTrip(req)
 }
 
 type McpAgentTool struct {
 	session *mcp.ClientSession
-	info    llm.ToolInfo
+	// name is the name of the tool on the server.
+	name string
+	info llm.ToolInfo
 }
 
 func NewMcpAgentToolMethod(session *mcp.ClientSession, tool *mcp.Tool) *McpAgentTool {
@@ -62,11 +254,14 @@ func NewMcpAgentToolMethod(session *mcp.ClientSession, tool *mcp.Tool) *McpAgent
 
 	return &McpAgentTool{
 		session: session,
+		name:    tool.Name,
 		info: llm.ToolInfo{
 			Name:        tool.Name,
 			Description: tool.Description,
 			Parameters:  parameters,
 			Required:    required,
+			// Tools the server declares read-only can't race with each other.
+			Parallel: tool.Annotations != nil && tool.Annotations.ReadOnlyHint,
 		},
 	}
 }
@@ -86,7 +281,7 @@ func (m McpAgentTool) Run(ctx context.Context, params llm.ToolCall) (llm.ToolRes
 
 	// Call the MCP tool through the session
 	result, err := m.session.CallTool(ctx, &mcp.CallToolParams{
-		Name:      m.info.Name,
+		Name:      m.name,
 		Arguments: arguments,
 	})
 	if err != nil {
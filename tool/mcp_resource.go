@@ -0,0 +1,207 @@
+package tool
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/elek/rai/llm"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/pkg/errors"
+)
+
+// ReadResourceInput defines the input of the read-resource tool.
+type ReadResourceInput struct {
+	URI string `json:"uri" description:"The URI of the resource, as listed by list-resources or built from one of its URI templates"`
+}
+
+// resourceTools returns the tools that list and read the resources of the
+// server, the ones allowed by the server config.
+func (c *McpClient) resourceTools() []llm.Tool {
+	var res []llm.Tool
+	if c.server.offers("list-resources") {
+		res = append(res, llm.Parallel(llm.NewTool[struct{}](c.server.toolName("list-resources"), "List the resources (documents, records, files, ...) the MCP server offers, and the URI templates of the ones it can build on request", func(ctx context.Context, _ struct{}) (string, error) {
+			return c.ListResources(ctx)
+		})))
+	}
+	if c.server.offers("read-resource") {
+		res = append(res, llm.Parallel(llm.NewTool[ReadResourceInput](c.server.toolName("read-resource"), "Read a resource of the MCP server by its URI", func(ctx context.Context, input ReadResourceInput) (string, error) {
+			return c.ReadResource(ctx, input.URI)
+		})))
+	}
+	return res
+}
+
+// ListResources lists the resources and the resource templates of the server.
+func (c *McpClient) ListResources(ctx context.Context) (string, error) {
+	var b strings.Builder
+	for r, err := range c.session.Resources(ctx, &mcp.ListResourcesParams{}) {
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		writeResource(&b, r.URI, r.Name, r.MIMEType, r.Description)
+	}
+	for r, err := range c.session.ResourceTemplates(ctx, &mcp.ListResourceTemplatesParams{}) {
+		if err != nil {
+			// Templates are optional; servers without them may reject the call.
+			break
+		}
+		writeResource(&b, r.URITemplate, r.Name, r.MIMEType, r.Description)
+	}
+	if b.Len() == 0 {
+		return "The server has no resources", nil
+	}
+	return b.String(), nil
+}
+
+func writeResource(b *strings.Builder, uri, name, mimeType, description string) {
+	b.WriteString(uri)
+	if name != "" && name != uri {
+		fmt.Fprintf(b, " (%s)", name)
+	}
+	if mimeType != "" {
+		fmt.Fprintf(b, " [%s]", mimeType)
+	}
+	if description != "" {
+		fmt.Fprintf(b, ": %s", strings.TrimSpace(description))
+	}
+	b.WriteString("\n")
+}
+
+// ReadResource returns the text of the resource with the given URI. Binary
+// contents are only described.
+func (c *McpClient) ReadResource(ctx context.Context, uri string) (string, error) {
+	if uri == "" {
+		return "", errors.New("uri is required")
+	}
+	res, err := c.session.ReadResource(ctx, &mcp.ReadResourceParams{URI: uri})
+	if err != nil {
+		return "", errors.Wrapf(err, "error reading the resource %s", uri)
+	}
+	var parts []string
+	for _, content := range res.Contents {
+		parts = append(parts, resourceText(content))
+	}
+	return strings.Join(parts, "\n"), nil
+}
+
+// resourceText returns the text of resource contents, or a description of
+// binary contents.
+func resourceText(content *mcp.ResourceContents) string {
+	if content.Blob != nil {
+		mimeType := content.MIMEType
+		if mimeType == "" {
+			mimeType = "binary"
+		}
+		return fmt.Sprintf("[%s: %s, %d bytes]", content.URI, mimeType, len(content.Blob))
+	}
+	return content.Text
+}
+
+// McpPrompt is a prompt template of an MCP server.
+type McpPrompt struct {
+	Name        string
+	Description string
+	// Arguments are the names of the arguments, in order; the required
+	// ones are marked with Required.
+	Arguments []string
+	Required  map[string]bool
+}
+
+// Prompts lists the prompts of the server.
+func (c *McpClient) Prompts(ctx context.Context) ([]McpPrompt, error) {
+	if caps := c.capabilities(); caps != nil && caps.Prompts == nil {
+		return nil, nil
+	}
+	var prompts []McpPrompt
+	for p, err := range c.session.Prompts(ctx, &mcp.ListPromptsParams{}) {
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		prompt := McpPrompt{Name: p.Name, Description: p.Description, Required: map[string]bool{}}
+		for _, arg := range p.Arguments {
+			prompt.Arguments = append(prompt.Arguments, arg.Name)
+			prompt.Required[arg.Name] = arg.Required
+		}
+		prompts = append(prompts, prompt)
+	}
+	return prompts, nil
+}
+
+// Prompt returns the text of the named prompt. args are "name=value" pairs
+// for the arguments of the prompt, or values of its arguments in order.
+func (c *McpClient) Prompt(ctx context.Context, name string, args []string) (string, error) {
+	prompts, err := c.Prompts(ctx)
+	if err != nil {
+		return "", err
+	}
+	var prompt *McpPrompt
+	for i := range prompts {
+		if prompts[i].Name == name {
+			prompt = &prompts[i]
+		}
+	}
+	if prompt == nil {
+		return "", errors.Errorf("the MCP server has no prompt %q", name)
+	}
+	values, err := prompt.arguments(args)
+	if err != nil {
+		return "", err
+	}
+	res, err := c.session.GetPrompt(ctx, &mcp.GetPromptParams{Name: name, Arguments: values})
+	if err != nil {
+		return "", errors.Wrapf(err, "error getting the prompt %s", name)
+	}
+	var parts []string
+	for _, msg := range res.Messages {
+		var text string
+		switch content := msg.Content.(type) {
+		case *mcp.TextContent:
+			text = content.Text
+		case *mcp.EmbeddedResource:
+			text = resourceText(content.Resource)
+		case *mcp.ResourceLink:
+			text = content.URI
+		default:
+			return "", errors.Errorf("the prompt %s has %T content, only text is supported", name, msg.Content)
+		}
+		if msg.Role == "assistant" {
+			text = "Assistant: " + text
+		}
+		parts = append(parts, text)
+	}
+	return strings.Join(parts, "\n\n"), nil
+}
+
+// arguments maps the command line arguments to the arguments of the prompt.
+func (p McpPrompt) arguments(args []string) (map[string]string, error) {
+	values := map[string]string{}
+	var positional []string
+	for _, arg := range args {
+		if name, value, ok := strings.Cut(arg, "="); ok {
+			if _, known := p.Required[name]; known {
+				values[name] = value
+				continue
+			}
+		}
+		positional = append(positional, arg)
+	}
+	for _, name := range p.Arguments {
+		if _, set := values[name]; !set && len(positional) > 0 {
+			values[name], positional = positional[0], positional[1:]
+		}
+	}
+	if len(positional) > 0 {
+		return nil, errors.Errorf("too many arguments for the prompt %s, it takes: %s", p.Name, strings.Join(p.Arguments, ", "))
+	}
+	var missing []string
+	for _, name := range p.Arguments {
+		if _, set := values[name]; !set && p.Required[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, errors.Errorf("the prompt %s needs the arguments: %s", p.Name, strings.Join(missing, ", "))
+	}
+	return values, nil
+}
@@ -1,11 +1,17 @@
 package tool
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/elek/rai/llm"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -33,3 +39,201 @@ func TestMcp(t *testing.T) {
 	require.NoError(t, err)
 	fmt.Println(run.Content)
 }
+
+type echoInput struct {
+	Text string `json:"text"`
+}
+
+// newTestMcpServer returns an MCP server with an echo tool, which only answers
+// requests with the bearer token "secret".
+func newTestMcpServer(t *testing.T, transport string) *httptest.Server {
+	t.Helper()
+	server := mcp.NewServer(&mcp.Implementation{Name: "test", Version: "v1.0.0"}, nil)
+	mcp.AddTool(server, &mcp.Tool{Name: "echo", Description: "Echo the text"}, func(ctx context.Context, req *mcp.CallToolRequest, input echoInput) (*mcp.CallToolResult, any, error) {
+		return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: "echo: " + input.Text}}}, nil, nil
+	})
+	var handler http.Handler
+	if transport == "sse" {
+		handler = mcp.NewSSEHandler(func(*http.Request) *mcp.Server { return server }, nil)
+	} else {
+		handler = mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return server }, nil)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestMcpOverHTTP(t *testing.T) {
+	for _, tc := range []struct {
+		name, server, transport string
+	}{
+		{"streamable", "streamable", "streamable"},
+		{"sse", "sse", "sse"},
+		{"detect streamable", "streamable", ""},
+		{"fall back to sse", "sse", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newTestMcpServer(t, tc.server)
+			tools, closer, err := NewMcpServerAgentTool(t.Context(), McpServer{
+				URL:       ts.URL,
+				Transport: tc.transport,
+				Headers:   map[string]string{"Authorization": "Bearer secret"},
+			})
+			require.NoError(t, err)
+			defer closer()
+			require.Len(t, tools, 1)
+			assert.Equal(t, "echo", tools[0].Info().Name)
+
+			res, err := tools[0].Run(t.Context(), llm.ToolCall{ID: "1", Name: "echo", Input: `{"text":"hi"}`})
+			require.NoError(t, err)
+			assert.Equal(t, llm.ToolResult{Content: "echo: hi"}, res)
+		})
+	}
+}
+
+func TestMcpOverHTTPNeedsAuthorization(t *testing.T) {
+	ts := newTestMcpServer(t, "streamable")
+	_, _, err := NewMcpServerAgentTool(t.Context(), McpServer{URL: ts.URL, Transport: "streamable"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error connecting to the MCP server "+ts.URL)
+}
+
+func TestMcpAliasAndFilters(t *testing.T) {
+	server := mcp.NewServer(&mcp.Implementation{Name: "issues", Version: "v1.0.0"}, nil)
+	for _, name := range []string{"get_issue", "list_issues", "delete_issue"} {
+		mcp.AddTool(server, &mcp.Tool{Name: name}, func(ctx context.Context, req *mcp.CallToolRequest, input echoInput) (*mcp.CallToolResult, any, error) {
+			return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: req.Params.Name + " " + input.Text}}}, nil, nil
+		})
+	}
+	ts := httptest.NewServer(mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return server }, nil))
+	t.Cleanup(ts.Close)
+
+	names := func(mcpServer McpServer) []string {
+		tools, closer, err := NewMcpServerAgentTool(t.Context(), mcpServer)
+		require.NoError(t, err)
+		defer closer()
+		var res []string
+		for _, tl := range tools {
+			res = append(res, tl.Info().Name)
+		}
+		return res
+	}
+	assert.ElementsMatch(t, []string{"gh_get_issue", "gh_list_issues", "gh_delete_issue"}, names(McpServer{URL: ts.URL, Alias: "gh"}))
+	assert.ElementsMatch(t, []string{"get_issue", "list_issues"}, names(McpServer{URL: ts.URL, Allow: []string{"get_*", "list_*"}}))
+	assert.ElementsMatch(t, []string{"gh_get_issue", "gh_list_issues"}, names(McpServer{URL: ts.URL, Alias: "gh", Deny: []string{"delete_*"}}))
+	assert.ElementsMatch(t, []string{"list_issues"}, names(McpServer{URL: ts.URL, Allow: []string{"*_issue*"}, Deny: []string{"*_issue"}}))
+	assert.ElementsMatch(t, []string{"my_gh_v2_list_issues"}, names(McpServer{URL: ts.URL, Alias: "my gh.v2", Allow: []string{"list_*"}}))
+
+	tools, closer, err := NewMcpServerAgentTool(t.Context(), McpServer{URL: ts.URL, Alias: "gh", Allow: []string{"get_issue"}})
+	require.NoError(t, err)
+	defer closer()
+	require.Len(t, tools, 1)
+	res, err := tools[0].Run(t.Context(), llm.ToolCall{ID: "1", Name: "gh_get_issue", Input: `{"text":"42"}`})
+	require.NoError(t, err)
+	assert.Equal(t, llm.ToolResult{Content: "get_issue 42"}, res, "the tool is called by its name on the server")
+}
+
+func TestMcpToolNameFitsTheProviders(t *testing.T) {
+	assert.Equal(t, "docs_search-v2", McpServer{Alias: "docs"}.toolName("search-v2"))
+	assert.Equal(t, "my_docs_find_page", McpServer{Alias: "my docs"}.toolName("find.page"))
+
+	long := McpServer{Alias: strings.Repeat("a", 40)}
+	first, second := long.toolName(strings.Repeat("b", 30)+"1"), long.toolName(strings.Repeat("b", 30)+"2")
+	assert.Len(t, first, 64)
+	assert.Regexp(t, `^[a-zA-Z0-9_-]{1,64}$`, first)
+	assert.NotEqual(t, first, second)
+}
+
+// newLibraryMcpServer returns an MCP server with resources and prompts, but no
+// tools.
+func newLibraryMcpServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := mcp.NewServer(&mcp.Implementation{Name: "library", Version: "v1.0.0"}, nil)
+	server.AddResource(&mcp.Resource{URI: "docs://guide", Name: "guide", MIMEType: "text/markdown", Description: "The style guide"},
+		func(ctx context.Context, req *mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+			return &mcp.ReadResourceResult{Contents: []*mcp.ResourceContents{{URI: req.Params.URI, Text: "Use tabs."}}}, nil
+		})
+	server.AddResource(&mcp.Resource{URI: "docs://logo", Name: "logo"},
+		func(ctx context.Context, req *mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+			return &mcp.ReadResourceResult{Contents: []*mcp.ResourceContents{{URI: req.Params.URI, MIMEType: "image/png", Blob: []byte{1, 2, 3}}}}, nil
+		})
+	server.AddResourceTemplate(&mcp.ResourceTemplate{URITemplate: "tickets://{id}", Name: "ticket"},
+		func(ctx context.Context, req *mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+			return &mcp.ReadResourceResult{Contents: []*mcp.ResourceContents{{URI: req.Params.URI, Text: "ticket " + req.Params.URI}}}, nil
+		})
+	server.AddPrompt(&mcp.Prompt{Name: "review", Description: "Review a change", Arguments: []*mcp.PromptArgument{
+		{Name: "change", Required: true},
+		{Name: "focus"},
+	}}, func(ctx context.Context, req *mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		return &mcp.GetPromptResult{Messages: []*mcp.PromptMessage{
+			{Role: "user", Content: &mcp.TextContent{Text: fmt.Sprintf("Review %s, focus on %q.", req.Params.Arguments["change"], req.Params.Arguments["focus"])}},
+			{Role: "user", Content: &mcp.EmbeddedResource{Resource: &mcp.ResourceContents{URI: "docs://guide", Text: "Use tabs."}}},
+		}}, nil
+	})
+	ts := httptest.NewServer(mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return server }, nil))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestMcpResources(t *testing.T) {
+	client, err := ConnectMcp(t.Context(), McpServer{URL: newLibraryMcpServer(t).URL})
+	require.NoError(t, err)
+	defer client.Close()
+
+	tools, err := client.Tools(t.Context())
+	require.NoError(t, err)
+	require.Len(t, tools, 2)
+	assert.Equal(t, "list-resources", tools[0].Info().Name)
+	assert.Equal(t, "read-resource", tools[1].Info().Name)
+
+	res, err := tools[0].Run(t.Context(), llm.ToolCall{ID: "1", Name: "list-resources", Input: `{}`})
+	require.NoError(t, err)
+	assert.Equal(t, "docs://guide (guide) [text/markdown]: The style guide\ndocs://logo (logo)\ntickets://{id} (ticket)\n", res.Content)
+
+	res, err = tools[1].Run(t.Context(), llm.ToolCall{ID: "2", Name: "read-resource", Input: `{"uri":"docs://guide"}`})
+	require.NoError(t, err)
+	assert.Equal(t, llm.ToolResult{Content: "Use tabs."}, res)
+
+	text, err := client.ReadResource(t.Context(), "tickets://42")
+	require.NoError(t, err)
+	assert.Equal(t, "ticket tickets://42", text)
+
+	text, err = client.ReadResource(t.Context(), "docs://logo")
+	require.NoError(t, err)
+	assert.Equal(t, "[docs://logo: image/png, 3 bytes]", text)
+
+	_, err = client.ReadResource(t.Context(), "docs://missing")
+	require.Error(t, err)
+}
+
+func TestMcpPrompts(t *testing.T) {
+	client, err := ConnectMcp(t.Context(), McpServer{URL: newLibraryMcpServer(t).URL})
+	require.NoError(t, err)
+	defer client.Close()
+
+	prompts, err := client.Prompts(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, []McpPrompt{{
+		Name:        "review",
+		Description: "Review a change",
+		Arguments:   []string{"change", "focus"},
+		Required:    map[string]bool{"change": true, "focus": false},
+	}}, prompts)
+
+	text, err := client.Prompt(t.Context(), "review", []string{"focus=tests", "HEAD~1"})
+	require.NoError(t, err)
+	assert.Equal(t, "Review HEAD~1, focus on \"tests\".\n\nUse tabs.", text)
+
+	_, err = client.Prompt(t.Context(), "review", nil)
+	require.ErrorContains(t, err, "the prompt review needs the arguments: change")
+	_, err = client.Prompt(t.Context(), "review", []string{"a", "b", "c"})
+	require.ErrorContains(t, err, "too many arguments")
+	_, err = client.Prompt(t.Context(), "missing", nil)
+	require.ErrorContains(t, err, `no prompt "missing"`)
+}
@@ -0,0 +1,605 @@
+package tool
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type PatchInput struct {
+	Patch string `json:"patch" description:"The changes of one or more files, either as a unified diff (as printed by git diff, with ---/+++ file headers and @@ hunks; /dev/null creates or deletes a file) or as an apply_patch document: '*** Begin Patch', then '*** Add File: <path>' followed by +lines, '*** Delete File: <path>', or '*** Update File: <path>' (optionally followed by '*** Move to: <path>') with @@-separated chunks of ' ' context, '-' removed and '+' added lines, and finally '*** End Patch'."`
+}
+
+// patchOp is one file operation of a patch.
+type patchOp struct {
+	kind   byte // 'A' (add), 'M' (update), 'D' (delete)
+	path   string
+	moveTo string
+	hunks  []patchHunk
+}
+
+// patchHunk replaces the old lines of a file with the new ones. The old lines
+// are searched for in the file; line and anchor only narrow the search.
+type patchHunk struct {
+	// line is the 1-based line of the old lines in the original file, as
+	// given by a unified diff; zero when unknown.
+	line int
+	// anchor is a line (given after @@ in apply_patch chunks) that precedes
+	// the old lines.
+	anchor string
+	// eof anchors the old lines at the end of the file.
+	eof bool
+	old []string
+	new []string
+	// context gives the index in old of each context line of new, and -1 for
+	// the added lines.
+	context []int
+	// added and removed count the lines that are not context.
+	added, removed int
+	// last is the kind of the last line added to the hunk. newlineSet is set
+	// by a "\ No newline at end of file" marker; noNewline then tells whether
+	// the new lines end the file without a newline.
+	last                  byte
+	newlineSet, noNewline bool
+}
+
+// patchFile is the state of a file while a patch is applied in memory.
+type patchFile struct {
+	display  string
+	original []byte
+	existed  bool
+	mode     os.FileMode
+	lines    []string
+	deleted  bool
+	added    int
+	removed  int
+	// movedFrom is the path of the file moved here; moved is set on the
+	// file it was moved away from.
+	movedFrom string
+	moved     bool
+	// noEOL is set when the file doesn't end with a newline.
+	noEOL bool
+}
+
+// Patch validates all changes of a patch against the files, and only writes
+// them when every hunk applies. Paths are resolved in the workspace of ctx.
+func Patch(ctx context.Context, input PatchInput) (string, error) {
+	ops, err := parsePatch(input.Patch)
+	if err != nil {
+		return "", err
+	}
+	if len(ops) == 0 {
+		return "", errors.New("the patch doesn't change any file")
+	}
+
+	ws := WorkspaceFrom(ctx)
+	files := map[string]*patchFile{}
+	var order []string
+	load := func(display string) (string, *patchFile, error) {
+		path, err := ws.Resolve(display)
+		if err != nil {
+			return "", nil, err
+		}
+		if f, ok := files[path]; ok {
+			return path, f, nil
+		}
+		f := &patchFile{display: display, mode: 0644}
+		content, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			stat, err := os.Stat(path)
+			if err != nil {
+				return "", nil, errors.WithStack(err)
+			}
+			f.existed, f.original, f.mode, f.lines = true, content, stat.Mode(), splitLines(string(content))
+			f.noEOL = len(content) > 0 && content[len(content)-1] != '\n'
+		case os.IsNotExist(err):
+			f.deleted = true
+		default:
+			return "", nil, errors.WithStack(err)
+		}
+		files[path] = f
+		order = append(order, path)
+		return path, f, nil
+	}
+
+	var problems []string
+	for _, op := range ops {
+		_, f, err := load(op.path)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		switch op.kind {
+		case 'A':
+			if !f.deleted {
+				problems = append(problems, fmt.Sprintf("%s: can't add the file, it already exists", op.path))
+				continue
+			}
+			f.deleted, f.noEOL = false, false
+			for _, h := range op.hunks {
+				f.lines = append(f.lines, h.new...)
+				f.added += len(h.new)
+				if h.newlineSet {
+					f.noEOL = h.noNewline
+				}
+			}
+		case 'D':
+			if f.deleted {
+				problems = append(problems, fmt.Sprintf("%s: can't delete the file, it doesn't exist", op.path))
+				continue
+			}
+			f.removed += len(f.lines)
+			f.deleted, f.lines = true, nil
+		case 'M':
+			if f.deleted {
+				problems = append(problems, fmt.Sprintf("%s: can't update the file, it doesn't exist", op.path))
+				continue
+			}
+			lines, errs := applyHunks(f.lines, op.hunks)
+			if len(errs) > 0 {
+				for _, e := range errs {
+					problems = append(problems, op.path+": "+e)
+				}
+				continue
+			}
+			for _, h := range op.hunks {
+				f.added += h.added
+				f.removed += h.removed
+				if h.newlineSet {
+					f.noEOL = h.noNewline
+				}
+			}
+			f.lines = lines
+			if op.moveTo != "" {
+				_, target, err := load(op.moveTo)
+				if err != nil {
+					problems = append(problems, err.Error())
+					continue
+				}
+				if !target.deleted {
+					problems = append(problems, fmt.Sprintf("%s: can't move the file to %s, it already exists", op.path, op.moveTo))
+					continue
+				}
+				target.deleted, target.lines, target.mode, target.noEOL = false, f.lines, f.mode, f.noEOL
+				target.added, target.removed, target.movedFrom = f.added, f.removed, op.path
+				f.deleted, f.lines, f.added, f.removed, f.moved = true, nil, 0, 0, true
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return "", errors.Errorf("the patch doesn't apply, no files were changed:\n%s", strings.Join(problems, "\n"))
+	}
+
+	if err := writePatchFiles(files, order); err != nil {
+		return "", err
+	}
+	for _, path := range order {
+		fileChanged(path)
+	}
+
+	var b strings.Builder
+	var changed int
+	for _, path := range order {
+		f := files[path]
+		status, display := "", f.display
+		switch {
+		case f.moved && f.deleted:
+			continue
+		case f.movedFrom != "":
+			status, display = "R", f.movedFrom+" -> "+f.display
+		case f.existed && f.deleted:
+			status = "D"
+		case !f.existed && !f.deleted:
+			status = "A"
+		case f.existed:
+			status = "M"
+		default:
+			continue
+		}
+		changed++
+		fmt.Fprintf(&b, "%s %s (+%d -%d)\n", status, display, f.added, f.removed)
+	}
+	return fmt.Sprintf("Patch applied to %d files:\n%s", changed, b.String()), nil
+}
+
+// writePatchFiles writes the patched files. All new contents are written to
+// temporary files first, then renamed into place; if that fails halfway, the
+// files already replaced are restored.
+func writePatchFiles(files map[string]*patchFile, order []string) error {
+	temps := map[string]string{}
+	cleanup := func() {
+		for _, tmp := range temps {
+			_ = os.Remove(tmp)
+		}
+	}
+	for _, path := range order {
+		f := files[path]
+		if f.deleted {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			cleanup()
+			return errors.Wrap(err, "error creating directory")
+		}
+		tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".patch-*")
+		if err != nil {
+			cleanup()
+			return errors.Wrap(err, "error writing file")
+		}
+		temps[path] = tmp.Name()
+		content := ""
+		if len(f.lines) > 0 {
+			content = strings.Join(f.lines, "\n")
+			if !f.noEOL {
+				content += "\n"
+			}
+		}
+		_, err = tmp.WriteString(content)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Chmod(tmp.Name(), f.mode)
+		}
+		if err != nil {
+			cleanup()
+			return errors.Wrap(err, "error writing file")
+		}
+	}
+
+	var done []string
+	restore := func() {
+		for _, path := range done {
+			f := files[path]
+			if f.existed {
+				_ = os.WriteFile(path, f.original, f.mode)
+			} else {
+				_ = os.Remove(path)
+			}
+		}
+	}
+	for _, path := range order {
+		f := files[path]
+		var err error
+		switch {
+		case !f.deleted:
+			err = os.Rename(temps[path], path)
+			delete(temps, path)
+		case f.existed:
+			err = os.Remove(path)
+		default:
+			continue
+		}
+		if err != nil {
+			cleanup()
+			restore()
+			return errors.Wrapf(err, "error writing %s, no files were changed", f.display)
+		}
+		done = append(done, path)
+	}
+	return nil
+}
+
+// applyHunks applies the hunks to lines in order. It returns a description of
+// every hunk that doesn't apply.
+func applyHunks(lines []string, hunks []patchHunk) ([]string, []string) {
+	var problems []string
+	lines = append([]string(nil), lines...)
+	cursor, delta := 0, 0
+	for i, h := range hunks {
+		start := cursor
+		if h.anchor != "" {
+			at := findLines(lines, []string{h.anchor}, start, -1, false)
+			if at < 0 {
+				problems = append(problems, fmt.Sprintf("hunk %d: the line %q was not found", i+1, h.anchor))
+				continue
+			}
+			start = at + 1
+		}
+		hint := -1
+		if h.line > 0 {
+			hint = h.line - 1 + delta
+		}
+		at := findLines(lines, h.old, start, hint, h.eof)
+		if at < 0 {
+			problems = append(problems, fmt.Sprintf("hunk %d: the lines to change were not found:\n%s", i+1, indent(h.old)))
+			continue
+		}
+		// The context lines are taken from the file, which may differ in
+		// whitespace from the patch when it only matched loosely.
+		repl := append([]string(nil), h.new...)
+		for j, k := range h.context {
+			if k >= 0 {
+				repl[j] = lines[at+k]
+			}
+		}
+		lines = append(lines[:at], append(repl, lines[at+len(h.old):]...)...)
+		cursor = at + len(h.new)
+		delta += len(h.new) - len(h.old)
+	}
+	return lines, problems
+}
+
+// findLines returns where old occurs in lines at or after start: the
+// occurrence closest to hint when it is not negative, or the first one. When
+// eof is set, old must end the file. Lines are compared exactly first, then
+// ignoring trailing and then all surrounding whitespace.
+func findLines(lines, old []string, start, hint int, eof bool) int {
+	if len(old) == 0 {
+		switch {
+		case eof:
+			return len(lines)
+		case hint >= 0:
+			return min(max(hint, start), len(lines))
+		default:
+			return min(start, len(lines))
+		}
+	}
+	for _, norm := range []func(string) string{
+		func(s string) string { return s },
+		func(s string) string { return strings.TrimRight(s, " \t\r") },
+		strings.TrimSpace,
+	} {
+		best := -1
+		for at := start; at+len(old) <= len(lines); at++ {
+			if eof && at+len(old) != len(lines) {
+				continue
+			}
+			if !linesMatch(lines[at:at+len(old)], old, norm) {
+				continue
+			}
+			if hint < 0 {
+				return at
+			}
+			if best < 0 || abs(at-hint) < abs(best-hint) {
+				best = at
+			}
+		}
+		if best >= 0 {
+			return best
+		}
+	}
+	return -1
+}
+
+func linesMatch(a, b []string, norm func(string) string) bool {
+	for i := range a {
+		if norm(a[i]) != norm(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func indent(lines []string) string {
+	var b strings.Builder
+	for _, l := range lines {
+		b.WriteString("    " + l + "\n")
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// parsePatch parses a unified diff or an apply_patch document.
+func parsePatch(patch string) ([]patchOp, error) {
+	lines := strings.Split(strings.ReplaceAll(patch, "\r\n", "\n"), "\n")
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "*** Begin Patch" {
+			return parseApplyPatch(lines)
+		}
+	}
+	return parseUnifiedDiff(lines)
+}
+
+// parseApplyPatch parses the apply_patch format.
+func parseApplyPatch(lines []string) ([]patchOp, error) {
+	var ops []patchOp
+	var op *patchOp
+	var hunk *patchHunk
+	flush := func() {
+		if hunk != nil && op != nil && (len(hunk.old) > 0 || len(hunk.new) > 0) {
+			op.hunks = append(op.hunks, *hunk)
+		}
+		hunk = nil
+	}
+	started := false
+	for n, line := range lines {
+		header := strings.TrimSpace(line)
+		switch {
+		case header == "*** Begin Patch":
+			started = true
+			continue
+		case !started:
+			continue
+		case header == "*** End Patch":
+			flush()
+			return ops, nil
+		case strings.HasPrefix(header, "*** Add File:"), strings.HasPrefix(header, "*** Update File:"), strings.HasPrefix(header, "*** Delete File:"):
+			flush()
+			name, path, _ := strings.Cut(strings.TrimPrefix(header, "*** "), ":")
+			ops = append(ops, patchOp{kind: name[0], path: strings.TrimSpace(path)})
+			if ops[len(ops)-1].kind == 'U' {
+				ops[len(ops)-1].kind = 'M'
+			}
+			op = &ops[len(ops)-1]
+			if op.kind == 'A' {
+				hunk = &patchHunk{}
+			}
+			continue
+		case strings.HasPrefix(header, "*** Move to:"):
+			if op == nil || op.kind != 'M' {
+				return nil, errors.Errorf("line %d: *** Move to: must follow *** Update File:", n+1)
+			}
+			op.moveTo = strings.TrimSpace(strings.TrimPrefix(header, "*** Move to:"))
+			continue
+		case header == "*** End of File":
+			if hunk != nil {
+				hunk.eof = true
+			}
+			continue
+		}
+		if op == nil {
+			if header == "" {
+				continue
+			}
+			return nil, errors.Errorf("line %d: expected a file header (*** Add File:, *** Update File:, *** Delete File:), got %q", n+1, line)
+		}
+		switch {
+		case op.kind == 'A':
+			if strings.HasPrefix(line, "+") {
+				hunk.new = append(hunk.new, line[1:])
+			} else if header != "" {
+				return nil, errors.Errorf("line %d: the lines of an added file must start with +", n+1)
+			}
+		case op.kind == 'D':
+			if header != "" {
+				return nil, errors.Errorf("line %d: unexpected line after *** Delete File:", n+1)
+			}
+		case strings.HasPrefix(line, "@@"):
+			flush()
+			hunk = &patchHunk{anchor: strings.TrimSpace(strings.TrimPrefix(line, "@@"))}
+		default:
+			if hunk == nil {
+				hunk = &patchHunk{}
+			}
+			if err := addHunkLine(hunk, line); err != nil {
+				return nil, errors.Wrapf(err, "line %d", n+1)
+			}
+		}
+	}
+	if !started {
+		return nil, errors.New("the patch has no *** Begin Patch line")
+	}
+	return nil, errors.New("the patch has no *** End Patch line")
+}
+
+// addHunkLine adds a context (' '), removed ('-'), or added ('+') line, or a
+// "\ No newline at end of file" marker for the line before it, to a hunk. An
+// empty line is taken as empty context.
+func addHunkLine(h *patchHunk, line string) error {
+	if line == "" {
+		line = " "
+	}
+	switch line[0] {
+	case ' ':
+		h.context = append(h.context, len(h.old))
+		h.old = append(h.old, line[1:])
+		h.new = append(h.new, line[1:])
+	case '-':
+		h.old = append(h.old, line[1:])
+		h.removed++
+	case '+':
+		h.context = append(h.context, -1)
+		h.new = append(h.new, line[1:])
+		h.added++
+	case '\\':
+		// After a removed line, the marker is about the old lines only.
+		h.newlineSet, h.noNewline = true, h.last != '-'
+		return nil
+	default:
+		return errors.Errorf("unexpected line in a hunk: %q", line)
+	}
+	h.last = line[0]
+	return nil
+}
+
+var hunkHeaderRe = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// parseUnifiedDiff parses a unified diff of one or more files. The line counts
+// of the hunk headers are not trusted; a hunk ends at the next hunk or file
+// header.
+func parseUnifiedDiff(lines []string) ([]patchOp, error) {
+	var ops []patchOp
+	var op *patchOp
+	var hunk *patchHunk
+	flush := func() {
+		if hunk != nil && op != nil {
+			op.hunks = append(op.hunks, *hunk)
+		}
+		hunk = nil
+	}
+	for n := 0; n < len(lines); n++ {
+		line := lines[n]
+		switch {
+		case strings.HasPrefix(line, "--- ") && n+1 < len(lines) && strings.HasPrefix(lines[n+1], "+++ "):
+			flush()
+			from, to := diffPath(line[4:]), diffPath(lines[n+1][4:])
+			n++
+			switch {
+			case from == "" && to == "":
+				return nil, errors.Errorf("line %d: both sides of the file header are /dev/null", n)
+			case from == "":
+				ops = append(ops, patchOp{kind: 'A', path: to})
+			case to == "":
+				ops = append(ops, patchOp{kind: 'D', path: from})
+			default:
+				ops = append(ops, patchOp{kind: 'M', path: from})
+				if to != from {
+					ops[len(ops)-1].moveTo = to
+				}
+			}
+			op = &ops[len(ops)-1]
+		case strings.HasPrefix(line, "@@"):
+			flush()
+			if op == nil {
+				return nil, errors.Errorf("line %d: hunk before the ---/+++ file header", n+1)
+			}
+			m := hunkHeaderRe.FindStringSubmatch(line)
+			if m == nil {
+				return nil, errors.Errorf("line %d: invalid hunk header %q", n+1, line)
+			}
+			start, _ := strconv.Atoi(m[1])
+			hunk = &patchHunk{line: start}
+			if m[2] == "0" {
+				// A pure insertion is numbered by the line before it.
+				hunk.line = start + 1
+			}
+		case strings.HasPrefix(line, "diff "):
+			flush()
+			op = nil
+		case hunk != nil:
+			if line == "" && n == len(lines)-1 {
+				continue
+			}
+			if err := addHunkLine(hunk, line); err != nil {
+				return nil, errors.Wrapf(err, "line %d", n+1)
+			}
+		}
+	}
+	flush()
+
+	// Added and deleted files take their content from the hunks alone.
+	for i := range ops {
+		if ops[i].kind == 'D' {
+			ops[i].hunks = nil
+		}
+	}
+	return ops, nil
+}
+
+// diffPath returns the path of a ---/+++ header, without its a/ or b/ prefix
+// and timestamp, or the empty string for /dev/null.
+func diffPath(header string) string {
+	path, _, _ := strings.Cut(header, "\t")
+	path = strings.TrimSpace(path)
+	if path == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(path, "a/") || strings.HasPrefix(path, "b/") {
+		path = path[2:]
+	}
+	return path
+}
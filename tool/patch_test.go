@@ -0,0 +1,259 @@
+package tool
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newPatchWorkspace returns a context confined to a workspace holding the
+// given files.
+func newPatchWorkspace(t *testing.T, files map[string]string) (context.Context, string) {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), []byte(content), 0o644))
+	}
+	ws, err := NewWorkspace(root, false)
+	require.NoError(t, err)
+	return ContextWithWorkspace(context.Background(), ws), ws.Root
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestPatchUnifiedDiff(t *testing.T) {
+	ctx, root := newPatchWorkspace(t, map[string]string{
+		"a.go":   "package a\n\nfunc A() int {\n\treturn 1\n}\n",
+		"b.go":   "package b\n\nvar B = 1\n",
+		"old.go": "package old\n",
+	})
+
+	out, err := Patch(ctx, PatchInput{Patch: `diff --git a/a.go b/a.go
+index 1111111..2222222 100644
+--- a/a.go
++++ b/a.go
+@@ -3,3 +3,3 @@ package a
+ func A() int {
+-	return 1
++	return 2
+ }
+--- a/b.go
++++ b/b.go
+@@ -3,1 +3,2 @@
+ var B = 1
++var C = 2
+--- /dev/null
++++ b/c/new.go
+@@ -0,0 +1,1 @@
++package c
+--- a/old.go
++++ /dev/null
+@@ -1 +0,0 @@
+-package old
+`})
+	require.NoError(t, err)
+	assert.Equal(t, "Patch applied to 4 files:\nM a.go (+1 -1)\nM b.go (+1 -0)\nA c/new.go (+1 -0)\nD old.go (+0 -1)\n", out)
+
+	assert.Equal(t, "package a\n\nfunc A() int {\n\treturn 2\n}\n", readTestFile(t, filepath.Join(root, "a.go")))
+	assert.Equal(t, "package b\n\nvar B = 1\nvar C = 2\n", readTestFile(t, filepath.Join(root, "b.go")))
+	assert.Equal(t, "package c\n", readTestFile(t, filepath.Join(root, "c", "new.go")))
+	assert.NoFileExists(t, filepath.Join(root, "old.go"))
+}
+
+func TestPatchUnifiedDiffWithWrongLineNumbers(t *testing.T) {
+	ctx, root := newPatchWorkspace(t, map[string]string{
+		"a.txt": "x\none\nx\ntwo\nx\n",
+	})
+
+	_, err := Patch(ctx, PatchInput{Patch: `--- a.txt
++++ a.txt
+@@ -1,2 +1,2 @@
+ x
+-two
++TWO
+`})
+	require.NoError(t, err)
+	assert.Equal(t, "x\none\nx\nTWO\nx\n", readTestFile(t, filepath.Join(root, "a.txt")))
+}
+
+func TestPatchUnifiedDiffWithoutContext(t *testing.T) {
+	ctx, root := newPatchWorkspace(t, map[string]string{
+		"a.txt": "1\n2\n3\n4\n5\n6\n7\n",
+		"b.txt": "x\ny",
+		"c.txt": "p\nq",
+	})
+
+	// The output of git diff -U0.
+	_, err := Patch(ctx, PatchInput{Patch: `diff --git a/a.txt b/a.txt
+index 06e567b..fc95e4f 100644
+--- a/a.txt
++++ b/a.txt
+@@ -0,0 +1 @@
++new
+@@ -5,0 +7 @@
++new5
+diff --git a/b.txt b/b.txt
+index 1b32298..aa3d911 100644
+--- a/b.txt
++++ b/b.txt
+@@ -2 +2 @@ x
+-y
+\ No newline at end of file
++Y
+\ No newline at end of file
+diff --git a/c.txt b/c.txt
+index 8d7864f..e563bc2 100644
+--- a/c.txt
++++ b/c.txt
+@@ -2 +2 @@ p
+-q
+\ No newline at end of file
++q
+diff --git a/d.txt b/d.txt
+new file mode 100644
+index 0000000..c59d9b6
+--- /dev/null
++++ b/d.txt
+@@ -0,0 +1 @@
++d
+\ No newline at end of file
+`})
+	require.NoError(t, err)
+	assert.Equal(t, "new\n1\n2\n3\n4\n5\nnew5\n6\n7\n", readTestFile(t, filepath.Join(root, "a.txt")))
+	assert.Equal(t, "x\nY", readTestFile(t, filepath.Join(root, "b.txt")))
+	assert.Equal(t, "p\nq\n", readTestFile(t, filepath.Join(root, "c.txt")))
+	assert.Equal(t, "d", readTestFile(t, filepath.Join(root, "d.txt")))
+}
+
+func TestPatchKeepsTheFileWithoutFinalNewline(t *testing.T) {
+	ctx, root := newPatchWorkspace(t, map[string]string{
+		"a.txt": "a\nb\nc",
+	})
+
+	_, err := Patch(ctx, PatchInput{Patch: `*** Begin Patch
+*** Update File: a.txt
+-a
++A
+*** End Patch`})
+	require.NoError(t, err)
+	assert.Equal(t, "A\nb\nc", readTestFile(t, filepath.Join(root, "a.txt")))
+}
+
+func TestPatchKeepsTheContextOfTheFileOnALooseMatch(t *testing.T) {
+	ctx, root := newPatchWorkspace(t, map[string]string{
+		"main.go": "func a() {\n\tif x {\n\t\treturn\n\t}\n}\n",
+	})
+
+	_, err := Patch(ctx, PatchInput{Patch: `*** Begin Patch
+*** Update File: main.go
+    if x {
+-        return
++		return 1
+    }
+*** End Patch`})
+	require.NoError(t, err)
+	assert.Equal(t, "func a() {\n\tif x {\n\t\treturn 1\n\t}\n}\n", readTestFile(t, filepath.Join(root, "main.go")))
+}
+
+func TestPatchApplyPatchFormat(t *testing.T) {
+	ctx, root := newPatchWorkspace(t, map[string]string{
+		"main.go":   "package main\n\nfunc a() {\n\treturn\n}\n\nfunc b() {\n\treturn\n}\n",
+		"gone.txt":  "bye\n",
+		"moved.txt": "one\ntwo\n",
+	})
+
+	out, err := Patch(ctx, PatchInput{Patch: `*** Begin Patch
+*** Update File: main.go
+@@ func b() {
+-	return
++	println("b")
+*** Add File: docs/README.md
++# Docs
++
++Hello
+*** Delete File: gone.txt
+*** Update File: moved.txt
+*** Move to: renamed.txt
+ one
+-two
++three
+*** End of File
+*** End Patch`})
+	require.NoError(t, err)
+	assert.Equal(t, "Patch applied to 4 files:\nM main.go (+1 -1)\nA docs/README.md (+3 -0)\nD gone.txt (+0 -1)\nR moved.txt -> renamed.txt (+1 -1)\n", out)
+
+	assert.Equal(t, "package main\n\nfunc a() {\n\treturn\n}\n\nfunc b() {\n\tprintln(\"b\")\n}\n", readTestFile(t, filepath.Join(root, "main.go")))
+	assert.Equal(t, "# Docs\n\nHello\n", readTestFile(t, filepath.Join(root, "docs", "README.md")))
+	assert.Equal(t, "one\nthree\n", readTestFile(t, filepath.Join(root, "renamed.txt")))
+	assert.NoFileExists(t, filepath.Join(root, "gone.txt"))
+	assert.NoFileExists(t, filepath.Join(root, "moved.txt"))
+}
+
+func TestPatchIsAllOrNothing(t *testing.T) {
+	ctx, root := newPatchWorkspace(t, map[string]string{
+		"a.txt": "a\n",
+		"b.txt": "b\n",
+	})
+
+	_, err := Patch(ctx, PatchInput{Patch: `*** Begin Patch
+*** Update File: a.txt
+-a
++A
+*** Update File: b.txt
+-missing
++B
+*** Add File: a.txt
++again
+*** End Patch`})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no files were changed")
+	assert.Contains(t, err.Error(), "b.txt: hunk 1: the lines to change were not found")
+	assert.Contains(t, err.Error(), "a.txt: can't add the file, it already exists")
+
+	assert.Equal(t, "a\n", readTestFile(t, filepath.Join(root, "a.txt")))
+	assert.Equal(t, "b\n", readTestFile(t, filepath.Join(root, "b.txt")))
+	entries, err := os.ReadDir(root)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "no temporary files are left behind")
+}
+
+func TestPatchIsConfinedToTheWorkspace(t *testing.T) {
+	ctx, root := newPatchWorkspace(t, map[string]string{"a.txt": "a\n"})
+
+	_, err := Patch(ctx, PatchInput{Patch: `*** Begin Patch
+*** Update File: a.txt
+-a
++A
+*** Add File: ../escape.txt
++x
+*** End Patch`})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "outside of the workspace")
+	assert.Equal(t, "a\n", readTestFile(t, filepath.Join(root, "a.txt")))
+	assert.NoFileExists(t, filepath.Join(filepath.Dir(root), "escape.txt"))
+}
+
+func TestParsePatchErrors(t *testing.T) {
+	for patch, want := range map[string]string{
+		"*** Begin Patch\n*** Update File: a\n-x\n":          "no *** End Patch line",
+		"*** Begin Patch\nhello\n*** End Patch":              "expected a file header",
+		"*** Begin Patch\n*** Add File: a\nx\n*** End Patch": "must start with +",
+		"@@ -1 +1 @@\n-a\n+b\n":                              "hunk before the ---/+++ file header",
+		"--- a\n+++ a\n@@ bogus @@\n":                        "invalid hunk header",
+		"--- a\n+++ a\n@@ -1 +1 @@\n?a\n":                    "unexpected line in a hunk",
+	} {
+		_, err := parsePatch(patch)
+		if assert.Error(t, err, patch) {
+			assert.Contains(t, err.Error(), want, patch)
+		}
+	}
+}
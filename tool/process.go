@@ -0,0 +1,363 @@
+package tool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxProcesses bounds the background processes running at the same time.
+	maxProcesses = 16
+	// maxProcessBuffer is how much unread output of a background process is
+	// kept; older output is dropped.
+	maxProcessBuffer = 1 << 20
+	// maxProcessWait bounds how long a call waits for output.
+	maxProcessWait = 60 * time.Second
+	// processStopDelay is how long a process may take to exit after SIGTERM
+	// before it is killed.
+	processStopDelay = 2 * time.Second
+)
+
+// Processes tracks the background processes started by the tools of one agent
+// run or session. Close stops all of them.
+type Processes struct {
+	mu     sync.Mutex
+	next   int
+	procs  map[int]*process
+	closed bool
+}
+
+// NewProcesses creates an empty registry of background processes.
+func NewProcesses() *Processes {
+	return &Processes{procs: map[int]*process{}}
+}
+
+// process is one background process and the output it wrote so far.
+type process struct {
+	id      int
+	command string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+
+	mu      sync.Mutex
+	unread  []byte
+	dropped int
+	written chan struct{}
+	// exited is closed when the process has exited and its output is read.
+	exited chan struct{}
+}
+
+func (p *process) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.unread = append(p.unread, b...)
+	if over := len(p.unread) - maxProcessBuffer; over > 0 {
+		p.dropped += over
+		p.unread = append(p.unread[:0], p.unread[over:]...)
+	}
+	select {
+	case p.written <- struct{}{}:
+	default:
+	}
+	return len(b), nil
+}
+
+// read returns the output written since the last read.
+func (p *process) read() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := &cappedOutput{limit: maxCommandOutput}
+	if p.dropped > 0 {
+		fmt.Fprintf(out, "[... %d bytes of older output dropped ...]\n", p.dropped)
+	}
+	_, _ = out.Write(p.unread)
+	p.unread, p.dropped = nil, 0
+	select {
+	case <-p.written:
+	default:
+	}
+	return out.String()
+}
+
+// wait waits until the process writes output or exits, or d passes.
+func (p *process) wait(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-p.written:
+	case <-p.exited:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// status describes whether the process still runs.
+func (p *process) status() string {
+	select {
+	case <-p.exited:
+		return fmt.Sprintf("exited with code %d", p.cmd.ProcessState.ExitCode())
+	default:
+		return "running"
+	}
+}
+
+// report returns the header and the new output of the process.
+func (p *process) report() string {
+	out := p.read()
+	if out != "" && !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	return fmt.Sprintf("[process %d: %s, %s]\n%s", p.id, p.command, p.status(), out)
+}
+
+// stop asks the process group to terminate, and kills it if it doesn't.
+func (p *process) stop() {
+	select {
+	case <-p.exited:
+		return
+	default:
+	}
+	_ = signalProcessGroup(p.cmd, "TERM")
+	select {
+	case <-p.exited:
+	case <-time.After(processStopDelay):
+		_ = killProcessGroup(p.cmd)
+		<-p.exited
+	}
+}
+
+// start starts command in the background, in the workspace (and sandbox) of
+// ctx. The process outlives ctx; it runs until it exits or is stopped.
+func (ps *Processes) start(ctx context.Context, command string) (*process, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.closed {
+		return nil, errors.New("the session is closed")
+	}
+	running := 0
+	for _, p := range ps.procs {
+		if p.status() == "running" {
+			running++
+		}
+	}
+	if running >= maxProcesses {
+		return nil, errors.Errorf("there are already %d background processes running; stop some of them first", running)
+	}
+
+	ws := WorkspaceFrom(ctx)
+	ps.next++
+	p := &process{
+		id:      ps.next,
+		command: command,
+		cmd:     exec.Command("/bin/sh", "-c", command),
+		written: make(chan struct{}, 1),
+		exited:  make(chan struct{}),
+	}
+	p.cmd.Dir = ws.Dir()
+	p.cmd.WaitDelay = commandWaitDelay
+	p.cmd.Stdout = p
+	p.cmd.Stderr = p
+	setProcessGroup(p.cmd)
+	stdin, err := p.cmd.StdinPipe()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	p.stdin = stdin
+	if ws != nil && ws.Sandbox {
+		err = startSandboxed(p.cmd, ws.Root)
+	} else {
+		err = p.cmd.Start()
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	go func() {
+		_ = p.cmd.Wait()
+		close(p.exited)
+	}()
+	ps.procs[p.id] = p
+	return p, nil
+}
+
+// get returns the process with the given id.
+func (ps *Processes) get(id int) (*process, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	p, ok := ps.procs[id]
+	if !ok {
+		return nil, errors.Errorf("there is no background process %d", id)
+	}
+	return p, nil
+}
+
+// Close stops every process that still runs. Processes can't be started
+// afterwards.
+func (ps *Processes) Close() {
+	ps.mu.Lock()
+	ps.closed = true
+	procs := make([]*process, 0, len(ps.procs))
+	for _, p := range ps.procs {
+		procs = append(procs, p)
+	}
+	ps.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, p := range procs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.stop()
+		}()
+	}
+	wg.Wait()
+}
+
+// list describes all processes, in the order they were started.
+func (ps *Processes) list() string {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if len(ps.procs) == 0 {
+		return "No background processes"
+	}
+	ids := make([]int, 0, len(ps.procs))
+	for id := range ps.procs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	var b strings.Builder
+	for _, id := range ids {
+		p := ps.procs[id]
+		fmt.Fprintf(&b, "%d: %s (%s)\n", id, p.command, p.status())
+	}
+	return b.String()
+}
+
+type processesKey struct{}
+
+// ContextWithProcesses returns a context in which the process tools start and
+// find their processes in ps.
+func ContextWithProcesses(ctx context.Context, ps *Processes) context.Context {
+	return context.WithValue(ctx, processesKey{}, ps)
+}
+
+// ProcessesFrom returns the background processes of ctx, or nil if there is
+// no place to track them.
+func ProcessesFrom(ctx context.Context) *Processes {
+	ps, _ := ctx.Value(processesKey{}).(*Processes)
+	return ps
+}
+
+func processesOf(ctx context.Context) (*Processes, error) {
+	ps := ProcessesFrom(ctx)
+	if ps == nil {
+		return nil, errors.New("background processes are not available here")
+	}
+	return ps, nil
+}
+
+type ProcessStartInput struct {
+	Command string `json:"command" description:"The shell command to start in the background, e.g. a dev server or a test watcher"`
+	Wait    int    `json:"wait" description:"Optional number of seconds to wait for its first output (default 1)"`
+}
+
+type ProcessOutputInput struct {
+	ID   int `json:"id" description:"The id of the background process"`
+	Wait int `json:"wait" description:"Optional number of seconds to wait for new output when there is none yet (at most 60)"`
+}
+
+type ProcessSendInput struct {
+	ID     int    `json:"id" description:"The id of the background process"`
+	Input  string `json:"input" description:"Optional text to write to the standard input of the process (include a trailing newline to send a line)"`
+	Signal string `json:"signal" description:"Optional signal to send to the process and its children after the input: INT, TERM, KILL or HUP; use EOF to close its standard input"`
+}
+
+// ProcessStart starts a background process and returns its id with its first
+// output.
+func ProcessStart(ctx context.Context, input ProcessStartInput) (string, error) {
+	ps, err := processesOf(ctx)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(input.Command) == "" {
+		return "", errors.New("command is required")
+	}
+	p, err := ps.start(ctx, input.Command)
+	if err != nil {
+		return "", err
+	}
+	wait := time.Second
+	if input.Wait > 0 {
+		wait = min(time.Duration(input.Wait)*time.Second, maxProcessWait)
+	}
+	p.wait(ctx, wait)
+	return p.report(), nil
+}
+
+// ProcessOutput returns the output a background process wrote since the last
+// call, waiting for some if there is none yet.
+func ProcessOutput(ctx context.Context, input ProcessOutputInput) (string, error) {
+	ps, err := processesOf(ctx)
+	if err != nil {
+		return "", err
+	}
+	p, err := ps.get(input.ID)
+	if err != nil {
+		return "", err
+	}
+	p.mu.Lock()
+	empty := len(p.unread) == 0
+	p.mu.Unlock()
+	if empty && input.Wait > 0 {
+		p.wait(ctx, min(time.Duration(input.Wait)*time.Second, maxProcessWait))
+	}
+	return p.report(), nil
+}
+
+// ProcessSend writes input to a background process and/or sends it a signal.
+func ProcessSend(ctx context.Context, input ProcessSendInput) (string, error) {
+	ps, err := processesOf(ctx)
+	if err != nil {
+		return "", err
+	}
+	p, err := ps.get(input.ID)
+	if err != nil {
+		return "", err
+	}
+	if input.Input != "" {
+		if _, err := io.WriteString(p.stdin, input.Input); err != nil {
+			return "", errors.Wrap(err, "error writing to the process")
+		}
+	}
+	switch sig := strings.TrimPrefix(strings.ToUpper(input.Signal), "SIG"); sig {
+	case "":
+	case "EOF":
+		if err := p.stdin.Close(); err != nil {
+			return "", errors.WithStack(err)
+		}
+	case "TERM":
+		p.stop()
+	default:
+		if err := signalProcessGroup(p.cmd, sig); err != nil {
+			return "", err
+		}
+	}
+	p.wait(ctx, 200*time.Millisecond)
+	return p.report(), nil
+}
+
+// ProcessList lists the background processes.
+func ProcessList(ctx context.Context, _ struct{}) (string, error) {
+	ps, err := processesOf(ctx)
+	if err != nil {
+		return "", err
+	}
+	return ps.list(), nil
+}
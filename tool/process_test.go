@@ -0,0 +1,84 @@
+package tool
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessOutputIsIncremental(t *testing.T) {
+	ps := NewProcesses()
+	defer ps.Close()
+	ctx := ContextWithProcesses(context.Background(), ps)
+
+	out, err := ProcessStart(ctx, ProcessStartInput{Command: "echo started; read line; echo got $line; sleep 30"})
+	require.NoError(t, err)
+	assert.Equal(t, "[process 1: echo started; read line; echo got $line; sleep 30, running]\nstarted\n", out)
+
+	out, err = ProcessSend(ctx, ProcessSendInput{ID: 1, Input: "hello\n"})
+	require.NoError(t, err)
+	if !strings.Contains(out, "got hello") {
+		out, err = ProcessOutput(ctx, ProcessOutputInput{ID: 1, Wait: 5})
+		require.NoError(t, err)
+	}
+	assert.Contains(t, out, "got hello\n")
+	assert.NotContains(t, out, "\nstarted\n", "output is only returned once")
+
+	out, err = ProcessSend(ctx, ProcessSendInput{ID: 1, Signal: "TERM"})
+	require.NoError(t, err)
+	assert.Contains(t, out, "exited with code -1")
+
+	out, err = ProcessList(ctx, struct{}{})
+	require.NoError(t, err)
+	assert.Contains(t, out, "1: echo started")
+
+	_, err = ProcessOutput(ctx, ProcessOutputInput{ID: 7})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no background process 7")
+}
+
+func TestProcessExitCode(t *testing.T) {
+	ps := NewProcesses()
+	defer ps.Close()
+	ctx := ContextWithProcesses(context.Background(), ps)
+
+	_, err := ProcessStart(ctx, ProcessStartInput{Command: "echo bye; exit 4", Wait: 5})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		out, err := ProcessOutput(ctx, ProcessOutputInput{ID: 1})
+		return err == nil && strings.Contains(out, "exited with code 4")
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestProcessesCloseStopsEverything(t *testing.T) {
+	ps := NewProcesses()
+	ctx := ContextWithProcesses(context.Background(), ps)
+
+	// The first process ignores SIGTERM and has to be killed.
+	_, err := ProcessStart(ctx, ProcessStartInput{Command: "trap '' TERM; sleep 30 & wait"})
+	require.NoError(t, err)
+	_, err = ProcessStart(ctx, ProcessStartInput{Command: "sleep 30"})
+	require.NoError(t, err)
+
+	start := time.Now()
+	ps.Close()
+	assert.Less(t, time.Since(start), 10*time.Second)
+	for _, id := range []int{1, 2} {
+		p, err := ps.get(id)
+		require.NoError(t, err)
+		assert.NotEqual(t, "running", p.status())
+	}
+
+	_, err = ProcessStart(ctx, ProcessStartInput{Command: "true"})
+	require.Error(t, err)
+}
+
+func TestProcessToolsNeedARegistry(t *testing.T) {
+	_, err := ProcessStart(context.Background(), ProcessStartInput{Command: "true"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not available")
+}
@@ -0,0 +1,26 @@
+//go:build !unix
+
+package tool
+
+import (
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// setProcessGroup does nothing where process groups are not supported.
+func setProcessGroup(_ *exec.Cmd) {}
+
+// killProcessGroup kills only the started cmd itself.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// signalProcessGroup can only kill the started cmd itself; other signals are
+// not supported.
+func signalProcessGroup(cmd *exec.Cmd, name string) error {
+	if name != "KILL" && name != "TERM" {
+		return errors.Errorf("the signal %q is not supported on this platform", name)
+	}
+	return errors.WithStack(cmd.Process.Kill())
+}
@@ -0,0 +1,42 @@
+//go:build unix
+
+package tool
+
+import (
+	"os/exec"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// signals are the signals that can be sent to background processes.
+var signals = map[string]syscall.Signal{
+	"INT":  syscall.SIGINT,
+	"TERM": syscall.SIGTERM,
+	"KILL": syscall.SIGKILL,
+	"HUP":  syscall.SIGHUP,
+}
+
+// setProcessGroup makes cmd the leader of a new process group, so the
+// processes it starts can be killed with it.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// killProcessGroup kills the started cmd and every process of its group.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// signalProcessGroup sends the named signal (INT, TERM, KILL or HUP) to every
+// process of the group of the started cmd.
+func signalProcessGroup(cmd *exec.Cmd, name string) error {
+	sig, ok := signals[name]
+	if !ok {
+		return errors.Errorf("unknown signal %q, use INT, TERM, KILL or HUP", name)
+	}
+	return errors.WithStack(syscall.Kill(-cmd.Process.Pid, sig))
+}
@@ -8,32 +8,73 @@ import (
 
 func AllTools() (res []llm.Tool) {
 	res = append(res, llm.NewTool[GitInput]("git", "Execute any git command in the local repository", func(ctx context.Context, input GitInput) (string, error) {
-		return Git(input), nil
+		return Git(ctx, input), nil
 	}))
 
-	res = append(res, llm.NewTool[CatInput]("cat", "Read file content with optional offset and line limits", func(ctx context.Context, input CatInput) (string, error) {
+	res = append(res, llm.Parallel(llm.NewTool[CatInput]("cat", "Read file content with optional offset and line limits", func(ctx context.Context, input CatInput) (string, error) {
+		if err := confine(ctx, &input.Path); err != nil {
+			return "", err
+		}
 		return Cat(input), nil
-	}))
+	})))
 
-	res = append(res, llm.NewTool[FileListInput]("files", "List files in a directory, with options for recursive listing and pattern matching", func(ctx context.Context, input FileListInput) (string, error) {
+	res = append(res, llm.Parallel(llm.NewTool[FileListInput]("files", "List files in a directory, with options for recursive listing, depth limits, pattern matching and a tree view. Files ignored by .gitignore or .raiignore are skipped, and long listings are truncated.", func(ctx context.Context, input FileListInput) (string, error) {
+		if err := confine(ctx, &input.Path); err != nil {
+			return "", err
+		}
 		return ListFiles(input), nil
+	})))
+
+	res = append(res, llm.Parallel(llm.NewTool[GrepInput]("grep", "Search the contents of files for a regular expression, with optional context lines. Files ignored by .gitignore or .raiignore, hidden directories and binary files are skipped. Prefer this over running grep with bash.", Grep)))
+
+	res = append(res, llm.NewTool[EditInput]("edit", "Edit a file by replacing an exact string with another one, and show the diff of the change. This is the preferred way to change existing files.", func(ctx context.Context, input EditInput) (string, error) {
+		if err := confine(ctx, &input.Path); err != nil {
+			return "", err
+		}
+		return Edit(input)
 	}))
 
-	res = append(res, llm.NewTool[CreateInput]("create", "Create a file with the specified content and path ", func(ctx context.Context, input CreateInput) (string, error) {
+	res = append(res, llm.NewTool[CreateInput]("create", "Create a file with the specified content and path, overwriting it if it exists. Use edit to change existing files.", func(ctx context.Context, input CreateInput) (string, error) {
+		if err := confine(ctx, &input.Path); err != nil {
+			return "", err
+		}
 		return Create(input)
 	}))
 
 	res = append(res, llm.NewTool[InsertInput]("insert", "Insert additional content to a file from a specific line", func(ctx context.Context, input InsertInput) (string, error) {
+		if err := confine(ctx, &input.Path); err != nil {
+			return "", err
+		}
 		return Insert(input)
 	}))
 
+	res = append(res, llm.NewTool[PatchInput]("patch", "Apply a unified diff or an apply_patch document that changes, adds, deletes or moves one or more files. Every hunk is checked before anything is written: either all files are changed, or none.", Patch))
+
 	res = append(res, llm.NewTool[BashInput]("bash", "Execute any bash command", func(ctx context.Context, input BashInput) (string, error) {
-		return Bash(input), nil
+		return Bash(ctx, input), nil
 	}))
 
-	res = append(res, llm.NewTool[SkillInput]("skill", SkillToolDescription(), func(ctx context.Context, input SkillInput) (string, error) {
+	res = append(res, llm.NewTool[ProcessStartInput]("process-start", "Start a long-running command (a dev server, a test watcher, ...) in the background and return its id with its first output. Use process-output to read what it writes later, and process-send to give it input or stop it.", ProcessStart))
+
+	res = append(res, llm.NewTool[ProcessOutputInput]("process-output", "Read the output a background process wrote since the last read, and whether it still runs", ProcessOutput))
+
+	res = append(res, llm.NewTool[ProcessSendInput]("process-send", "Write to the standard input of a background process, or send it a signal (TERM stops it)", ProcessSend))
+
+	res = append(res, llm.NewTool[struct{}]("process-list", "List the background processes with their ids and status", ProcessList))
+
+	res = append(res, llm.Parallel(llm.NewTool[SkillInput]("skill", SkillToolDescription(), func(ctx context.Context, input SkillInput) (string, error) {
 		return Skill(input), nil
-	}))
+	})))
 
 	return res
 }
+
+// confine resolves *path in the workspace of ctx, failing if it is outside.
+func confine(ctx context.Context, path *string) error {
+	resolved, err := WorkspaceFrom(ctx).Resolve(*path)
+	if err != nil {
+		return err
+	}
+	*path = resolved
+	return nil
+}
@@ -0,0 +1,106 @@
+//go:build linux
+
+package tool
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// startSandboxed starts cmd in new user and network namespaces, so it has no
+// network access, and under a landlock ruleset that lets it read everything
+// but write only below root, the temp directory, and /dev/null.
+func startSandboxed(cmd *exec.Cmd, root string) error {
+	// No uid/gid mappings are set up: writing them would be denied by the
+	// ruleset below, which already applies to the parent. Files are still
+	// created with the user's ids; only inside they show as the overflow id.
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET
+
+	started := make(chan error, 1)
+	go func() {
+		// Landlock restricts the calling thread and the processes it starts.
+		// The thread stays locked, so it exits with this goroutine instead of
+		// running others with the restrictions.
+		runtime.LockOSThread()
+		if err := landlock(root, os.TempDir()); err != nil {
+			started <- err
+			return
+		}
+		started <- cmd.Start()
+	}()
+	return <-started
+}
+
+// landlock restricts the current thread to write only below the writable
+// directories (and to /dev/null).
+func landlock(writable ...string) error {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return errors.Wrap(errno, "landlock is not available")
+	}
+
+	const read = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+	write := uint64(unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_REMOVE_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR | unix.LANDLOCK_ACCESS_FS_MAKE_DIR | unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK | unix.LANDLOCK_ACCESS_FS_MAKE_FIFO | unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM)
+	fileWrite := uint64(unix.LANDLOCK_ACCESS_FS_WRITE_FILE)
+	if abi >= 2 {
+		write |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		write |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+		fileWrite |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+
+	attr := unix.LandlockRulesetAttr{Access_fs: read | write}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return errors.Wrap(errno, "couldn't create landlock ruleset")
+	}
+	defer unix.Close(int(fd))
+
+	if err := landlockAllow(int(fd), "/", read); err != nil {
+		return err
+	}
+	for _, dir := range writable {
+		if err := landlockAllow(int(fd), dir, read|write); err != nil {
+			return err
+		}
+	}
+	if err := landlockAllow(int(fd), "/dev/null", unix.LANDLOCK_ACCESS_FS_READ_FILE|fileWrite); err != nil {
+		return err
+	}
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return errors.WithStack(err)
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0); errno != 0 {
+		return errors.Wrap(errno, "couldn't apply landlock ruleset")
+	}
+	return nil
+}
+
+// landlockAllow adds a rule granting access below path to the ruleset.
+func landlockAllow(ruleset int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't open %s", path)
+	}
+	defer unix.Close(fd)
+	rule := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&rule)), 0, 0, 0)
+	if errno != 0 {
+		return errors.Wrapf(errno, "couldn't add landlock rule for %s", path)
+	}
+	return nil
+}
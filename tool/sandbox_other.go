@@ -0,0 +1,14 @@
+//go:build !linux
+
+package tool
+
+import (
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// startSandboxed is only supported on Linux.
+func startSandboxed(_ *exec.Cmd, _ string) error {
+	return errors.New("the bash sandbox is only supported on Linux")
+}
@@ -0,0 +1,147 @@
+package tool
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Workspace is the directory tree the file and shell tools are confined to.
+// Relative paths are resolved against its root, and paths outside of it,
+// including symlinks that point outside, are rejected. Shell commands run in
+// the root.
+type Workspace struct {
+	// Root is the absolute path of the workspace, with symlinks resolved.
+	Root string
+	// Sandbox runs bash and git commands in a Linux sandbox without network
+	// access, which may write only to the workspace and the temp directory.
+	Sandbox bool
+}
+
+// NewWorkspace creates a workspace rooted at the directory root.
+func NewWorkspace(root string, sandbox bool) (*Workspace, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid workspace")
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid workspace")
+	}
+	if !info.IsDir() {
+		return nil, errors.Errorf("workspace %s is not a directory", root)
+	}
+	return &Workspace{Root: resolved, Sandbox: sandbox}, nil
+}
+
+// Resolve returns the absolute path of path, which is relative to the root
+// unless it is absolute. It fails if the path, with its symlinks resolved,
+// lies outside the workspace. The path doesn't need to exist; a file to be
+// created is checked through its nearest existing parent directory. A nil
+// workspace confines nothing and returns path as it is.
+func (w *Workspace) Resolve(path string) (string, error) {
+	if w == nil || path == "" {
+		return path, nil
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(w.Root, path)
+	}
+	resolved, err := evalExisting(path)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if !w.contains(resolved) {
+		return "", errors.Errorf("%s is outside of the workspace %s", path, w.Root)
+	}
+	return filepath.Clean(path), nil
+}
+
+// Dir returns the directory commands run in: the root, or, for a nil
+// workspace, the empty string, which is the current directory.
+func (w *Workspace) Dir() string {
+	if w == nil {
+		return ""
+	}
+	return w.Root
+}
+
+// contains reports whether the clean absolute path is the root or below it.
+func (w *Workspace) contains(path string) bool {
+	return path == w.Root || strings.HasPrefix(path, strings.TrimSuffix(w.Root, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// evalExisting resolves the symlinks of the longest existing prefix of path
+// and appends the rest, which can't contain symlinks as it doesn't exist.
+func evalExisting(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		return resolved, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	dir, base := filepath.Split(path)
+	dir = filepath.Clean(dir)
+	if dir == path {
+		return path, nil
+	}
+	parent, err := evalExisting(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(parent, base), nil
+}
+
+type workspaceKey struct{}
+
+// ContextWithWorkspace returns a context that confines the tools run with it to
+// w. A nil w leaves them unconfined.
+func ContextWithWorkspace(ctx context.Context, w *Workspace) context.Context {
+	if w == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, workspaceKey{}, w)
+}
+
+// WorkspaceFrom returns the workspace of ctx, or nil if the tools are not
+// confined.
+func WorkspaceFrom(ctx context.Context) *Workspace {
+	w, _ := ctx.Value(workspaceKey{}).(*Workspace)
+	return w
+}
+
+// WithWorkspace holds the command line flags that confine the tools.
+type WithWorkspace struct {
+	Workspace string `help:"Confine the file and shell tools to this directory"`
+	Sandbox   bool   `help:"Run bash and git commands without network access, writing only to the workspace (Linux only; implies --workspace=.)"`
+}
+
+// WorkspaceContext returns ctx confined to the workspace selected by the
+// flags, or ctx itself when there is none.
+func (w WithWorkspace) WorkspaceContext(ctx context.Context) (context.Context, error) {
+	ws, err := w.GetWorkspace()
+	if err != nil {
+		return nil, err
+	}
+	return ContextWithWorkspace(ctx, ws), nil
+}
+
+// GetWorkspace returns the workspace selected by the flags, or nil when there
+// is none.
+func (w WithWorkspace) GetWorkspace() (*Workspace, error) {
+	root := w.Workspace
+	if root == "" {
+		if !w.Sandbox {
+			return nil, nil
+		}
+		root = "."
+	}
+	return NewWorkspace(root, w.Sandbox)
+}
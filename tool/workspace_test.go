@@ -0,0 +1,139 @@
+package tool
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elek/rai/llm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestWorkspace creates a workspace holding src/main.go, next to a
+// directory outside of it, and returns both.
+func newTestWorkspace(t *testing.T, sandbox bool) (*Workspace, string) {
+	t.Helper()
+	base := t.TempDir()
+	root := filepath.Join(base, "ws")
+	outside := filepath.Join(base, "outside")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "src"), 0o755))
+	require.NoError(t, os.MkdirAll(outside, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "src", "main.go"), []byte("package main\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret"), []byte("secret\n"), 0o644))
+	ws, err := NewWorkspace(root, sandbox)
+	require.NoError(t, err)
+	return ws, outside
+}
+
+func TestWorkspaceResolve(t *testing.T) {
+	ws, outside := newTestWorkspace(t, false)
+	require.NoError(t, os.Symlink(outside, filepath.Join(ws.Root, "escape")))
+	require.NoError(t, os.Symlink("src", filepath.Join(ws.Root, "inside")))
+
+	for path, want := range map[string]string{
+		"src/main.go":                            filepath.Join(ws.Root, "src", "main.go"),
+		"src/new/file.go":                        filepath.Join(ws.Root, "src", "new", "file.go"),
+		filepath.Join(ws.Root, "src", "x"):       filepath.Join(ws.Root, "src", "x"),
+		"inside/main.go":                         filepath.Join(ws.Root, "inside", "main.go"),
+		".":                                      ws.Root,
+		"src/../src/main.go":                     filepath.Join(ws.Root, "src", "main.go"),
+		"src/missing/../../src/main.go":          filepath.Join(ws.Root, "src", "main.go"),
+		filepath.Join(ws.Root, "src", "..", "x"): filepath.Join(ws.Root, "x"),
+	} {
+		got, err := ws.Resolve(path)
+		if assert.NoError(t, err, path) {
+			assert.Equal(t, want, got, path)
+		}
+	}
+
+	for _, path := range []string{
+		"../outside/secret",
+		filepath.Join(outside, "secret"),
+		"escape/secret",
+		"escape/new-file",
+		"src/missing/../../../outside/secret",
+		"/etc/passwd",
+	} {
+		_, err := ws.Resolve(path)
+		if assert.Error(t, err, path) {
+			assert.Contains(t, err.Error(), "outside of the workspace")
+		}
+	}
+}
+
+func TestNilWorkspaceConfinesNothing(t *testing.T) {
+	var ws *Workspace
+	got, err := ws.Resolve("/etc/passwd")
+	require.NoError(t, err)
+	assert.Equal(t, "/etc/passwd", got)
+	assert.Equal(t, "", ws.Dir())
+}
+
+func TestToolsAreConfinedToTheWorkspace(t *testing.T) {
+	ws, outside := newTestWorkspace(t, false)
+	ctx := ContextWithWorkspace(context.Background(), ws)
+	tools := map[string]llm.Tool{}
+	for _, tl := range AllTools() {
+		tools[tl.Info().Name] = tl
+	}
+
+	res, err := tools["cat"].Run(ctx, llm.ToolCall{Input: `{"path":"src/main.go"}`})
+	require.NoError(t, err)
+	assert.False(t, res.IsError)
+	assert.Contains(t, res.Content, "package main")
+
+	res, err = tools["cat"].Run(ctx, llm.ToolCall{Input: `{"path":"` + filepath.Join(outside, "secret") + `"}`})
+	require.NoError(t, err)
+	assert.True(t, res.IsError)
+	assert.Contains(t, res.Content, "outside of the workspace")
+
+	res, err = tools["create"].Run(ctx, llm.ToolCall{Input: `{"path":"../outside/new","file_text":"x"}`})
+	require.NoError(t, err)
+	assert.True(t, res.IsError)
+	assert.NoFileExists(t, filepath.Join(outside, "new"))
+
+	res, err = tools["bash"].Run(ctx, llm.ToolCall{Input: `{"command":"pwd"}`})
+	require.NoError(t, err)
+	assert.Equal(t, ws.Root, strings.TrimSpace(res.Content), "commands run in the workspace")
+}
+
+func TestSandboxedBash(t *testing.T) {
+	ws, outside := newTestWorkspace(t, true)
+	t.Setenv("TMPDIR", filepath.Join(filepath.Dir(outside), "ws", "src"))
+	ctx := ContextWithWorkspace(context.Background(), ws)
+
+	out := Bash(ctx, BashInput{Command: "echo hi > out.txt && cat out.txt"})
+	if strings.Contains(out, "landlock is not available") || strings.Contains(out, "operation not permitted") {
+		t.Skip("the sandbox is not available here: " + out)
+	}
+	assert.Equal(t, "hi\n", out)
+
+	out = Bash(ctx, BashInput{Command: "cat " + filepath.Join(outside, "secret") + " && echo x > " + filepath.Join(outside, "new")})
+	assert.Contains(t, out, "secret", "files outside the workspace can be read")
+	assert.Contains(t, out, "Permission denied")
+	assert.NoFileExists(t, filepath.Join(outside, "new"))
+
+	out = Bash(ctx, BashInput{Command: "grep -c : /proc/net/dev"})
+	assert.Equal(t, "1\n", out, "only the loopback interface exists")
+}
+
+func TestSandboxedGit(t *testing.T) {
+	ws, outside := newTestWorkspace(t, true)
+	t.Setenv("TMPDIR", filepath.Join(filepath.Dir(outside), "ws", "src"))
+	ctx := ContextWithWorkspace(context.Background(), ws)
+
+	out := Git(ctx, GitInput{Command: "git init -q"})
+	if strings.Contains(out, "landlock is not available") || strings.Contains(out, "operation not permitted") {
+		t.Skip("the sandbox is not available here: " + out)
+	}
+	assert.Empty(t, out)
+
+	marker := filepath.Join(outside, "marker")
+	Git(ctx, GitInput{Command: `git -c "alias.x=!touch ` + marker + `" x`})
+	assert.NoFileExists(t, marker, "programs started by git are sandboxed")
+	Git(ctx, GitInput{Command: "git -C " + outside + " init -q"})
+	assert.NoDirExists(t, filepath.Join(outside, ".git"))
+}